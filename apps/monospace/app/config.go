@@ -10,10 +10,16 @@ package app
 import (
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -36,22 +42,292 @@ type MonospaceConfigTask struct {
 	CacheMaxEntries int               `yaml:"cache_max_entries,omitempty"` // 0 = use global default
 	Inputs          []string          `yaml:"inputs,omitempty"`
 	Outputs         []string          `yaml:"outputs,omitempty"`
+	GlobalInputs    []string          `yaml:"global_inputs,omitempty"`    // globs relative to the monospace root
+	HashEnv         []string          `yaml:"hash_env,omitempty"`         // env variables whose values are part of the cache key
+	PassThroughEnv  []string          `yaml:"pass_through_env,omitempty"` // env variables read by the task whose values are part of the cache key
+	CacheFailures   bool              `yaml:"cache_failures,omitempty"`   // also cache failed runs and replay them on hits
+	Exclusive       bool              `yaml:"exclusive,omitempty"`        // never run concurrently with other tasks
+	Retries         int               `yaml:"retries,omitempty"`          // number of retries after a failed attempt
+	RetryDelay      string            `yaml:"retry_delay,omitempty"`      // wait between attempts ie: "5s"
+	Timeout         string            `yaml:"timeout,omitempty"`          // kill attempts running longer ie: "10m"
+	// condition for dependents of a persistent task to start
+	Ready       *MonospaceConfigTaskReady `yaml:"ready,omitempty"`
+	GracePeriod string                    `yaml:"grace_period,omitempty"` // wait between SIGTERM and SIGKILL of persistent tasks ie: "5s"
+	// named parameters given with --param name=value
+	Params map[string]MonospaceConfigTaskParam `yaml:"params,omitempty"`
+}
+
+// MonospaceConfigTaskParam is a named parameter of a task, its value replaces
+// ${name} in the task cmd and env values
+type MonospaceConfigTaskParam struct {
+	Description string   `yaml:"description,omitempty"`
+	Default     string   `yaml:"default,omitempty"`      // params without default are required
+	Choices     []string `yaml:"choices,omitempty,flow"` // allowed values, any value when empty
+}
+
+// MonospaceConfigTaskReady is the condition a persistent task must meet for
+// its dependents to start, only one of output, tcp or http can be set
+type MonospaceConfigTaskReady struct {
+	Output  string `yaml:"output,omitempty"`  // regexp matched against each output line
+	Tcp     string `yaml:"tcp,omitempty"`     // local port or address accepting connections ie: 3000
+	Http    string `yaml:"http,omitempty"`    // local url answering a non error status ie: http://localhost:3000/health
+	Timeout string `yaml:"timeout,omitempty"` // max wait for the condition to be met ie: "2m"
+}
+type MonospaceConfigRemoteCache struct {
+	Url     string            `yaml:"url,omitempty"`     // http(s) url or path to a shared directory
+	Mode    string            `yaml:"mode,omitempty"`    // "read-write" | "read-only" | "" (read-write)
+	Headers map[string]string `yaml:"headers,omitempty"` // http headers sent to the server, values are env expanded
 }
 type MonospaceConfig struct {
-	GoModPrefix         string                         `yaml:"go_mod_prefix,omitempty"`
-	JSPM                string                         `yaml:"js_package_manager,omitempty"`
-	PreferredOutputMode string                         `yaml:"preferred_output_mode,omitempty"`
-	CacheMaxEntries     int                            `yaml:"cache_max_entries,omitempty"` // global default, 0 = use DefaultCacheMaxEntries
-	Projects            map[string]string              `yaml:"projects,omitempty"`
-	Aliases             map[string]string              `yaml:"projects_aliases,omitempty"`
-	Pipeline            map[string]MonospaceConfigTask `yaml:"pipeline,omitempty"`
-	configPath          string
-	root                string
+	GoModPrefix          string                         `yaml:"go_mod_prefix,omitempty"`
+	JSPM                 string                         `yaml:"js_package_manager,omitempty"`
+	PreferredOutputMode  string                         `yaml:"preferred_output_mode,omitempty"`
+	CacheMaxEntries      int                            `yaml:"cache_max_entries,omitempty"` // global default, 0 = use DefaultCacheMaxEntries
+	RemoteCache          *MonospaceConfigRemoteCache    `yaml:"remote_cache,omitempty"`
+	GlobalInputs         []string                       `yaml:"global_inputs,omitempty"`      // added to every cached task
+	HashEnv              []string                       `yaml:"hash_env,omitempty"`           // added to every cached task
+	PassThroughEnv       []string                       `yaml:"pass_through_env,omitempty"`   // added to every cached task
+	CacheGitLsFiles      bool                           `yaml:"cache_git_ls_files,omitempty"` // list cache inputs with git ls-files
+	CacheMaxSize         string                         `yaml:"cache_max_size,omitempty"`     // total local cache size limit ie: "5GB"
+	CacheMaxAge          string                         `yaml:"cache_max_age,omitempty"`      // entries unused for longer are evicted ie: "30d"
+	MaxConcurrency       string                         `yaml:"max_concurrency,omitempty"`    // max concurrent jobs ie: "4" or "50%" of cpus
+	LogsMaxRuns          int                            `yaml:"logs_max_runs,omitempty"`      // runs logs kept, 0 = use DefaultLogsMaxRuns, negative disables logs
+	Projects             map[string]string              `yaml:"projects,omitempty"`
+	Aliases              map[string]string              `yaml:"projects_aliases,omitempty"`
+	ProjectsDependencies map[string][]string            `yaml:"projects_dependencies,omitempty"`
+	Pipeline             map[string]MonospaceConfigTask `yaml:"pipeline,omitempty"`
+	configPath           string
+	root                 string
 }
 
 var appConfig *MonospaceConfig
 
 var ErrNotLoadedConfig = errors.New("config not loaded")
+var ErrInvalidCacheBudget = errors.New("invalid cache budget")
+var ErrInvalidConcurrency = errors.New("invalid concurrency")
+var ErrInvalidTaskConfig = errors.New("invalid task config")
+var ErrInvalidTaskParam = errors.New("invalid task param")
+
+var paramNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+var sizeRegexp = regexp.MustCompile(`(?i)^(\d+(?:\.\d+)?)\s*([KMGT]?)(?:i?B)?$`)
+var sizeUnits = map[string]float64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+
+// GetCacheMaxSize returns cache_max_size in bytes or 0 when not set.
+// Units are powers of 1024 (K, KB, KiB, M, MB, MiB, G...)
+func (c *MonospaceConfig) GetCacheMaxSize() (int64, error) {
+	if c.CacheMaxSize == "" {
+		return 0, nil
+	}
+	matches := sizeRegexp.FindStringSubmatch(strings.TrimSpace(c.CacheMaxSize))
+	if matches == nil {
+		return 0, fmt.Errorf("%w: cache_max_size %q, expected a size like 500MB or 5GB", ErrInvalidCacheBudget, c.CacheMaxSize)
+	}
+	value, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: cache_max_size %q: %s", ErrInvalidCacheBudget, c.CacheMaxSize, err)
+	}
+	return int64(value * sizeUnits[strings.ToUpper(matches[2])]), nil
+}
+
+// GetCacheMaxAge returns cache_max_age as a duration or 0 when not set.
+// Accepts go durations (ie: 12h) and days or weeks (ie: 30d, 2w)
+func (c *MonospaceConfig) GetCacheMaxAge() (time.Duration, error) {
+	if strings.TrimSpace(c.CacheMaxAge) == "" {
+		return 0, nil
+	}
+	if duration, ok := ParseAge(c.CacheMaxAge); ok {
+		return duration, nil
+	}
+	return 0, fmt.Errorf("%w: cache_max_age %q, expected a duration like 12h, 30d or 2w", ErrInvalidCacheBudget, c.CacheMaxAge)
+}
+
+// ParseAge parses a strictly positive go duration (ie: 12h) or a number of
+// days or weeks (ie: 30d, 2w).
+func ParseAge(age string) (time.Duration, bool) {
+	age = strings.TrimSpace(age)
+	unit := time.Duration(0)
+	if days, ok := strings.CutSuffix(age, "d"); ok {
+		age, unit = days, 24*time.Hour
+	} else if weeks, ok := strings.CutSuffix(age, "w"); ok {
+		age, unit = weeks, 7*24*time.Hour
+	}
+	if unit != 0 {
+		value, err := strconv.ParseFloat(age, 64)
+		if err == nil && value > 0 {
+			return time.Duration(value * float64(unit)), true
+		}
+	} else if duration, err := time.ParseDuration(age); err == nil && duration > 0 {
+		return duration, true
+	}
+	return 0, false
+}
+
+// GetRetries returns the number of retries after a failed attempt
+func (t MonospaceConfigTask) GetRetries() (int, error) {
+	if t.Retries < 0 {
+		return 0, fmt.Errorf("%w: retries %d, expected a positive number", ErrInvalidTaskConfig, t.Retries)
+	}
+	return t.Retries, nil
+}
+
+// GetRetryDelay returns retry_delay as a duration or 0 when not set
+func (t MonospaceConfigTask) GetRetryDelay() (time.Duration, error) {
+	return parseTaskDuration("retry_delay", t.RetryDelay)
+}
+
+// GetTimeout returns timeout as a duration or 0 when not set
+func (t MonospaceConfigTask) GetTimeout() (time.Duration, error) {
+	return parseTaskDuration("timeout", t.Timeout)
+}
+
+// GetGracePeriod returns grace_period as a duration or DefaultGracePeriod when
+// not set
+func (t MonospaceConfigTask) GetGracePeriod() (time.Duration, error) {
+	if strings.TrimSpace(t.GracePeriod) == "" {
+		return DefaultGracePeriod, nil
+	}
+	return parseTaskDuration("grace_period", t.GracePeriod)
+}
+
+// Validate checks the param name and that its default is one of its choices
+func (p MonospaceConfigTaskParam) Validate(name string) error {
+	if !paramNameRegexp.MatchString(name) {
+		return fmt.Errorf("%w: param name %q, expected letters, digits and underscores", ErrInvalidTaskConfig, name)
+	}
+	if p.Default != "" && len(p.Choices) > 0 && !slices.Contains(p.Choices, p.Default) {
+		return fmt.Errorf("%w: param %s default %q is not one of its choices", ErrInvalidTaskConfig, name, p.Default)
+	}
+	return nil
+}
+
+// GetValue returns the given value of the param or its default when not set.
+// It returns an error when a required param is not set or when the value is
+// not one of the param choices.
+func (p MonospaceConfigTaskParam) GetValue(name string, value string, isSet bool) (string, error) {
+	if !isSet {
+		if p.Default == "" {
+			return "", fmt.Errorf("%w: %s is required, use --param %s=<value>", ErrInvalidTaskParam, name, name)
+		}
+		value = p.Default
+	}
+	if len(p.Choices) > 0 && !slices.Contains(p.Choices, value) {
+		return "", fmt.Errorf("%w: %s=%s, expected one of %s", ErrInvalidTaskParam, name, value, strings.Join(p.Choices, ", "))
+	}
+	return value, nil
+}
+
+// GetTimeout returns the ready timeout as a duration or DefaultReadyTimeout
+// when not set
+func (r *MonospaceConfigTaskReady) GetTimeout() (time.Duration, error) {
+	if strings.TrimSpace(r.Timeout) == "" {
+		return DefaultReadyTimeout, nil
+	}
+	return parseTaskDuration("ready timeout", r.Timeout)
+}
+
+// GetTcpAddress returns the address to connect to for a tcp condition, a port
+// alone is an address on localhost
+func (r *MonospaceConfigTaskReady) GetTcpAddress() (string, error) {
+	address := strings.TrimSpace(r.Tcp)
+	if _, err := strconv.ParseUint(address, 10, 16); err == nil {
+		return net.JoinHostPort("localhost", address), nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err == nil {
+		_, err = strconv.ParseUint(port, 10, 16)
+	}
+	if err != nil || !isLocalHost(host) {
+		return "", fmt.Errorf("%w: ready tcp %q, expected a port or a local address like localhost:3000", ErrInvalidTaskConfig, r.Tcp)
+	}
+	return address, nil
+}
+
+// Validate checks that exactly one valid condition is set
+func (r *MonospaceConfigTaskReady) Validate() error {
+	conditions := 0
+	if r.Output != "" {
+		conditions++
+		if _, err := regexp.Compile(r.Output); err != nil {
+			return fmt.Errorf("%w: ready output: %s", ErrInvalidTaskConfig, err)
+		}
+	}
+	if r.Tcp != "" {
+		conditions++
+		if _, err := r.GetTcpAddress(); err != nil {
+			return err
+		}
+	}
+	if r.Http != "" {
+		conditions++
+		u, err := url.Parse(r.Http)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !isLocalHost(u.Hostname()) {
+			return fmt.Errorf("%w: ready http %q, expected a local url like http://localhost:3000", ErrInvalidTaskConfig, r.Http)
+		}
+	}
+	if conditions != 1 {
+		return fmt.Errorf("%w: ready expects one of output, tcp or http", ErrInvalidTaskConfig)
+	}
+	_, err := r.GetTimeout()
+	return err
+}
+
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func parseTaskDuration(key string, value string) (time.Duration, error) {
+	if strings.TrimSpace(value) == "" {
+		return 0, nil
+	}
+	if duration, ok := ParseAge(value); ok {
+		return duration, nil
+	}
+	return 0, fmt.Errorf("%w: %s %q, expected a duration like 30s or 10m", ErrInvalidTaskConfig, key, value)
+}
+
+// GetMaxConcurrency returns max_concurrency as a number of jobs or 0 when not set.
+func (c *MonospaceConfig) GetMaxConcurrency() (int, error) {
+	n, err := ParseConcurrency(c.MaxConcurrency)
+	if err != nil {
+		return 0, fmt.Errorf("max_concurrency: %w", err)
+	}
+	return n, nil
+}
+
+// GetLogsMaxRuns returns the number of runs logs to keep, 0 when logs are
+// disabled.
+func (c *MonospaceConfig) GetLogsMaxRuns() int {
+	if c.LogsMaxRuns < 0 {
+		return 0
+	}
+	if c.LogsMaxRuns == 0 {
+		return DefaultLogsMaxRuns
+	}
+	return c.LogsMaxRuns
+}
+
+// ParseConcurrency parses a strictly positive number of jobs (ie: 4) or a
+// percentage of the number of cpus (ie: 50%), percentages always give at least
+// one job. Empty value returns 0.
+func ParseConcurrency(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	percent, isPercent := strings.CutSuffix(value, "%")
+	n, err := strconv.Atoi(strings.TrimSpace(percent))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%w %q, expected a number of jobs like 4 or a percentage of cpus like 50%%", ErrInvalidConcurrency, value)
+	}
+	if isPercent {
+		n = max(1, runtime.NumCPU()*n/100)
+	}
+	return n, nil
+}
 
 func fileExists(filePath string) (bool, error) {
 	_, err := os.Stat(filePath)
@@ -213,6 +489,18 @@ func ConfigRemoveProjectAlias(alias string, save bool) error {
 		return nil
 	}
 	delete(config.Aliases, alias)
+	// update projects dependencies that use the alias
+	if deps, ok := config.ProjectsDependencies[alias]; ok {
+		delete(config.ProjectsDependencies, alias)
+		config.ProjectsDependencies[projectName] = append(config.ProjectsDependencies[projectName], deps...)
+	}
+	for k, deps := range config.ProjectsDependencies {
+		for i, dep := range deps {
+			if dep == alias {
+				config.ProjectsDependencies[k][i] = projectName
+			}
+		}
+	}
 	// update pipeline: rename task keys and dependsOn entries that use the alias
 	if len(config.Pipeline) > 0 {
 		aliasPrefix := alias + "#"
@@ -286,6 +574,29 @@ func ConfigAddProject(projectName string, repoUrl string, save bool) error {
 	return err
 }
 
+// replace oldName with newName in projects dependencies
+func ConfigRenameProjectInDependencies(oldName string, newName string, save bool) error {
+	config, err := ConfigGet()
+	if err != nil {
+		return err
+	}
+	if deps, ok := config.ProjectsDependencies[oldName]; ok {
+		delete(config.ProjectsDependencies, oldName)
+		config.ProjectsDependencies[newName] = deps
+	}
+	for _, deps := range config.ProjectsDependencies {
+		for i, dep := range deps {
+			if dep == oldName {
+				deps[i] = newName
+			}
+		}
+	}
+	if save {
+		return ConfigSave()
+	}
+	return nil
+}
+
 func ConfigRemoveProject(projectName string, save bool) error {
 	config, err := ConfigGet()
 	if err != nil {
@@ -293,12 +604,31 @@ func ConfigRemoveProject(projectName string, save bool) error {
 	}
 	delete(config.Projects, projectName)
 	// lookup for aliases to remove
+	removedNames := []string{projectName}
 	for k, v := range config.Aliases {
 		if v == projectName {
+			removedNames = append(removedNames, k)
 			delete(config.Aliases, k)
 			continue
 		}
 	}
+	// remove project from projects dependencies
+	for _, name := range removedNames {
+		delete(config.ProjectsDependencies, name)
+	}
+	for k, deps := range config.ProjectsDependencies {
+		filtered := []string{}
+		for _, dep := range deps {
+			if !slices.Contains(removedNames, dep) {
+				filtered = append(filtered, dep)
+			}
+		}
+		if len(filtered) == 0 {
+			delete(config.ProjectsDependencies, k)
+		} else {
+			config.ProjectsDependencies[k] = filtered
+		}
+	}
 	if save {
 		return ConfigSave()
 	}
@@ -2,12 +2,15 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -277,6 +280,28 @@ func TestConfigRemoveProjectAliasUpdatesPipeline(t *testing.T) {
 	}
 }
 
+func TestConfigRemoveProjectUpdatesDependencies(t *testing.T) {
+	cfg := &MonospaceConfig{
+		GoModPrefix: "test.com",
+		Projects:    map[string]string{"packages/test": "internal", "packages/other": "internal", "apps/web": "internal"},
+		Aliases:     map[string]string{"myalias": "packages/test"},
+		ProjectsDependencies: map[string][]string{
+			"apps/web":       {"myalias", "packages/other"},
+			"packages/other": {"packages/test"},
+			"myalias":        {"packages/other"},
+		},
+	}
+	appConfig = cfg
+
+	if err := ConfigRemoveProject("packages/test", false); err != nil {
+		t.Fatalf("ConfigRemoveProject(): unexpected error: %v", err)
+	}
+	want := map[string][]string{"apps/web": {"packages/other"}}
+	if !reflect.DeepEqual(cfg.ProjectsDependencies, want) {
+		t.Errorf("ConfigRemoveProject(): projects dependencies = %v, want %v", cfg.ProjectsDependencies, want)
+	}
+}
+
 func TestConfigRemoveProjectAliasCollision(t *testing.T) {
 	cfg := &MonospaceConfig{
 		GoModPrefix: "test.com",
@@ -345,3 +370,164 @@ func TestCacheStringValues(t *testing.T) {
 		}
 	}
 }
+
+func TestGetCacheMaxSize(t *testing.T) {
+	cases := map[string]int64{
+		"":       0,
+		"1024":   1024,
+		"10K":    10 << 10,
+		"500MB":  500 << 20,
+		"5GB":    5 << 30,
+		"1.5GiB": 3 << 29,
+		"2 tb":   2 << 40,
+	}
+	for value, expected := range cases {
+		config := &MonospaceConfig{CacheMaxSize: value}
+		if got, err := config.GetCacheMaxSize(); err != nil || got != expected {
+			t.Errorf("GetCacheMaxSize(%q) = %d, %v, want %d", value, got, err, expected)
+		}
+	}
+	for _, value := range []string{"5XB", "GB", "-1GB"} {
+		config := &MonospaceConfig{CacheMaxSize: value}
+		if _, err := config.GetCacheMaxSize(); !errors.Is(err, ErrInvalidCacheBudget) {
+			t.Errorf("GetCacheMaxSize(%q): expected ErrInvalidCacheBudget, got %v", value, err)
+		}
+	}
+}
+
+func TestGetCacheMaxAge(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":    0,
+		"12h": 12 * time.Hour,
+		"30d": 30 * 24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+	}
+	for value, expected := range cases {
+		config := &MonospaceConfig{CacheMaxAge: value}
+		if got, err := config.GetCacheMaxAge(); err != nil || got != expected {
+			t.Errorf("GetCacheMaxAge(%q) = %s, %v, want %s", value, got, err, expected)
+		}
+	}
+	for _, value := range []string{"30", "d", "-1d", "tomorrow"} {
+		config := &MonospaceConfig{CacheMaxAge: value}
+		if _, err := config.GetCacheMaxAge(); !errors.Is(err, ErrInvalidCacheBudget) {
+			t.Errorf("GetCacheMaxAge(%q): expected ErrInvalidCacheBudget, got %v", value, err)
+		}
+	}
+}
+
+func TestParseConcurrency(t *testing.T) {
+	cpus := runtime.NumCPU()
+	cases := map[string]int{
+		"":     0,
+		"4":    4,
+		" 2 ":  2,
+		"100%": cpus,
+		"50%":  max(1, cpus/2),
+		"1%":   1,
+	}
+	for value, expected := range cases {
+		if got, err := ParseConcurrency(value); err != nil || got != expected {
+			t.Errorf("ParseConcurrency(%q) = %d, %v, want %d", value, got, err, expected)
+		}
+	}
+	for _, value := range []string{"0", "-1", "0%", "many", "1.5", "%"} {
+		if _, err := ParseConcurrency(value); !errors.Is(err, ErrInvalidConcurrency) {
+			t.Errorf("ParseConcurrency(%q): expected ErrInvalidConcurrency, got %v", value, err)
+		}
+	}
+}
+
+func TestMaxConcurrencyAcceptsYAMLIntegers(t *testing.T) {
+	var config MonospaceConfig
+	if err := yaml.Unmarshal([]byte("max_concurrency: 3"), &config); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := config.GetMaxConcurrency(); err != nil || n != 3 {
+		t.Errorf("GetMaxConcurrency() = %d, %v, want 3", n, err)
+	}
+}
+
+func TestTaskRetriesAndTimeout(t *testing.T) {
+	task := MonospaceConfigTask{Retries: 2, RetryDelay: "500ms", Timeout: "10m"}
+	retries, errRetries := task.GetRetries()
+	delay, errDelay := task.GetRetryDelay()
+	timeout, errTimeout := task.GetTimeout()
+	if retries != 2 || delay != 500*time.Millisecond || timeout != 10*time.Minute || errRetries != nil || errDelay != nil || errTimeout != nil {
+		t.Errorf("unexpected values %d, %s, %s (%v, %v, %v)", retries, delay, timeout, errRetries, errDelay, errTimeout)
+	}
+	if timeout, err := (MonospaceConfigTask{}).GetTimeout(); timeout != 0 || err != nil {
+		t.Errorf("unset timeout should be 0, got %s, %v", timeout, err)
+	}
+	invalid := MonospaceConfigTask{Retries: -1, RetryDelay: "soon", Timeout: "-1s"}
+	if _, err := invalid.GetRetries(); !errors.Is(err, ErrInvalidTaskConfig) {
+		t.Errorf("expected ErrInvalidTaskConfig for negative retries, got %v", err)
+	}
+	if _, err := invalid.GetRetryDelay(); !errors.Is(err, ErrInvalidTaskConfig) {
+		t.Errorf("expected ErrInvalidTaskConfig for invalid retry_delay, got %v", err)
+	}
+	if _, err := invalid.GetTimeout(); !errors.Is(err, ErrInvalidTaskConfig) {
+		t.Errorf("expected ErrInvalidTaskConfig for negative timeout, got %v", err)
+	}
+}
+
+func TestTaskReady(t *testing.T) {
+	if address, err := (&MonospaceConfigTaskReady{Tcp: "3000"}).GetTcpAddress(); address != "localhost:3000" || err != nil {
+		t.Errorf("a port should be a localhost address, got %s, %v", address, err)
+	}
+	if timeout, err := (&MonospaceConfigTaskReady{}).GetTimeout(); timeout != DefaultReadyTimeout || err != nil {
+		t.Errorf("unset ready timeout should default to %s, got %s, %v", DefaultReadyTimeout, timeout, err)
+	}
+	if grace, err := (MonospaceConfigTask{GracePeriod: "3s"}).GetGracePeriod(); grace != 3*time.Second || err != nil {
+		t.Errorf("unexpected grace period %s, %v", grace, err)
+	}
+	for _, ready := range []MonospaceConfigTaskReady{
+		{Output: "listening on :\\d+"},
+		{Tcp: "127.0.0.1:3000"},
+		{Tcp: "[::1]:3000"},
+		{Http: "http://localhost:3000/health", Timeout: "2m"},
+	} {
+		if err := ready.Validate(); err != nil {
+			t.Errorf("%+v should be valid, got %v", ready, err)
+		}
+	}
+	for _, ready := range []MonospaceConfigTaskReady{
+		{},
+		{Output: "ready", Tcp: "3000"},
+		{Output: "(unclosed"},
+		{Tcp: "example.com:3000"},
+		{Tcp: "70000"},
+		{Http: "http://example.com/health"},
+		{Http: "ftp://localhost/"},
+		{Output: "ready", Timeout: "never"},
+	} {
+		if err := ready.Validate(); !errors.Is(err, ErrInvalidTaskConfig) {
+			t.Errorf("%+v should be invalid, got %v", ready, err)
+		}
+	}
+}
+
+func TestTaskParams(t *testing.T) {
+	param := MonospaceConfigTaskParam{Default: "dev", Choices: []string{"dev", "prod"}}
+	if err := param.Validate("env"); err != nil {
+		t.Errorf("param should be valid, got %v", err)
+	}
+	if value, err := param.GetValue("env", "", false); value != "dev" || err != nil {
+		t.Errorf("unset param should default to dev, got %q, %v", value, err)
+	}
+	if value, err := param.GetValue("env", "prod", true); value != "prod" || err != nil {
+		t.Errorf("expected prod, got %q, %v", value, err)
+	}
+	if _, err := param.GetValue("env", "staging", true); !errors.Is(err, ErrInvalidTaskParam) {
+		t.Errorf("expected ErrInvalidTaskParam for a value not in choices, got %v", err)
+	}
+	if _, err := (MonospaceConfigTaskParam{}).GetValue("target", "", false); !errors.Is(err, ErrInvalidTaskParam) {
+		t.Errorf("expected ErrInvalidTaskParam for a missing required param, got %v", err)
+	}
+	if err := param.Validate("my-env"); !errors.Is(err, ErrInvalidTaskConfig) {
+		t.Errorf("expected ErrInvalidTaskConfig for an invalid param name, got %v", err)
+	}
+	if err := (MonospaceConfigTaskParam{Default: "qa", Choices: []string{"dev"}}).Validate("env"); !errors.Is(err, ErrInvalidTaskConfig) {
+		t.Errorf("expected ErrInvalidTaskConfig for a default not in choices, got %v", err)
+	}
+}
@@ -1,12 +1,20 @@
 package app
 
-import "path/filepath"
+import (
+	"path/filepath"
+	"time"
+)
 
 const DfltJSPM string = "pnpm@10.11.0"
 
 const CacheStrategyContent = "content"
 const CacheStrategyMtime = "mtime"
 const DefaultCacheMaxEntries = 3
+const DefaultReadyTimeout = time.Minute
+const DefaultGracePeriod = 10 * time.Second
+const DefaultLogsMaxRuns = 10
+const RemoteCacheModeReadWrite = "read-write"
+const RemoteCacheModeReadOnly = "read-only"
 const DfltGoModPrfx string = "example.com"
 const DfltPreferredOutputMode string = "grouped"
 
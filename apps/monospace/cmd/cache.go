@@ -10,6 +10,7 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"os"
 	"sort"
 	"strings"
 
@@ -27,7 +28,13 @@ var cacheCmd = &cobra.Command{
 	Long: `Manage the task cache used to skip unchanged tasks during pipeline execution.
 
 Cache entries are stored in .monospace/.cache/ inside the monospace root.
-A task uses the cache when its pipeline entry sets cache: "skip" or cache: "restore".`,
+A task uses the cache when its pipeline entry sets cache: "skip" or cache: "restore".
+When a remote_cache is configured in monospace.yml, local misses are looked up
+in the remote cache and new entries are uploaded to it (unless in read-only mode).
+Commands below only operate on the local cache.
+
+Input files content hashes are memoized in .monospace/.cache/file-hashes.json
+so unchanged files (same size, modification time and inode) are not read again.`,
 }
 
 var cacheStatusCmd = &cobra.Command{
@@ -36,7 +43,10 @@ var cacheStatusCmd = &cobra.Command{
 	Long: `Display currently cached tasks with their hash and timestamp.
 
 You can optionally specify task names (in project#task form) to filter the output.
-Use --project-filter to restrict results to specific projects.`,
+Use --project-filter to restrict results to specific projects.
+
+When global_inputs, hash_env or pass_through_env values changed since an entry was cached, they
+are listed below that entry.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		CheckConfigFound(true)
 		monospaceRoot := mono.SpaceGetRoot()
@@ -69,6 +79,9 @@ Use --project-filter to restrict results to specific projects.`,
 			return entries[i].Project < entries[j].Project
 		})
 
+		pipeline, err := tasks.GetStandardizedPipeline(config, true)
+		utils.CheckErr(err)
+
 		// header
 		fmt.Printf("%-40s  %-10s  %s\n", theme.Bold("task"), theme.Bold("hash"), theme.Bold("cached at"))
 		fmt.Println(strings.Repeat("─", 72))
@@ -79,11 +92,80 @@ Use --project-filter to restrict results to specific projects.`,
 			}
 			taskKey := e.Project + "#" + e.Task
 			fmt.Printf("%-40s  %-10s  %s\n", taskKey, shortHash, e.CachedAt.Format("2006-01-02 15:04:05"))
+			if e.ExitCode != 0 {
+				fmt.Println(theme.Error(fmt.Sprintf("  cached failure (exit code %d)", e.ExitCode)))
+			}
+			if changes := cacheEntryChanges(pipeline, config, monospaceRoot, e); len(changes) > 0 {
+				fmt.Println(theme.Warning("  changed since cached: " + strings.Join(changes, ", ")))
+			}
 		}
 		fmt.Printf("\n%d cache %s\n", len(entries), utils.If(len(entries) == 1, "entry", "entries"))
 	},
 }
 
+// return changes of global inputs and hashed env since the entry was cached,
+// entries of tasks no longer in the pipeline are ignored
+func cacheEntryChanges(pipeline tasks.Pipeline, config *app.MonospaceConfig, monospaceRoot string, e tasks.CacheStatusEntry) []string {
+	if _, ok := config.Projects[e.Project]; !ok && e.Project != "root" {
+		return nil
+	}
+	task := pipeline.TaskLookup(e.Task, e.Project, config)
+	if task == nil {
+		return nil
+	}
+	changes, err := tasks.CacheEntryChanges(tasks.TaskCacheOptions(task, config, monospaceRoot), e.Hash)
+	if err != nil {
+		utils.PrintWarning(fmt.Sprintf("Failed to check changes for %s#%s: %s", e.Project, e.Task, err))
+		return nil
+	}
+	return changes
+}
+
+var cacheExplainCmd = &cobra.Command{
+	Use:   "explain project#task",
+	Short: "Explain why a task misses the cache",
+	Long: `Compare the current state of a task with its most recent cache entry.
+
+When any cache entry of the task matches its current state, the task will be a
+cache hit and that entry is reported instead.
+
+Each cache entry stores a manifest of the components of its hash (cmd, env,
+inputs and outputs patterns, dependencies hashes, global inputs and each input
+file). This command lists the ones that changed since the entry was cached.
+
+Tasks declaring params are explained with their default values unless set with
+--param, as for the run command.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeTaskNameArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		CheckConfigFound(true)
+		config := utils.CheckErrOrReturn(app.ConfigGet())
+		explanation := utils.CheckErrOrReturn(tasks.ExplainTaskCache(args[0], FlagGetParams(cmd), config))
+		entry := explanation.Entry
+		if entry == nil {
+			fmt.Println(theme.Info(fmt.Sprintf("No cache entry found for %s, it will run on next execution.", explanation.Task)))
+			return
+		}
+		cachedAt := entry.CachedAt.Format("2006-01-02 15:04:05")
+		if entry.Hash == explanation.Hash {
+			fmt.Printf("%s %s matches its cache entry %s (cached at %s)\n", theme.SuccessIndicator(), explanation.Task, entry.Hash[:10], cachedAt)
+			return
+		}
+		if !explanation.HasManifest {
+			utils.PrintWarning(fmt.Sprintf("Cache entry %s of %s was created without hash manifest, can't explain the difference", entry.Hash[:10], explanation.Task))
+			return
+		}
+		fmt.Printf("%s differs from its last cache entry %s (cached at %s):\n", theme.Bold(explanation.Task), entry.Hash[:10], cachedAt)
+		if len(explanation.Changes) == 0 {
+			fmt.Println(theme.Info("  no difference found in the hash manifest"))
+			return
+		}
+		for _, change := range explanation.Changes {
+			fmt.Printf("  - %s\n", change)
+		}
+	},
+}
+
 var cacheClearCmd = &cobra.Command{
 	Use:     "clear [task...]",
 	Aliases: []string{"rm"},
@@ -137,16 +219,21 @@ Without arguments, clears the entire cache (will prompt for confirmation unless
 
 var cachePruneCmd = &cobra.Command{
 	Use:   "prune [task...]",
-	Short: "Remove cache entries beyond the configured limit",
-	Long: `Remove old cache entries that exceed the configured maximum for each task.
+	Short: "Remove cache entries beyond the configured limits",
+	Long: `Remove least recently used cache entries that exceed the configured limits.
 
-Without arguments, prunes all cacheable tasks defined in the pipeline.
+Without arguments, prunes all cacheable tasks defined in the pipeline and then
+applies the cache_max_age and cache_max_size limits to the whole cache.
 With arguments (project#task form), only those tasks are pruned.
 
 The maximum number of entries per task is resolved as follows:
   1. cache_max_entries defined on the task itself
   2. cache_max_entries defined at the root of monospace.yml
-  3. Built-in default (` + fmt.Sprintf("%d", app.DefaultCacheMaxEntries) + ` entries)`,
+  3. Built-in default (` + fmt.Sprintf("%d", app.DefaultCacheMaxEntries) + ` entries)
+
+cache_max_age and cache_max_size are also applied after each run.
+Without arguments, deleted files are also removed from the memoized file hashes.
+Use --orphans to also delete the cache of tasks that no longer exist in the pipeline.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		CheckConfigFound(true)
 		monospaceRoot := mono.SpaceGetRoot()
@@ -155,6 +242,21 @@ The maximum number of entries per task is resolved as follows:
 		pipeline, err := tasks.GetStandardizedPipeline(config, true)
 		utils.CheckErr(err)
 
+		if orphans, _ := cmd.Flags().GetBool("orphans"); orphans {
+			removed, err := tasks.PruneOrphanCache(monospaceRoot, func(project, task string) bool {
+				if _, ok := config.Projects[project]; !ok && project != "root" {
+					return false
+				}
+				return pipeline.TaskLookup(task, project, config) != nil
+			})
+			if err != nil {
+				utils.PrintWarning(fmt.Sprintf("Failed to prune orphan cache: %s", err))
+			}
+			for _, taskKey := range removed {
+				fmt.Printf("%s Removed orphan cache of %s\n", theme.SuccessIndicator(), taskKey)
+			}
+		}
+
 		globalMax := config.CacheMaxEntries
 		if globalMax == 0 {
 			globalMax = app.DefaultCacheMaxEntries
@@ -198,6 +300,106 @@ The maximum number of entries per task is resolved as follows:
 			pruned++
 		}
 		fmt.Printf("%s Pruned %d task cache %s\n", theme.SuccessIndicator(), pruned, utils.If(pruned == 1, "entry", "entries"))
+		if len(args) == 0 {
+			evicted, err := tasks.EvictCacheFromConfig(monospaceRoot, config)
+			utils.CheckErr(err)
+			if evicted > 0 {
+				fmt.Printf("%s Evicted %d cache %s exceeding cache_max_age or cache_max_size\n", theme.SuccessIndicator(), evicted, utils.If(evicted == 1, "entry", "entries"))
+			}
+			removed, err := tasks.PruneFileHashIndex(monospaceRoot)
+			if err != nil {
+				utils.PrintWarning(fmt.Sprintf("Failed to prune file hashes: %s", err))
+			} else if removed > 0 {
+				fmt.Printf("%s Removed %d deleted %s from memoized file hashes\n", theme.SuccessIndicator(), removed, utils.If(removed == 1, "file", "files"))
+			}
+		}
+	},
+}
+
+var cacheExportCmd = &cobra.Command{
+	Use:   "export [task...]",
+	Short: "Pack local cache entries into a single archive",
+	Long: `Pack local cache entries into a single archive to transfer them to another
+machine without a remote cache (ie: seed a dev machine with a CI artifact).
+
+Without arguments, all the local cache entries are exported. Arguments
+(project#task, project or task names) restrict the exported entries, project
+filter flags only keep the entries of matching projects among them, --max-age
+only keeps entries cached recently (ie: 12h, 7d, 2w).
+
+Use 'monospace cache import' to merge the archive into another local cache.`,
+	Example: `  monospace cache export -o cache.tar.gz
+  monospace cache export web#build --max-age 2d -o - > cache.tar.gz`,
+	Run: func(cmd *cobra.Command, args []string) {
+		CheckConfigFound(true)
+		monospaceRoot := mono.SpaceGetRoot()
+		config := utils.CheckErrOrReturn(app.ConfigGet())
+
+		filter := tasks.CacheExportFilter{}
+		for _, arg := range args {
+			if strings.Contains(arg, "#") {
+				arg = tasks.StandardizedTaskName(arg, config)
+			}
+			filter.Filters = append(filter.Filters, arg)
+		}
+		if cmd.Flags().Changed("project-filter") || cmd.Flags().Changed("project-filter-out") || cmd.Flags().Changed("include-root") {
+			// non nil even when no project matches so that nothing is exported
+			filter.Projects = append([]string{}, FlagGetFilteredProjectsNames(cmd, config)...)
+		}
+		if maxAge, _ := cmd.Flags().GetString("max-age"); maxAge != "" {
+			age, ok := app.ParseAge(maxAge)
+			if !ok {
+				utils.Exit(fmt.Sprintf("invalid --max-age %q, expected a duration like 12h, 30d or 2w", maxAge))
+			}
+			filter.MaxAge = age
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		out := os.Stdout
+		if output != "-" {
+			f, err := os.Create(output)
+			utils.CheckErr(err)
+			defer f.Close()
+			out = f
+		}
+		exported, err := tasks.ExportCache(monospaceRoot, out, filter)
+		if err != nil && output != "-" {
+			os.Remove(output)
+		}
+		utils.CheckErr(err)
+		// keep stdout for the archive when writing to it
+		fmt.Fprintf(utils.If(output == "-", os.Stderr, os.Stdout), "%s Exported %d cache %s\n", theme.SuccessIndicator(), len(exported), utils.If(len(exported) == 1, "entry", "entries"))
+	},
+}
+
+var cacheImportCmd = &cobra.Command{
+	Use:   "import archive",
+	Short: "Merge a cache archive into the local cache",
+	Long: `Merge an archive created by 'monospace cache export' into the local cache.
+
+Entries metadata and outputs archives are checked before anything is imported,
+entries already present in the local cache are skipped.
+Use - to read the archive from stdin.`,
+	Example: `  monospace cache import cache.tar.gz`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		CheckConfigFound(true)
+		monospaceRoot := mono.SpaceGetRoot()
+
+		in := os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			utils.CheckErr(err)
+			defer f.Close()
+			in = f
+		}
+		res, err := tasks.ImportCache(monospaceRoot, in)
+		utils.CheckErr(err)
+		for _, e := range res.Imported {
+			fmt.Printf("%s Imported %s#%s %s\n", theme.SuccessIndicator(), e.Project, e.Task, e.Hash[:min(10, len(e.Hash))])
+		}
+		imported := len(res.Imported)
+		fmt.Printf("%s Imported %d cache %s, %d already cached\n", theme.SuccessIndicator(), imported, utils.If(imported == 1, "entry", "entries"), len(res.Skipped))
 	},
 }
 
@@ -205,10 +407,21 @@ func init() {
 	cacheCmd.AddCommand(cacheStatusCmd)
 	FlagAddProjectFilter(cacheStatusCmd, false)
 
+	cacheCmd.AddCommand(cacheExplainCmd)
+	FlagAddParams(cacheExplainCmd)
+
 	cacheCmd.AddCommand(cacheClearCmd)
 	cacheClearCmd.Flags().BoolP("force", "f", false, "Skip confirmation when clearing all cache")
 
 	cacheCmd.AddCommand(cachePruneCmd)
+	cachePruneCmd.Flags().Bool("orphans", false, "Also delete the cache of tasks that no longer exist in the pipeline")
+
+	cacheCmd.AddCommand(cacheExportCmd)
+	FlagAddProjectFilter(cacheExportCmd, false)
+	cacheExportCmd.Flags().StringP("output", "o", "monospace-cache.tar.gz", "Archive file to write, - for stdout")
+	cacheExportCmd.Flags().String("max-age", "", "Only export entries cached more recently than this duration (ie: 12h, 7d, 2w)")
+
+	cacheCmd.AddCommand(cacheImportCmd)
 
 	RootCmd.AddCommand(cacheCmd)
 }
@@ -56,7 +56,8 @@ More choices may be available when --interactive flag is used
 
 ## Check pipeline (skipped if --project-filter is used)
 - Check tasks are associated with existing projects.
-- Check tasks depends on existing non persistent tasks.
+- Check tasks depends on existing non persistent tasks (or persistent tasks
+  with a ready condition).
 - Check for circular task dependencies
 There's no fix available on pipeline errors
 
@@ -282,6 +283,13 @@ won't change the exit status of the command.
 			utils.CheckErrOrReturn(tasks.GetStandardizedPipeline(config, false)).IsAcyclic(true)
 			fmt.Println(successIndicator + " pipeline ok")
 
+			// check projects dependencies are valid and acyclic
+			fmt.Println(boldUnderline("checking projects dependencies:"))
+			if !mono.ProjectsDependenciesAreAcyclic(utils.CheckErrOrReturn(mono.ProjectsGetDependenciesFromConfig(config))) {
+				utils.Exit("Projects circular dependencies detected")
+			}
+			fmt.Println(successIndicator + " projects dependencies ok")
+
 			// check githooks path is correctly set
 			if utils.FileExistsNoErr(filepath.Join(monoRoot, app.DfltHooksDir)) {
 				fmt.Printf(boldUnderline("found %s checking git core.hookspath:\n"), app.DfltHooksDir)
@@ -8,12 +8,14 @@ SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/software-t-rex/go-jobExecutor/v2"
 	"github.com/software-t-rex/monospace/app"
 	"github.com/software-t-rex/monospace/gomodules/ui"
 	"github.com/software-t-rex/monospace/gomodules/utils"
@@ -29,14 +31,22 @@ var execCmd = &cobra.Command{
 	Long: `execute given command in each project directory concurrently.
 
 ` + ui.ApplyStyle("execute options and command options must be separated by '--'", ui.Bold) + `
-You can restrict the command to one or more projects using flag --project-filter.`,
+You can restrict the command to one or more projects using flag --project-filter.
+
+With the --topological flag the command will run in a project only after it
+succeeded in every project it depends on (as detected from package.json,
+go.mod and go.work files or declared in projects_dependencies in monospace.yml).
+Projects excluded from the execution still order the ones around them: when a
+depends on b that depends on c, a runs after c even if b is excluded.`,
 	Example: `  monospace exec --project-filter modules/mymodule --project-filter modules/myothermodule -- ls -la
   # or more concise
   monospace exec -p modules/mymodule,modules/myothermodule -- ls -la
   # create a branch on all git projects at once (including root)
   monospace exec --git -r -- git checkout -b my-new-branch
   # fetching only external projects
-  monospace exec --external -- git fetch`,
+  monospace exec --external -- git fetch
+  # publish packages after their dependencies
+  monospace exec --topological -- npm publish`,
 	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		CheckConfigFound(true)
@@ -51,6 +61,7 @@ You can restrict the command to one or more projects using flag --project-filter
 		filterInternal := FlagGetBool(cmd, "internal")
 		filterLocal := FlagGetBool(cmd, "local")
 		includeRoot := FlagGetBool(cmd, "include-root")
+		topological := FlagGetBool(cmd, "topological")
 		hasKindFilter := filterExternal || filterInternal || filterLocal
 
 		if cmdBin != "" && cmdBin[0] == '.' { // make relative path relative to projects
@@ -62,7 +73,10 @@ You can restrict the command to one or more projects using flag --project-filter
 
 		projects := FlagGetFilteredProjects(cmd, config)
 
+		defer tasks.SetMaxConcurrentJobs(FlagGetConcurrency(cmd, config))()
 		executor := tasks.NewExecutor(outputMode)
+		reporter := FlagGetReporter(cmd, args)
+		jobs := make(map[string]jobExecutor.Job, len(projects))
 		for _, p := range projects {
 			project := p
 			// check project match --git/--external/--internal/--local flags
@@ -80,15 +94,41 @@ You can restrict the command to one or more projects using flag --project-filter
 			os.Setenv("MONOSPACE_PROJECT_PATH", project.Path())
 			cmd := exec.Command(cmdBin, cmdArgs...)
 			cmd.Dir = project.Path()
+			var jobName string
 			switch outputMode {
 			case "interleaved":
 				alias, hasAlias := aliases[project.Name]
-				executor.AddNamedJobCmd(utils.If(hasAlias, alias, project.Name), cmd)
+				jobName = utils.If(hasAlias, alias, project.Name)
 			default:
-				executor.AddNamedJobCmd(fmt.Sprintf("%s: %s", project.StyledString(), strings.Join(args, " ")), cmd)
+				jobName = fmt.Sprintf("%s: %s", project.StyledString(), strings.Join(args, " "))
+			}
+			jobs[project.Name] = executor.AddJob(jobExecutor.NamedJob{Name: jobName, Job: cmd})
+			if reporter != nil {
+				job := jobs[project.Name]
+				reporter.AddJob(job.Id(), project.Name+": "+strings.Join(args, " "), project.Name, "")
 			}
 		}
-		executor.Execute()
+		if reporter != nil {
+			reporter.Attach(executor)
+		}
+		if !topological {
+			executor.Execute()
+			return
+		}
+		projectsDeps := utils.CheckErrOrReturn(mono.ProjectsGetDependenciesFromConfig(config))
+		// keep the order given by excluded projects in between
+		projectsDeps = mono.ProjectsDependenciesAmong(projectsDeps, utils.MapGetKeys(jobs))
+		for projectName, job := range jobs {
+			for _, dep := range projectsDeps[projectName] {
+				if depJob, ok := jobs[dep]; ok {
+					executor.AddJobDependency(job, depJob)
+				}
+			}
+		}
+		errs := executor.DagExecute()
+		if errs.Len() > 0 && errors.Is(errs[0], jobExecutor.ErrCyclicDependencyDetected) {
+			utils.Exit(errs[0].Error())
+		}
 	},
 }
 
@@ -100,5 +140,8 @@ func init() {
 	execCmd.Flags().Bool("external", false, "Execute command in all external projects")
 	execCmd.Flags().Bool("internal", false, "Execute command in all internal projects (root has to be include with -r)")
 	execCmd.Flags().Bool("local", false, "Execute command in all local projects (root has to be include with -r)")
+	execCmd.Flags().Bool("topological", false, "Execute command in a project only after it succeeded in projects it depends on")
+	FlagAddReportFile(execCmd)
+	FlagAddConcurrency(execCmd)
 
 }
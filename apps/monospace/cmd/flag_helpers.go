@@ -3,17 +3,19 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/software-t-rex/monospace/app"
 	"github.com/software-t-rex/monospace/gomodules/utils"
 	"github.com/software-t-rex/monospace/mono"
+	"github.com/software-t-rex/monospace/tasks"
 	"github.com/spf13/cobra"
 )
 
 // constants for flag enumValue, first item in the list is default value
 const projectTypes = ",go,js"
-const outputModes = "grouped,interleaved,status-only,errors-only,none"
+const outputModes = "grouped,interleaved,status-only,errors-only,tui,none"
 
 func exitAndHelp(cmd *cobra.Command, err error) {
 	utils.PrintError(err)
@@ -134,6 +136,89 @@ func FlagGetFilteredProjectsNames(cmd *cobra.Command, config *app.MonospaceConfi
 	return utils.SliceMap(FlagGetFilteredProjects(cmd, config), func(p mono.Project) string { return p.Name })
 }
 
+func FlagAddReportFile(cmd *cobra.Command) {
+	cmd.Flags().String("report-file", "", "Write a report of the execution to the given file (status, duration, exit code, cache\nand output excerpt of each task)")
+	cmd.Flags().String("report-format", "", "Report file format: json or junit\n(default to junit for .xml report files, json otherwise)")
+	utils.CheckErr(cmd.RegisterFlagCompletionFunc("report-format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{tasks.ReportFormatJSON, tasks.ReportFormatJUnit}, cobra.ShellCompDirectiveDefault
+	}))
+}
+
+// return a reporter for the --report-file flag or nil if not set
+func FlagGetReporter(cmd *cobra.Command, args []string) *tasks.Reporter {
+	reportFile := FlagGetString(cmd, "report-file")
+	if reportFile == "" {
+		return nil
+	}
+	return utils.CheckErrOrReturn(tasks.NewReporter(reportFile, FlagGetString(cmd, "report-format"), commandLine(cmd, args)))
+}
+
+// return the command line as typed by the user, without flags
+func commandLine(cmd *cobra.Command, args []string) string {
+	return strings.TrimSpace(cmd.CommandPath() + " " + strings.Join(args, " "))
+}
+
+func FlagAddConcurrency(cmd *cobra.Command) {
+	cmd.Flags().StringP("concurrency", "j", "", "Maximum number of concurrent jobs, either a number or a percentage of cpus like 50%\n(default to max_concurrency in monospace.yml or number of cpus if not set)")
+}
+
+// return the --concurrency flag value or max_concurrency from config, 0 if none is set
+func FlagGetConcurrency(cmd *cobra.Command, config *app.MonospaceConfig) int {
+	if concurrency := FlagGetString(cmd, "concurrency"); concurrency != "" {
+		n, err := app.ParseConcurrency(concurrency)
+		if err != nil {
+			exitAndHelp(cmd, err)
+		}
+		return n
+	}
+	return utils.CheckErrOrReturn(config.GetMaxConcurrency())
+}
+
+// should use FlagGetParams in Run
+func FlagAddParams(cmd *cobra.Command) {
+	cmd.Flags().StringArray("param", nil, "Set a task parameter as name=value (can be repeated), parameters are declared\nin the params of the tasks in monospace.yml")
+	utils.CheckErr(cmd.RegisterFlagCompletionFunc("param", completeParams))
+}
+
+// complete parameters names declared in the pipeline, then their choices
+func completeParams(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config, err := app.ConfigGet()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	name, _, hasValue := strings.Cut(toComplete, "=")
+	res := []string{}
+	for _, taskDef := range config.Pipeline {
+		for paramName, param := range taskDef.Params {
+			if !hasValue {
+				res = append(res, paramName+"=")
+			} else if paramName == name {
+				for _, choice := range param.Choices {
+					res = append(res, paramName+"="+choice)
+				}
+			}
+		}
+	}
+	slices.Sort(res)
+	if hasValue {
+		return slices.Compact(res), cobra.ShellCompDirectiveNoFileComp
+	}
+	return slices.Compact(res), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+// return the --param flags values by parameter name
+func FlagGetParams(cmd *cobra.Command) map[string]string {
+	params := map[string]string{}
+	for _, param := range utils.CheckErrOrReturn(cmd.Flags().GetStringArray("param")) {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || name == "" {
+			exitAndHelp(cmd, fmt.Errorf("invalid param %q, expected name=value", param))
+		}
+		params[name] = value
+	}
+	return params
+}
+
 // you should call GetFlagOutputMode in the Run of the associated command
 func FlagAddOutputMode(cmd *cobra.Command) {
 	cmd.Flags().StringP("output-mode", "O", "", "output mode for multiple commands:\n- "+strings.Replace(outputModes, ",", "\n- ", -1)+"\n(default to monospace.yml settings or grouped if not set)")
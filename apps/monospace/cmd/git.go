@@ -9,7 +9,9 @@ package cmd
 
 import (
 	"os/exec"
+	"strings"
 
+	jobExecutor "github.com/software-t-rex/go-jobExecutor/v2"
 	"github.com/software-t-rex/monospace/app"
 	"github.com/software-t-rex/monospace/gomodules/ui"
 	"github.com/software-t-rex/monospace/gomodules/utils"
@@ -77,7 +79,10 @@ Output mode is grouped by default, and git flags must be passed after a double h
 		outputMode := FlagGetOutputMode(cmd, "grouped")
 		aliases := config.GetProjectsAliases()
 		projects := FlagGetFilteredProjectsWithRoot(cmd, config)
+		defer tasks.SetMaxConcurrentJobs(FlagGetConcurrency(cmd, config))()
 		executor := tasks.NewExecutor(outputMode)
+		reporter := FlagGetReporter(cmd, args)
+		gitCommand := "git " + strings.Join(args, " ")
 		if ui.EnhancedEnabled() { // add colors to git commands if color is enabled
 			args = append([]string{"-c", "color.ui=always"}, args...)
 		}
@@ -88,13 +93,21 @@ Output mode is grouped by default, and git flags must be passed after a double h
 			project := p
 			cmd := exec.Command("git", args...)
 			cmd.Dir = project.Path()
+			var jobName string
 			switch outputMode {
 			case "interleaved":
 				alias, hasAlias := aliases[project.Name]
-				executor.AddNamedJobCmd(utils.If(hasAlias, alias, project.Name), cmd)
+				jobName = utils.If(hasAlias, alias, project.Name)
 			default:
-				executor.AddNamedJobCmd(project.StyledString(), cmd)
+				jobName = project.StyledString()
 			}
+			job := executor.AddJob(jobExecutor.NamedJob{Name: jobName, Job: cmd})
+			if reporter != nil {
+				reporter.AddJob(job.Id(), project.Name+": "+gitCommand, project.Name, "")
+			}
+		}
+		if reporter != nil {
+			reporter.Attach(executor)
 		}
 		executor.Execute()
 	},
@@ -104,4 +117,6 @@ func init() {
 	RootCmd.AddCommand(gitCmd)
 	FlagAddProjectFilter(gitCmd, true)
 	FlagAddOutputMode(gitCmd)
+	FlagAddReportFile(gitCmd)
+	FlagAddConcurrency(gitCmd)
 }
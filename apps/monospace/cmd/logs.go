@@ -0,0 +1,170 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/software-t-rex/monospace/app"
+	"github.com/software-t-rex/monospace/gomodules/utils"
+	"github.com/software-t-rex/monospace/mono"
+	"github.com/software-t-rex/monospace/tasks"
+	"github.com/spf13/cobra"
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [run-id] [task]",
+	Short: "Browse the logs of past runs",
+	Long: `Browse the logs of past 'monospace run' executions.
+
+Each run keeps the full output of its tasks in .monospace/logs/<run-id>/
+(one <project#task>.log file per task) with a run.json report, so the logs of a
+failed run can be read without running it again. Only the last runs are kept,
+see logs_max_runs in monospace.yml (defaults to ` + fmt.Sprintf("%d", app.DefaultLogsMaxRuns) + `, -1 disables run logs).
+
+Without arguments, lists the kept runs with their status.
+With a run id, lists the tasks of that run with their status.
+With a run id and a task (in project#task form), prints the task log.
+Use 'last' as run id for the most recent run.`,
+	Example: `  monospace logs
+  monospace logs last
+  monospace logs 20240131-143005 web#build
+  monospace logs last api#dev --follow`,
+	Args: cobra.MaximumNArgs(2),
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		switch len(args) {
+		case 0:
+			runs, _ := tasks.ListRunLogs(mono.SpaceGetRoot())
+			res := []string{tasks.RunLogLast}
+			for _, run := range runs {
+				res = append(res, run.Id)
+			}
+			return res, cobra.ShellCompDirectiveNoFileComp
+		case 1:
+			run, err := tasks.GetRunLog(mono.SpaceGetRoot(), args[0])
+			if err != nil {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			names, _ := run.Tasks()
+			return names, cobra.ShellCompDirectiveNoFileComp
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		CheckConfigFound(true)
+		monospaceRoot := mono.SpaceGetRoot()
+
+		if len(args) == 0 {
+			printRunLogs(utils.CheckErrOrReturn(tasks.ListRunLogs(monospaceRoot)))
+			return
+		}
+		run := utils.CheckErrOrReturn(tasks.GetRunLog(monospaceRoot, args[0]))
+		if len(args) == 1 {
+			printRunLog(run)
+			return
+		}
+
+		path, err := run.TaskLogPath(args[1])
+		if project, task, ok := strings.Cut(args[1], "#"); err != nil && ok {
+			// allow project aliases
+			if config, cfgErr := app.ConfigGet(); cfgErr == nil && config.Aliases[project] != "" {
+				path, err = run.TaskLogPath(config.Aliases[project] + "#" + task)
+			}
+		}
+		utils.CheckErr(err)
+		lines, _ := cmd.Flags().GetInt("lines")
+		follow, _ := cmd.Flags().GetBool("follow")
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		utils.CheckErr(tasks.TailFile(ctx, path, lines, follow, os.Stdout))
+	},
+}
+
+// return a summary of the tasks status of a run report
+func runLogSummary(report *tasks.RunReport) string {
+	if report == nil {
+		return theme.Warning("incomplete (interrupted or still running)")
+	}
+	parts := []string{}
+	if report.Succeed > 0 {
+		parts = append(parts, theme.Success(fmt.Sprintf("%d succeed", report.Succeed)))
+	}
+	if report.Failed > 0 {
+		parts = append(parts, theme.Error(fmt.Sprintf("%d failed", report.Failed)))
+	}
+	if report.Skipped > 0 {
+		parts = append(parts, theme.Warning(fmt.Sprintf("%d skipped", report.Skipped)))
+	}
+	indicator := utils.If(report.Failed == 0 && report.Skipped == 0, theme.SuccessIndicator(), theme.FailureIndicator())
+	return indicator + " " + strings.Join(parts, " / ")
+}
+
+func runLogDuration(seconds float64) string {
+	return (time.Duration(seconds * float64(time.Second))).Round(time.Millisecond).String()
+}
+
+func printRunLogs(runs []tasks.RunLog) {
+	if len(runs) == 0 {
+		fmt.Println(theme.Info("No run logs found."))
+		return
+	}
+	fmt.Printf("%-20s  %-19s  %-10s  %s\n", theme.Bold("run id"), theme.Bold("started at"), theme.Bold("duration"), theme.Bold("command"))
+	fmt.Println(strings.Repeat("─", 72))
+	for _, run := range runs {
+		if run.Report == nil {
+			fmt.Printf("%-20s  %-19s  %-10s  %s\n", run.Id, "", "", runLogSummary(nil))
+			continue
+		}
+		fmt.Printf("%-20s  %-19s  %-10s  %s\n", run.Id, run.Report.StartedAt.Local().Format("2006-01-02 15:04:05"), runLogDuration(run.Report.Duration), run.Report.Command)
+		fmt.Printf("  %s\n", runLogSummary(run.Report))
+	}
+	fmt.Printf("\n%d %s kept in %s\n", len(runs), utils.If(len(runs) == 1, "run", "runs"), tasks.RunLogsDir(mono.SpaceGetRoot()))
+}
+
+func printRunLog(run tasks.RunLog) {
+	if run.Report == nil {
+		fmt.Printf("%s %s\n", theme.Bold("run "+run.Id), runLogSummary(nil))
+		for _, name := range utils.CheckErrOrReturn(run.Tasks()) {
+			fmt.Printf("  %s\n", name)
+		}
+		return
+	}
+	fmt.Printf("%s %s (%s, started at %s)\n", theme.Bold("run "+run.Id), run.Report.Command, runLogDuration(run.Report.Duration), run.Report.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	fmt.Printf("%s\n\n", runLogSummary(run.Report))
+	sort.Slice(run.Report.Tasks, func(i, j int) bool { return run.Report.Tasks[i].Name < run.Report.Tasks[j].Name })
+	for _, task := range run.Report.Tasks {
+		indicator := theme.FailureIndicator()
+		switch task.Status {
+		case tasks.ReportStatusSucceed:
+			indicator = theme.SuccessIndicator()
+		case tasks.ReportStatusSkipped:
+			indicator = "⏭"
+		}
+		cache := ""
+		if task.Cache != "" {
+			cache = " (cache " + task.Cache + ")"
+		}
+		fmt.Printf("%s %-40s %-8s %10s%s\n", indicator, task.Name, task.Status, runLogDuration(task.Duration), cache)
+		if task.Error != "" {
+			fmt.Println(theme.Error("  " + task.Error))
+		}
+	}
+	fmt.Printf("\nUse 'monospace logs %s <task>' to print the log of a task.\n", run.Id)
+}
+
+func init() {
+	logsCmd.Flags().BoolP("follow", "f", false, "Keep printing the task log as it grows (ie: a running persistent task), until interrupted")
+	logsCmd.Flags().IntP("lines", "n", 0, "Only print the last n lines of the task log")
+	RootCmd.AddCommand(logsCmd)
+}
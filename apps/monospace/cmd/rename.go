@@ -47,6 +47,7 @@ will update the monospace gitignore and .monospace.yml files accordingly.`,
 
 		project := utils.CheckErrOrReturn(mono.ProjectGetByName(oldName))
 
+		utils.CheckErr(app.ConfigRenameProjectInDependencies(project.Name, newName, false))
 		utils.CheckErr(app.ConfigRemoveProject(project.Name, false))
 		utils.CheckErr(app.ConfigAddProject(newName, project.RepoUrl, true))
 
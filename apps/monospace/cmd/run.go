@@ -15,6 +15,7 @@ import (
 
 	"github.com/software-t-rex/monospace/app"
 	"github.com/software-t-rex/monospace/gomodules/utils"
+	"github.com/software-t-rex/monospace/mono"
 	"github.com/software-t-rex/monospace/tasks"
 	"github.com/spf13/cobra"
 )
@@ -27,11 +28,37 @@ var runCmd = &cobra.Command{
 
 You can restrict the tasks execution to one or more projects
 using the --project-filter flag.
-You can pass additional arguments to tasks separating them with a double hyphen.
+You can also restrict it to projects that changed since a given git ref using
+the --affected-since flag, projects depending on affected projects (see
+projects_dependencies in monospace.yml) and tasks depending on affected
+projects tasks will be run too.
+You can pass additional arguments to tasks separating them with a double hyphen,
+use --no-deps-args to only pass them to the tasks named on the command line and
+not to their dependencies.
+Tasks declaring params in monospace.yml get their values from --param flags
+(or their default), values replace ${name} in the task cmd and env and are part
+of the cache key.
 
 you can get a dependency graph of tasks to run by using the --graphviz flag.
 It will output the dot representation in your terminal and open your browser
 for visual online rendering.
+Use --concurrency (or max_concurrency in monospace.yml) to limit the number
+of tasks running at the same time, tasks marked as exclusive in the pipeline
+never run concurrently with other tasks.
+When a task fails, tasks depending on it are skipped and every task not
+depending on it still runs (--continue, the default). Use --bail to cancel
+running tasks and skip pending ones at the first failure instead.
+The --dry-run flag prints the tasks to run in execution order with their
+resolved command, working directory, env, dependencies and whether they would
+hit the local or remote cache, without running anything (--dry-run=json for CI
+tooling).
+The --watch flag keeps running after the first execution: it watches the
+inputs of the tasks (as resolved for the cache) and re-runs the tasks whose
+inputs changed and the tasks depending on them. Persistent tasks are started
+once after the first execution and stay alive until you exit with ctrl+c.
+The tui output mode (--output-mode=tui) displays a full screen dashboard with
+the status of each task and the output of the selected one, where tasks can be
+restarted or killed; it stays open until you quit, handy with persistent tasks.
 
 A circular dependency check will be performed before the execution starts.`,
 	Example: `  monospace run --project-filter modules/mymodule --project-filter modules/myothermodule test
@@ -40,6 +67,24 @@ A circular dependency check will be performed before the execution starts.`,
   monospace run -p modules/mymodule,modules/myothermodule test -- additionalArg=value
   # run tasks on monospace root only
   monospace run -p root task
+  # pass arguments to the test tasks but not to the build tasks they depend on
+  monospace run test --no-deps-args -- --verbose
+  # set the env param of the deploy tasks
+  monospace run deploy --param env=prod
+  # run tests only for projects changed since origin/main
+  monospace run test --affected-since origin/main
+  # run at most 2 tasks at a time, or use half of the cpus
+  monospace run build -j 2
+  monospace run build --concurrency 50%
+  # stop everything at the first failing test
+  monospace run test --bail
+  # re-run build tasks when their inputs change
+  monospace run build --watch
+  # follow dev servers and their dependencies in a dashboard
+  monospace run dev --output-mode=tui
+  # show what would be executed
+  monospace run build --dry-run
+  monospace run build --dry-run=json
   # get dependency graph for a specific task
   monospace run task --graphviz
   # or for the entire pipeline
@@ -83,7 +128,26 @@ A circular dependency check will be performed before the execution starts.`,
 		filteredProjects := FlagGetFilteredProjects(cmd, config)
 		// remove additional args from the command and populate additional args as job parameters
 		additionalArgs := splitAdditionalArgs(&args)
-		taskList := tasks.PrepareTaskList(args, filteredProjects, config)
+		var taskList tasks.TaskList
+		affectedSince := FlagGetString(cmd, "affected-since")
+		if affectedSince == "" {
+			taskList = tasks.PrepareTaskList(args, filteredProjects, config)
+		} else {
+			affected := utils.CheckErrOrReturn(mono.ProjectsAffectedSince(affectedSince, filteredProjects))
+			if len(affected) == 0 {
+				fmt.Println(theme.Info("No project affected since " + affectedSince))
+				return
+			}
+			fmt.Println(theme.Info(fmt.Sprintf("Affected projects since %s: %s", affectedSince, strings.Join(affected, ", "))))
+			// projects depending on affected projects are affected too
+			projectsDeps := utils.CheckErrOrReturn(mono.ProjectsGetDependenciesFromConfig(config))
+			affected = mono.ProjectsWithDependents(projectsDeps, affected)
+			taskList = tasks.PrepareAffectedTaskList(args, filteredProjects, affected, config)
+			if taskList.Len() == 0 {
+				fmt.Println(theme.Info("No tasks to run for affected projects"))
+				return
+			}
+		}
 
 		// we don't need to bother with ouput mode for graphviz output
 		if graphviz {
@@ -91,6 +155,16 @@ A circular dependency check will be performed before the execution starts.`,
 			return
 		}
 
+		if err := taskList.SetParams(FlagGetParams(cmd)); err != nil {
+			utils.Exit(err.Error())
+		}
+		noCache := utils.CheckErrOrReturn(cmd.Flags().GetBool("no-cache"))
+		targetsOnlyArgs := utils.CheckErrOrReturn(cmd.Flags().GetBool("no-deps-args"))
+		if dryRun := FlagGetString(cmd, "dry-run"); dryRun != "" {
+			tasks.DryRun(taskList, tasks.RunOptions{AdditionalArgs: additionalArgs, NoCache: noCache, TargetsOnlyArgs: targetsOnlyArgs}, dryRun)
+			return
+		}
+
 		// we make extra job to determine best output mode for the task
 		flagOuputMode := utils.CheckErrOrReturn(cmd.Flags().GetString("output-mode"))
 		var outputMode string
@@ -123,12 +197,26 @@ A circular dependency check will be performed before the execution starts.`,
 			outputMode = FlagGetOutputMode(cmd, config.PreferredOutputMode)
 		}
 
-		noCache := utils.CheckErrOrReturn(cmd.Flags().GetBool("no-cache"))
-		tasks.Run(taskList, tasks.RunOptions{
-			AdditionalArgs: additionalArgs,
-			OutputMode:     outputMode,
-			NoCache:        noCache,
-		})
+		failurePolicy := tasks.FailurePolicyContinue
+		if utils.CheckErrOrReturn(cmd.Flags().GetBool("bail")) {
+			failurePolicy = tasks.FailurePolicyBail
+		}
+
+		opts := tasks.RunOptions{
+			AdditionalArgs:  additionalArgs,
+			OutputMode:      outputMode,
+			NoCache:         noCache,
+			Concurrency:     FlagGetConcurrency(cmd, config),
+			FailurePolicy:   failurePolicy,
+			Command:         commandLine(cmd, args),
+			TargetsOnlyArgs: targetsOnlyArgs,
+		}
+		if utils.CheckErrOrReturn(cmd.Flags().GetBool("watch")) {
+			tasks.Watch(taskList, opts)
+			return
+		}
+		opts.Reporter = FlagGetReporter(cmd, args)
+		tasks.Run(taskList, opts)
 	},
 }
 
@@ -136,6 +224,18 @@ func init() {
 	RootCmd.AddCommand(runCmd)
 	FlagAddProjectFilter(runCmd, true)
 	FlagAddOutputMode(runCmd)
+	FlagAddConcurrency(runCmd)
 	runCmd.Flags().BoolP("graphviz", "g", false, "Open a graph visualisation of the task execution plan instead of executing it")
 	runCmd.Flags().Bool("no-cache", false, "Bypass task cache and always execute tasks")
+	runCmd.Flags().Bool("continue", false, "Keep running tasks that don't depend on a failed task (default)")
+	runCmd.Flags().Bool("bail", false, "Cancel running tasks at the first failure")
+	runCmd.MarkFlagsMutuallyExclusive("continue", "bail")
+	runCmd.Flags().String("affected-since", "", "Only run tasks for projects changed since given git ref (and tasks depending on them)")
+	runCmd.Flags().String("dry-run", "", "Print the execution plan (commands, directories, env, dependencies and cache status)\ninstead of executing it, use --dry-run=json for a machine readable output")
+	runCmd.Flags().Lookup("dry-run").NoOptDefVal = "text"
+	runCmd.Flags().BoolP("watch", "w", false, "Re-run tasks when their inputs change, persistent tasks are kept alive")
+	FlagAddReportFile(runCmd)
+	runCmd.MarkFlagsMutuallyExclusive("watch", "report-file")
+	FlagAddParams(runCmd)
+	runCmd.Flags().Bool("no-deps-args", false, "Only pass additional args (after --) to the tasks named on the command line,\nnot to the tasks they depend on")
 }
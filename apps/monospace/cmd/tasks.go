@@ -79,6 +79,26 @@ var tasksCmd = &cobra.Command{
 				if task.TaskDef.DependsOn != nil {
 					sb.WriteString(fmt.Sprintf("  %s: %s\n", theme.Italic("dependends on"), strings.Join(task.TaskDef.DependsOn, ", ")))
 				}
+				if task.TaskDef.Exclusive {
+					sb.WriteString(fmt.Sprintf("  %s: %t\n", theme.Italic("exclusive"), true))
+				}
+				if task.TaskDef.Retries > 0 {
+					sb.WriteString(fmt.Sprintf("  %s: %d", theme.Italic("retries"), task.TaskDef.Retries))
+					if task.TaskDef.RetryDelay != "" {
+						sb.WriteString(fmt.Sprintf(" (delay %s)", task.TaskDef.RetryDelay))
+					}
+					sb.WriteString("\n")
+				}
+				if task.TaskDef.Timeout != "" {
+					sb.WriteString(fmt.Sprintf("  %s: %s\n", theme.Italic("timeout"), task.TaskDef.Timeout))
+				}
+				if ready := task.TaskDef.Ready; ready != nil {
+					condition := utils.If(ready.Output != "", "output matches "+ready.Output, utils.If(ready.Tcp != "", "tcp "+ready.Tcp, "http "+ready.Http))
+					sb.WriteString(fmt.Sprintf("  %s: %s\n", theme.Italic("ready when"), condition))
+				}
+				if task.TaskDef.GracePeriod != "" {
+					sb.WriteString(fmt.Sprintf("  %s: %s\n", theme.Italic("grace period"), task.TaskDef.GracePeriod))
+				}
 				if task.TaskDef.OutputMode != "" && task.TaskDef.OutputMode != config.PreferredOutputMode {
 					sb.WriteString(fmt.Sprintf("  %s: %s\n", theme.Italic("output mode"), task.TaskDef.OutputMode))
 				}
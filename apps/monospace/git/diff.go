@@ -0,0 +1,61 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var ErrUnknownRef = errors.New("unknown git ref")
+
+// check the given ref resolves to a commit in the repository at directory
+func RefExists(directory string, ref string) bool {
+	cmd := exec.Command("git", "-C", directory, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	return cmd.Run() == nil
+}
+
+// return the best common ancestor between ref and HEAD, or ref itself if none can be found
+func mergeBase(directory string, ref string) string {
+	/* #nosec G204 - only directory and ref come from the outside */
+	out, err := exec.Command("git", "-C", directory, "merge-base", ref, "HEAD").Output()
+	if base := strings.TrimSpace(string(out)); err == nil && base != "" {
+		return base
+	}
+	return ref
+}
+
+// return the NUL separated paths output by the given git command in directory
+func gitListPaths(directory string, args ...string) ([]string, error) {
+	/* #nosec G204 - only directory and ref come from the outside */
+	out, err := exec.Command("git", append([]string{"-C", directory}, args...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s in %s: %w", args[0], directory, err)
+	}
+	var files []string
+	for _, file := range strings.Split(string(out), "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// ChangedFilesSince returns files changed in directory since its repository
+// diverged from the given ref. It includes committed, staged, unstaged and
+// untracked (non ignored) files. Paths are relative to directory (which may be
+// a subdirectory of the repository), changes outside of it are ignored.
+func ChangedFilesSince(directory string, ref string) ([]string, error) {
+	if !RefExists(directory, ref) {
+		return nil, fmt.Errorf("%w %s in %s", ErrUnknownRef, ref, directory)
+	}
+	files, err := gitListPaths(directory, "diff", "--name-only", "--no-renames", "--relative", "-z", mergeBase(directory, ref))
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := gitListPaths(directory, "ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return nil, err
+	}
+	return append(files, untracked...), nil
+}
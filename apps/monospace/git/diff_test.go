@@ -0,0 +1,82 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestChangedFilesSince(t *testing.T) {
+	tmpdir := t.TempDir()
+	assert.NilError(t, Init(tmpdir, false), "Failed to init git repo")
+	gitRun := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", tmpdir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		assert.NilError(t, err, string(out))
+	}
+	writeFile := func(name string, content string) {
+		t.Helper()
+		assert.NilError(t, os.MkdirAll(filepath.Dir(filepath.Join(tmpdir, name)), 0750))
+		assert.NilError(t, os.WriteFile(filepath.Join(tmpdir, name), []byte(content), 0640))
+	}
+	writeFile("a.txt", "a")
+	writeFile("sub/b.txt", "b")
+	gitRun("add", ".")
+	gitRun("commit", "-m", "initial")
+	gitRun("tag", "v1")
+
+	files, err := ChangedFilesSince(tmpdir, "v1")
+	assert.NilError(t, err)
+	assert.Equal(t, len(files), 0, "expected no changes, got %v", files)
+
+	writeFile("sub/b.txt", "b changed")
+	gitRun("commit", "-am", "change b")
+	writeFile("a.txt", "a changed")
+	writeFile("sub/c.txt", "untracked")
+	files, err = ChangedFilesSince(tmpdir, "v1")
+	assert.NilError(t, err)
+	slices.Sort(files)
+	assert.DeepEqual(t, files, []string{"a.txt", "sub/b.txt", "sub/c.txt"})
+
+	_, err = ChangedFilesSince(tmpdir, "unknown-ref")
+	assert.Assert(t, errors.Is(err, ErrUnknownRef), "expected ErrUnknownRef, got %v", err)
+}
+
+func TestChangedFilesSince_Subdirectory(t *testing.T) {
+	tmpdir := t.TempDir()
+	assert.NilError(t, Init(tmpdir, false), "Failed to init git repo")
+	gitRun := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", tmpdir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		assert.NilError(t, err, string(out))
+	}
+	writeFile := func(name string, content string) {
+		t.Helper()
+		assert.NilError(t, os.MkdirAll(filepath.Dir(filepath.Join(tmpdir, name)), 0750))
+		assert.NilError(t, os.WriteFile(filepath.Join(tmpdir, name), []byte(content), 0640))
+	}
+	writeFile("outside.txt", "a")
+	writeFile("space/lib/a b.txt", "a")
+	writeFile("space/lib/é.txt", "a")
+	gitRun("add", ".")
+	gitRun("commit", "-m", "initial")
+	gitRun("tag", "v1")
+
+	writeFile("outside.txt", "changed")
+	writeFile("space/lib/a b.txt", "changed")
+	gitRun("commit", "-am", "change")
+	writeFile("space/lib/é.txt", "changed")
+	writeFile("space/new.txt", "untracked")
+	writeFile("other.txt", "untracked")
+	files, err := ChangedFilesSince(filepath.Join(tmpdir, "space"), "v1")
+	assert.NilError(t, err)
+	slices.Sort(files)
+	assert.DeepEqual(t, files, []string{"lib/a b.txt", "lib/é.txt", "new.txt"})
+}
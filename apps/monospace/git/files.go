@@ -0,0 +1,29 @@
+package git
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ListFiles returns tracked and untracked (non ignored) files of the
+// repository under directory. Paths are slash separated and relative to
+// directory. Tracked files deleted from the working tree are still listed.
+func ListFiles(directory string) ([]string, error) {
+	/* #nosec G204 - only directory comes from the outside */
+	cmd := exec.Command("git", "-C", directory, "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files in %s: %w", directory, err)
+	}
+	var files []string
+	seen := map[string]bool{}
+	for _, file := range strings.Split(string(out), "\x00") {
+		// files with unresolved conflicts are listed once per stage
+		if file != "" && !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
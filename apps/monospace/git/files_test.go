@@ -0,0 +1,35 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestListFiles(t *testing.T) {
+	tmpdir := t.TempDir()
+	assert.NilError(t, Init(tmpdir, false), "Failed to init git repo")
+	writeFile := func(name string, content string) {
+		t.Helper()
+		assert.NilError(t, os.MkdirAll(filepath.Dir(filepath.Join(tmpdir, name)), 0750))
+		assert.NilError(t, os.WriteFile(filepath.Join(tmpdir, name), []byte(content), 0640))
+	}
+	writeFile(".gitignore", "dist/\n")
+	writeFile("sub/a.txt", "a")
+	writeFile("sub/dist/out.js", "ignored")
+	writeFile("sub/untracked.txt", "untracked")
+	out, err := exec.Command("git", "-C", tmpdir, "add", ".gitignore", "sub/a.txt").CombinedOutput()
+	assert.NilError(t, err, string(out))
+
+	files, err := ListFiles(filepath.Join(tmpdir, "sub"))
+	assert.NilError(t, err)
+	slices.Sort(files)
+	assert.DeepEqual(t, files, []string{"a.txt", "untracked.txt"})
+
+	_, err = ListFiles(t.TempDir())
+	assert.Assert(t, err != nil, "expected an error outside of a git repository")
+}
@@ -0,0 +1,88 @@
+package mono
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/software-t-rex/monospace/git"
+	"github.com/software-t-rex/monospace/gomodules/utils"
+)
+
+// return the name of the project owning the given file path (relative to the
+// monospace root) or "root" if no project in projectNames contains it
+func projectOwningFile(file string, projectNames []string) string {
+	file = filepath.ToSlash(file)
+	owner := RootProject.Name
+	ownerLen := 0
+	for _, name := range projectNames {
+		prefix := strings.TrimSuffix(filepath.ToSlash(name), "/") + "/"
+		if strings.HasPrefix(file, prefix) && len(prefix) > ownerLen {
+			owner = name
+			ownerLen = len(prefix)
+		}
+	}
+	return owner
+}
+
+// ProjectsAffectedSince returns the names of given projects that have changes
+// since the given git ref.
+//   - root and internal projects are checked against the monospace repository
+//   - external and local projects are checked against their own repository,
+//     if the ref doesn't exist in such a repository the project is considered
+//     affected and a warning is printed.
+func ProjectsAffectedSince(ref string, projects []Project) ([]string, error) {
+	affected := []string{}
+	var internals []string
+	checkRoot := false
+	for _, p := range projects {
+		if p.IsInternal() {
+			internals = append(internals, p.Name)
+		} else if p.IsRoot() {
+			checkRoot = true
+		}
+	}
+
+	if checkRoot || len(internals) > 0 {
+		files, err := git.ChangedFilesSince(SpaceGetRoot(), ref)
+		if err != nil {
+			return nil, err
+		}
+		changed := map[string]bool{}
+		for _, file := range files {
+			changed[projectOwningFile(file, internals)] = true
+		}
+		if checkRoot && changed[RootProject.Name] {
+			affected = append(affected, RootProject.Name)
+		}
+		for _, name := range internals {
+			if changed[name] {
+				affected = append(affected, name)
+			}
+		}
+	}
+
+	for _, p := range projects {
+		if p.IsInternal() || p.IsRoot() {
+			continue
+		}
+		if !p.IsGit() {
+			utils.PrintWarning(fmt.Sprintf("%s is not a git repository, considered affected", p.Name))
+			affected = append(affected, p.Name)
+			continue
+		}
+		files, err := git.ChangedFilesSince(p.Path(), ref)
+		if errors.Is(err, git.ErrUnknownRef) {
+			utils.PrintWarning(fmt.Sprintf("%s: can't find ref %s, considered affected", p.Name, ref))
+			affected = append(affected, p.Name)
+		} else if err != nil {
+			return nil, err
+		} else if len(files) > 0 {
+			affected = append(affected, p.Name)
+		}
+	}
+	sort.Strings(affected)
+	return affected, nil
+}
@@ -0,0 +1,25 @@
+package mono
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestProjectOwningFile(t *testing.T) {
+	projects := []string{"apps/web", "apps/web-admin", "packages/ui", "packages/ui/icons"}
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"apps/web/src/index.js", "apps/web"},
+		{"apps/web-admin/package.json", "apps/web-admin"},
+		{"packages/ui/icons/svg/logo.svg", "packages/ui/icons"},
+		{"packages/ui/button.js", "packages/ui"},
+		{"apps/webby/index.js", "root"},
+		{"pnpm-lock.yaml", "root"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, projectOwningFile(tt.file, projects), tt.want, "wrong owner for %s", tt.file)
+	}
+}
@@ -0,0 +1,277 @@
+package mono
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/software-t-rex/monospace/app"
+	"github.com/software-t-rex/monospace/gomodules/utils"
+)
+
+// call handle with the name and arguments of each directive of a go.mod or
+// go.work file, directives of blocks are handled as single line ones
+func readGoModDirectives(path string, handle func(directive string, args []string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	block := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if block != "" {
+			if fields[0] == ")" {
+				block = ""
+				continue
+			}
+			fields = append([]string{block}, fields...)
+		} else if len(fields) == 2 && fields[1] == "(" {
+			block = fields[0]
+			continue
+		}
+		handle(fields[0], fields[1:])
+	}
+	return scanner.Err()
+}
+
+// return the replaced module path and the replacement directory (absolute path)
+// of a replace directive arguments, ok is false when the replacement is not a
+// local directory
+func localReplace(dir string, args []string) (module string, target string, ok bool) {
+	arrow := utils.SliceFindIndex(args, "=>")
+	if arrow < 1 || arrow+1 >= len(args) {
+		return "", "", false
+	}
+	target = args[arrow+1]
+	if !strings.HasPrefix(target, "./") && !strings.HasPrefix(target, "../") && !filepath.IsAbs(target) {
+		return "", "", false
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(dir, target)
+	}
+	return strings.Trim(args[0], `"`), filepath.Clean(target), true
+}
+
+// parse a go.mod file and return the module path, required modules and
+// local replacement directories (absolute paths)
+func readGoMod(goModPath string) (module string, requires []string, localReplaces []string, err error) {
+	dir := filepath.Dir(goModPath)
+	err = readGoModDirectives(goModPath, func(directive string, args []string) {
+		switch {
+		case directive == "module" && len(args) > 0:
+			module = strings.Trim(args[0], `"`)
+		case directive == "require" && len(args) > 0:
+			requires = append(requires, strings.Trim(args[0], `"`))
+		case directive == "replace":
+			if _, target, ok := localReplace(dir, args); ok {
+				localReplaces = append(localReplaces, target)
+			}
+		}
+	})
+	return module, requires, localReplaces, err
+}
+
+// parse a go.work file and return its local replacement directories (absolute
+// paths) by module path
+func readGoWorkReplaces(goWorkPath string) (map[string]string, error) {
+	dir := filepath.Dir(goWorkPath)
+	replaces := map[string]string{}
+	err := readGoModDirectives(goWorkPath, func(directive string, args []string) {
+		if directive != "replace" {
+			return
+		}
+		if module, target, ok := localReplace(dir, args); ok {
+			replaces[module] = target
+		}
+	})
+	return replaces, err
+}
+
+// detect dependencies between given projects by looking at package.json
+// dependencies, go.mod requires and local replaces and go.work local replaces
+// (modules used in go.work are matched by the requires of go.mod files)
+func detectProjectsDependencies(projects []Project) map[string][]string {
+	jsPackages := map[string]string{} // package name -> project name
+	goModules := map[string]string{}  // module path -> project name
+	projectDirs := map[string]string{}
+	for _, p := range projects {
+		projectDirs[filepath.Clean(p.Path())] = p.Name
+		if pjson, err := p.GetPackageJson(); err == nil && pjson.Name != "" {
+			jsPackages[pjson.Name] = p.Name
+		}
+		if p.IsGolangProject() {
+			if module, _, _, err := readGoMod(filepath.Join(p.Path(), "go.mod")); err == nil && module != "" {
+				goModules[module] = p.Name
+			}
+		}
+	}
+
+	// modules replaced by a project directory in go.work are provided by that project
+	if replaces, err := readGoWorkReplaces(filepath.Join(SpaceGetRoot(), "go.work")); err == nil {
+		for module, dir := range replaces {
+			if project, ok := projectDirs[dir]; ok {
+				goModules[module] = project
+			}
+		}
+	}
+
+	res := map[string][]string{}
+	addDep := func(project string, dep string) {
+		if dep != "" && dep != project && !utils.SliceContains(res[project], dep) {
+			res[project] = append(res[project], dep)
+		}
+	}
+	for _, p := range projects {
+		if pjson, err := p.GetPackageJson(); err == nil {
+			deps := pjson.GetMergedDependencies()
+			for name := range pjson.PeerDependencies {
+				deps[name] = ""
+			}
+			for name := range deps {
+				addDep(p.Name, jsPackages[name])
+			}
+		}
+		if p.IsGolangProject() {
+			_, requires, replaces, err := readGoMod(filepath.Join(p.Path(), "go.mod"))
+			if err != nil {
+				continue
+			}
+			for _, module := range requires {
+				addDep(p.Name, goModules[module])
+			}
+			for _, dir := range replaces {
+				addDep(p.Name, projectDirs[dir])
+			}
+		}
+	}
+	return res
+}
+
+// ProjectsGetDependenciesFromConfig returns a map indexed by project names with
+// the list of projects they depend on as values. It merges dependencies declared
+// in the projects_dependencies section of the config with the ones detected from
+// package.json dependencies, go.mod requires/replaces and go.work replaces.
+// Aliases are resolved to project names. It returns an error if a declared
+// dependency is unknown.
+func ProjectsGetDependenciesFromConfig(config *app.MonospaceConfig) (map[string][]string, error) {
+	projects := append([]Project{RootProject}, ProjectsAsStructs(config.Projects)...)
+	resolveName := func(name string) (string, error) {
+		if name == RootProject.Name {
+			return name, nil
+		} else if _, ok := config.Projects[name]; ok {
+			return name, nil
+		} else if aliased, ok := config.Aliases[name]; ok {
+			if _, ok := config.Projects[aliased]; ok {
+				return aliased, nil
+			}
+		}
+		return "", fmt.Errorf("projects_dependencies: unknown project %s", name)
+	}
+
+	res := detectProjectsDependencies(projects)
+	for project, deps := range config.ProjectsDependencies {
+		projectName, err := resolveName(project)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range deps {
+			depName, err := resolveName(dep)
+			if err != nil {
+				return nil, err
+			}
+			if depName != projectName && !utils.SliceContains(res[projectName], depName) {
+				res[projectName] = append(res[projectName], depName)
+			}
+		}
+	}
+	for project := range res {
+		sort.Strings(res[project])
+	}
+	return res, nil
+}
+
+// return given projects names and all the projects that depend on them (directly or not)
+func ProjectsWithDependents(dependencies map[string][]string, projectNames []string) []string {
+	res := append([]string{}, projectNames...)
+	for i := 0; i < len(res); i++ {
+		for project, deps := range dependencies {
+			if utils.SliceContains(deps, res[i]) && !utils.SliceContains(res, project) {
+				res = append(res, project)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// return the dependencies between given projects only. Dependencies on other
+// projects are replaced by their own dependencies among given projects, so
+// that given projects keep their relative order (ie: with a -> b -> c and
+// only a and c given, a depends on c).
+func ProjectsDependenciesAmong(dependencies map[string][]string, projectNames []string) map[string][]string {
+	res := map[string][]string{}
+	for _, project := range projectNames {
+		seen := map[string]bool{project: true}
+		pending := append([]string{}, dependencies[project]...)
+		for len(pending) > 0 {
+			dep := pending[0]
+			pending = pending[1:]
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			if utils.SliceContains(projectNames, dep) {
+				// its own dependencies are ordered through it
+				res[project] = append(res[project], dep)
+				continue
+			}
+			pending = append(pending, dependencies[dep]...)
+		}
+		sort.Strings(res[project])
+	}
+	return res
+}
+
+// check there's no circular dependencies between projects
+func ProjectsDependenciesAreAcyclic(dependencies map[string][]string) bool {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var visit func(project string) bool
+	visit = func(project string) bool {
+		switch state[project] {
+		case visiting:
+			return false
+		case visited:
+			return true
+		}
+		state[project] = visiting
+		for _, dep := range dependencies[project] {
+			if !visit(dep) {
+				return false
+			}
+		}
+		state[project] = visited
+		return true
+	}
+	for project := range dependencies {
+		if !visit(project) {
+			return false
+		}
+	}
+	return true
+}
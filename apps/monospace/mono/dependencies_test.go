@@ -0,0 +1,103 @@
+package mono
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestReadGoMod(t *testing.T) {
+	dir := t.TempDir()
+	goMod := filepath.Join(dir, "go.mod")
+	content := `module example.com/app // app module
+
+go 1.21
+
+require example.com/single v1.0.0
+
+require (
+	example.com/lib v0.0.0
+	// example.com/commented v1.0.0
+	github.com/other/mod v1.2.3 // indirect
+)
+
+replace example.com/lib => ../lib
+
+replace (
+	example.com/single => /abs/single
+	github.com/other/mod => github.com/fork/mod v1.2.4
+)
+`
+	assert.NilError(t, os.WriteFile(goMod, []byte(content), 0644))
+	module, requires, replaces, err := readGoMod(goMod)
+	assert.NilError(t, err)
+	assert.Equal(t, module, "example.com/app")
+	assert.DeepEqual(t, requires, []string{"example.com/single", "example.com/lib", "github.com/other/mod"})
+	assert.DeepEqual(t, replaces, []string{filepath.Join(filepath.Dir(dir), "lib"), "/abs/single"})
+}
+
+func TestDetectProjectsDependencies_GoWork(t *testing.T) {
+	root := t.TempDir()
+	oldRoot := monospaceRoot
+	monospaceRoot = root
+	t.Cleanup(func() { monospaceRoot = oldRoot })
+	files := map[string]string{
+		"go.work":           "go 1.21\n\nuse (\n\t./apps/api\n\t./libs/log\n)\n\nreplace example.com/logger => ./libs/log\n",
+		"apps/api/go.mod":   "module example.com/api\n\nrequire (\n\texample.com/logger v1.0.0\n\texample.com/db v1.0.0\n)\n",
+		"libs/log/go.mod":   "module example.com/log\n",
+		"libs/db/go.mod":    "module example.com/db\n",
+		"apps/other/go.mod": "module example.com/other\n\nrequire example.com/unknown v1.0.0\n",
+	}
+	for name, content := range files {
+		assert.NilError(t, os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755))
+		assert.NilError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0644))
+	}
+	projects := ProjectsAsStructs(map[string]string{"apps/api": "internal", "apps/other": "internal", "libs/log": "internal", "libs/db": "internal"})
+	assert.DeepEqual(t, detectProjectsDependencies(projects), map[string][]string{
+		"apps/api": {"libs/log", "libs/db"},
+	})
+}
+
+func TestProjectsWithDependents(t *testing.T) {
+	dependencies := map[string][]string{
+		"apps/web":     {"packages/ui", "packages/utils"},
+		"apps/admin":   {"apps/web"},
+		"packages/ui":  {"packages/utils"},
+		"apps/docs":    {"packages/ui"},
+		"apps/backend": {},
+	}
+	assert.DeepEqual(t, ProjectsWithDependents(dependencies, []string{"packages/ui"}), []string{"apps/admin", "apps/docs", "apps/web", "packages/ui"})
+	assert.DeepEqual(t, ProjectsWithDependents(dependencies, []string{"apps/backend"}), []string{"apps/backend"})
+	assert.DeepEqual(t, ProjectsWithDependents(dependencies, []string{}), []string{})
+}
+
+func TestProjectsDependenciesAmong(t *testing.T) {
+	dependencies := map[string][]string{
+		"apps/web":       {"packages/ui", "packages/utils"},
+		"apps/admin":     {"apps/web"},
+		"packages/ui":    {"packages/utils"},
+		"packages/utils": {"packages/core"},
+	}
+	assert.DeepEqual(t, ProjectsDependenciesAmong(dependencies, []string{"apps/admin", "packages/core"}), map[string][]string{
+		"apps/admin": {"packages/core"},
+	})
+	assert.DeepEqual(t, ProjectsDependenciesAmong(dependencies, []string{"apps/admin", "packages/ui", "packages/utils"}), map[string][]string{
+		"apps/admin":  {"packages/ui", "packages/utils"},
+		"packages/ui": {"packages/utils"},
+	})
+	assert.DeepEqual(t, ProjectsDependenciesAmong(dependencies, []string{"apps/web"}), map[string][]string{})
+}
+
+func TestProjectsDependenciesAreAcyclic(t *testing.T) {
+	assert.Assert(t, ProjectsDependenciesAreAcyclic(map[string][]string{
+		"apps/web":    {"packages/ui", "packages/utils"},
+		"packages/ui": {"packages/utils"},
+	}))
+	assert.Assert(t, !ProjectsDependenciesAreAcyclic(map[string][]string{
+		"apps/web":       {"packages/ui"},
+		"packages/ui":    {"packages/utils"},
+		"packages/utils": {"apps/web"},
+	}))
+}
@@ -11,8 +11,8 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -22,6 +22,9 @@ import (
 
 	"github.com/bmatcuk/doublestar/v4"
 	"github.com/software-t-rex/monospace/app"
+	"github.com/software-t-rex/monospace/git"
+	"github.com/software-t-rex/monospace/gomodules/utils"
+	"github.com/software-t-rex/packageJson"
 )
 
 // CacheOptions holds everything the cache layer needs to operate on a single task.
@@ -34,7 +37,19 @@ type CacheOptions struct {
 	Inputs        []string // glob patterns relative to ProjectPath (empty = all files)
 	Outputs       []string // glob patterns relative to ProjectPath
 	MonospaceRoot string
-	MaxEntries    int // maximum number of hash entries to keep per task (0 = no limit)
+	MaxEntries    int          // maximum number of hash entries to keep per task (0 = no limit)
+	Remote        *RemoteCache // shared cache used on local misses (nil = local cache only)
+	// hashes of the tasks this task depends on indexed by task name
+	DependencyHashes map[string]string
+	GlobalInputs     []string       // glob patterns relative to MonospaceRoot
+	HashEnv          []string       // env variables names whose values are part of the hash
+	PassThroughEnv   []string       // env variables names read by the task, values are part of the hash
+	HashManifest     *HashManifest  // components folded in the hash, saved with the entry when set
+	UseGitFiles      bool           // list project files with git ls-files when no inputs are set
+	CacheFailures    bool           // failed runs entries are hits (otherwise they are ignored)
+	fileHashes       *fileHashIndex // memoized files content hashes (nil = always read files)
+	// task parameters values, part of the hash
+	Params map[string]string
 }
 
 // CacheMetadata is stored alongside a cache entry to record when it was created.
@@ -44,6 +59,9 @@ type CacheMetadata struct {
 	Project   string    `json:"project"`
 	Task      string    `json:"task"`
 	Strategy  string    `json:"strategy"`
+	// duration of the task run that produced the entry
+	Duration time.Duration `json:"duration,omitempty"`
+	ExitCode int           `json:"exitCode,omitempty"` // non zero for cached failures
 }
 
 // CacheResult is returned by Check.
@@ -51,6 +69,7 @@ type CacheResult struct {
 	Hit      bool
 	Hash     string
 	CacheDir string // full path to .monospace/.cache/{proj}#{task}/{hash}
+	Fetched  bool   // the entry was downloaded from the remote cache
 }
 
 // CacheStatusEntry describes the cache state of a given task.
@@ -59,6 +78,7 @@ type CacheStatusEntry struct {
 	Task     string
 	Hash     string
 	CachedAt time.Time
+	ExitCode int // non zero for cached failures
 }
 
 // cacheBaseDir returns the base cache directory for this monospace.
@@ -78,16 +98,51 @@ func cacheEntryDir(monospaceRoot, project, task, hash string) string {
 }
 
 // ComputeHash returns a hex-encoded SHA256 hash over the resolved input files
-// for the given task. The hash also covers the task command and env vars so
-// that changes to the task definition itself bust the cache.
+// for the given task. The hash also covers the task command (or package.json
+// script body when no command is set), env vars and dependencies hashes so
+// that changes to the task definition itself or to its dependencies bust the cache.
 //
 // Strategy "content" (default) hashes the actual file bytes.
 // Strategy "mtime" hashes "{relpath}:{size}:{modtime_unix}\n" for each file.
 func ComputeHash(opts CacheOptions, taskDef app.MonospaceConfigTask) (string, error) {
+	hash, _, err := computeHash(opts, taskDef)
+	return hash, err
+}
+
+// computeHash is the same as ComputeHash but also returns the manifest of the
+// components folded in the hash.
+func computeHash(opts CacheOptions, taskDef app.MonospaceConfigTask) (string, HashManifest, error) {
 	h := sha256.New()
 
+	// 0. Global inputs and environment variables values
+	manifest, err := hashGlobals(opts)
+	if err != nil {
+		return "", manifest, err
+	}
+
 	// 1. Stable task identity
-	fmt.Fprintf(h, "%s|%s|%s\n", opts.TaskName, opts.ProjectName, strings.Join(taskDef.Cmd, " "))
+	manifest.Cmd = strings.Join(taskDef.Cmd, " ")
+	fmt.Fprintf(h, "%s|%s|%s\n", opts.TaskName, opts.ProjectName, manifest.Cmd)
+	if len(taskDef.Cmd) == 0 {
+		if script := packageJsonScript(opts.ProjectPath, opts.TaskName); script != "" {
+			manifest.Script = script
+			fmt.Fprintf(h, "script:%s\n", script)
+		}
+	}
+
+	// 1b. Sorted dependencies hashes
+	depNames := make([]string, 0, len(opts.DependencyHashes))
+	for k := range opts.DependencyHashes {
+		depNames = append(depNames, k)
+	}
+	sort.Strings(depNames)
+	if len(depNames) > 0 {
+		manifest.Dependencies = make(map[string]string, len(depNames))
+	}
+	for _, k := range depNames {
+		manifest.Dependencies[k] = opts.DependencyHashes[k]
+		fmt.Fprintf(h, "dep:%s=%s\n", k, opts.DependencyHashes[k])
+	}
 
 	// 2. Sorted env key=value pairs
 	envKeys := make([]string, 0, len(taskDef.Env))
@@ -95,10 +150,23 @@ func ComputeHash(opts CacheOptions, taskDef app.MonospaceConfigTask) (string, er
 		envKeys = append(envKeys, k)
 	}
 	sort.Strings(envKeys)
+	if len(envKeys) > 0 {
+		manifest.Env = make(map[string]string, len(envKeys))
+	}
 	for _, k := range envKeys {
+		manifest.Env[k] = hashString(taskDef.Env[k])
 		fmt.Fprintf(h, "%s=%s\n", k, taskDef.Env[k])
 	}
 
+	// 2a. Sorted parameters values
+	if len(opts.Params) > 0 {
+		manifest.Params = make(map[string]string, len(opts.Params))
+	}
+	for _, k := range sortedKeys(opts.Params) {
+		manifest.Params[k] = hashString(opts.Params[k])
+		fmt.Fprintf(h, "param:%s=%s\n", k, opts.Params[k])
+	}
+
 	// 2b. Input/output patterns — included in the hash to invalidate the cache
 	// if the patterns change even when the resolved files are identical.
 	inputsCopy := append([]string(nil), opts.Inputs...)
@@ -107,11 +175,21 @@ func ComputeHash(opts CacheOptions, taskDef app.MonospaceConfigTask) (string, er
 	outputsCopy := append([]string(nil), opts.Outputs...)
 	sort.Strings(outputsCopy)
 	fmt.Fprintf(h, "outputs:%s\n", strings.Join(outputsCopy, ","))
+	manifest.Inputs = inputsCopy
+	manifest.Outputs = outputsCopy
+
+	// 2c. Global inputs and environment variables
+	if len(opts.GlobalInputs) > 0 {
+		globalsCopy := append([]string(nil), opts.GlobalInputs...)
+		sort.Strings(globalsCopy)
+		fmt.Fprintf(h, "global_inputs:%s\n", strings.Join(globalsCopy, ","))
+	}
+	manifest.writeGlobalsTo(h)
 
 	// 3. Resolve input file list
 	files, err := resolveInputFiles(opts)
 	if err != nil {
-		return "", fmt.Errorf("resolving input files for %s#%s: %w", opts.ProjectName, opts.TaskName, err)
+		return "", manifest, fmt.Errorf("resolving input files for %s#%s: %w", opts.ProjectName, opts.TaskName, err)
 	}
 	sort.Strings(files)
 
@@ -120,56 +198,113 @@ func ComputeHash(opts CacheOptions, taskDef app.MonospaceConfigTask) (string, er
 	if strategy == "" {
 		strategy = app.CacheStrategyContent
 	}
-	for _, file := range files {
+	var sums []string
+	if strategy != app.CacheStrategyMtime {
+		// content strategy: files are hashed in parallel and memoized by the index
+		if sums, err = opts.fileHashes.hashFiles(files); err != nil {
+			return "", manifest, fmt.Errorf("hashing input file for %s#%s: %w", opts.ProjectName, opts.TaskName, err)
+		}
+	}
+	manifest.Files = make(map[string]string, len(files))
+	for i, file := range files {
 		rel, err := filepath.Rel(opts.ProjectPath, file)
 		if err != nil {
-			return "", fmt.Errorf("getting relative path for %s: %w", file, err)
+			return "", manifest, fmt.Errorf("getting relative path for %s: %w", file, err)
 		}
 		if strategy == app.CacheStrategyMtime {
 			info, err := os.Stat(file)
 			if err != nil {
-				return "", fmt.Errorf("stat input file %q for %s#%s: %w", file, opts.ProjectName, opts.TaskName, err)
+				return "", manifest, fmt.Errorf("stat input file %q for %s#%s: %w", file, opts.ProjectName, opts.TaskName, err)
 			}
+			manifest.Files[filepath.ToSlash(rel)] = fmt.Sprintf("%d:%d", info.Size(), info.ModTime().Unix())
 			fmt.Fprintf(h, "%s:%d:%d\n", rel, info.Size(), info.ModTime().Unix())
 		} else {
-			// content strategy
-			f, err := os.Open(file)
-			if err != nil {
-				return "", fmt.Errorf("open input file %q for %s#%s: %w", file, opts.ProjectName, opts.TaskName, err)
-			}
-			fmt.Fprintf(h, "%s:", rel)
-			if _, err := io.Copy(h, f); err != nil {
-				f.Close()
-				return "", fmt.Errorf("read input file %q for %s#%s: %w", file, opts.ProjectName, opts.TaskName, err)
-			}
-			if err := f.Close(); err != nil {
-				return "", fmt.Errorf("close input file %q for %s#%s: %w", file, opts.ProjectName, opts.TaskName, err)
-			}
-			fmt.Fprint(h, "\n")
+			manifest.Files[filepath.ToSlash(rel)] = sums[i]
+			fmt.Fprintf(h, "%s:%s\n", rel, sums[i])
 		}
 	}
 
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return hex.EncodeToString(h.Sum(nil)), manifest, nil
+}
+
+// packageJsonScript returns the body of the script named taskName in the
+// package.json of the project or an empty string if there's no such script.
+func packageJsonScript(projectPath, taskName string) string {
+	pjson, err := packageJson.Read(filepath.Join(projectPath, "package.json"))
+	if err != nil {
+		return ""
+	}
+	return pjson.Scripts[taskName]
 }
 
 // resolveInputFiles returns the list of files to include in the cache key.
-// If no inputs are configured all files in the project directory are used
-// (excluding .git and .monospace directories).
+// If no inputs are configured (or only negated ones) all files in the project
+// directory not ignored by git are used (excluding .git and .monospace
+// directories). Files matching a "!" prefixed input pattern are excluded.
 func resolveInputFiles(opts CacheOptions) ([]string, error) {
-	if len(opts.Inputs) == 0 {
-		return walkProjectFiles(opts.ProjectPath)
+	var patterns, negatedPatterns []string
+	for _, pattern := range opts.Inputs {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			negatedPatterns = append(negatedPatterns, negated)
+		} else {
+			patterns = append(patterns, pattern)
+		}
 	}
+	var files []string
+	var err error
+	if len(patterns) == 0 {
+		files, err = listProjectFiles(opts)
+	} else {
+		files, err = globProjectFiles(opts.ProjectPath, patterns)
+	}
+	if err != nil || len(negatedPatterns) == 0 {
+		return files, err
+	}
+	return utils.SliceFilter(files, func(file string) bool {
+		rel, err := filepath.Rel(opts.ProjectPath, file)
+		if err != nil {
+			return true
+		}
+		for _, pattern := range negatedPatterns {
+			if match, _ := doublestar.Match(pattern, filepath.ToSlash(rel)); match {
+				return false
+			}
+		}
+		return true
+	}), nil
+}
+
+// listProjectFiles returns all files of the project not ignored by git, using
+// git ls-files when opts.UseGitFiles is set and the project is in a git repository
+func listProjectFiles(opts CacheOptions) ([]string, error) {
+	if opts.UseGitFiles {
+		if gitFiles, err := git.ListFiles(opts.ProjectPath); err == nil {
+			files := make([]string, 0, len(gitFiles))
+			for _, file := range gitFiles {
+				abs := filepath.Join(opts.ProjectPath, filepath.FromSlash(file))
+				// skip deleted tracked files and nested repositories
+				if info, err := os.Stat(abs); err == nil && !info.IsDir() {
+					files = append(files, abs)
+				}
+			}
+			return files, nil
+		}
+	}
+	return walkProjectFiles(opts.ProjectPath, loadParentIgnoreRules(opts.ProjectPath, opts.MonospaceRoot))
+}
 
+// globProjectFiles returns the files of the project matching the given patterns
+func globProjectFiles(projectPath string, patterns []string) ([]string, error) {
 	seen := make(map[string]struct{})
 	var files []string
-	fsys := os.DirFS(opts.ProjectPath)
-	for _, pattern := range opts.Inputs {
+	fsys := os.DirFS(projectPath)
+	for _, pattern := range patterns {
 		matches, err := doublestar.Glob(fsys, pattern)
 		if err != nil {
 			return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
 		}
 		for _, match := range matches {
-			abs := filepath.Join(opts.ProjectPath, match)
+			abs := filepath.Join(projectPath, match)
 			info, err := os.Stat(abs)
 			if err != nil || info.IsDir() {
 				continue
@@ -184,23 +319,25 @@ func resolveInputFiles(opts CacheOptions) ([]string, error) {
 }
 
 // walkProjectFiles recursively collects all regular files in dir,
-// skipping .git and .monospace directories.
+// skipping .git and .monospace directories and files ignored by the given
+// rules or by .gitignore files found along the way.
 // Symbolic links to directories are followed with cycle detection
 // to avoid infinite loops.
-func walkProjectFiles(dir string) ([]string, error) {
+func walkProjectFiles(dir string, rules ignoreRules) ([]string, error) {
 	realRoot, err := filepath.EvalSymlinks(dir)
 	if err != nil {
 		realRoot = dir
 	}
 	visited := map[string]struct{}{realRoot: {}}
-	return walkProjectFilesRecurse(dir, visited)
+	return walkProjectFilesRecurse(dir, visited, rules)
 }
 
-func walkProjectFilesRecurse(dir string, visited map[string]struct{}) ([]string, error) {
+func walkProjectFilesRecurse(dir string, visited map[string]struct{}, rules ignoreRules) ([]string, error) {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
+	rules = rules.withFile(dir)
 	var files []string
 	for _, entry := range entries {
 		path := filepath.Join(dir, entry.Name())
@@ -212,7 +349,7 @@ func walkProjectFilesRecurse(dir string, visited map[string]struct{}) ([]string,
 				continue // broken link, skipped
 			}
 			info, err := os.Stat(realPath)
-			if err != nil {
+			if err != nil || rules.ignored(path, info.IsDir()) {
 				continue
 			}
 			if info.IsDir() {
@@ -224,7 +361,7 @@ func walkProjectFilesRecurse(dir string, visited map[string]struct{}) ([]string,
 					continue // cycle detected
 				}
 				visited[realPath] = struct{}{}
-				sub, err := walkProjectFilesRecurse(path, visited)
+				sub, err := walkProjectFilesRecurse(path, visited, rules)
 				if err != nil {
 					continue
 				}
@@ -237,10 +374,10 @@ func walkProjectFilesRecurse(dir string, visited map[string]struct{}) ([]string,
 
 		if entry.IsDir() {
 			name := entry.Name()
-			if name == ".git" || name == ".monospace" {
+			if name == ".git" || name == ".monospace" || rules.ignored(path, true) {
 				continue
 			}
-			sub, err := walkProjectFilesRecurse(path, visited)
+			sub, err := walkProjectFilesRecurse(path, visited, rules)
 			if err != nil {
 				continue
 			}
@@ -248,17 +385,40 @@ func walkProjectFilesRecurse(dir string, visited map[string]struct{}) ([]string,
 			continue
 		}
 
-		files = append(files, path)
+		if !rules.ignored(path, false) {
+			files = append(files, path)
+		}
 	}
 	return files, nil
 }
 
 // Check looks up whether a cache entry exists for the given hash.
+// Entries are committed atomically by Save so only complete entries are hits.
 func Check(opts CacheOptions, hash string) (CacheResult, error) {
 	entryDir := cacheEntryDir(opts.MonospaceRoot, opts.ProjectName, opts.TaskName, hash)
-	metaPath := filepath.Join(entryDir, "metadata.json")
+	var fetched bool
+	if _, err := os.Stat(filepath.Join(entryDir, "metadata.json")); os.IsNotExist(err) && opts.Remote != nil {
+		// local miss: try to populate the local cache from the remote one
+		if err := fetchRemote(opts, hash); errors.Is(err, ErrRemoteCacheMiss) {
+			return CacheResult{Hit: false, Hash: hash}, nil
+		} else if err != nil {
+			return CacheResult{Hit: false, Hash: hash}, fmt.Errorf("fetching remote cache: %w", err)
+		}
+		fetched = true
+	}
+	result, err := lookupCache(opts, hash)
+	result.Fetched = fetched
+	if result.Hit {
+		touchCacheEntry(entryDir)
+	}
+	return result, err
+}
 
-	data, err := os.ReadFile(metaPath)
+// lookupCache is like Check but only looks at the local cache and leaves the
+// entry last access time untouched, so it has no side effect.
+func lookupCache(opts CacheOptions, hash string) (CacheResult, error) {
+	entryDir := cacheEntryDir(opts.MonospaceRoot, opts.ProjectName, opts.TaskName, hash)
+	data, err := os.ReadFile(filepath.Join(entryDir, "metadata.json"))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return CacheResult{Hit: false, Hash: hash}, nil
@@ -269,17 +429,43 @@ func Check(opts CacheOptions, hash string) (CacheResult, error) {
 	if err := json.Unmarshal(data, &meta); err != nil {
 		return CacheResult{Hit: false, Hash: hash}, fmt.Errorf("unmarshaling cache metadata: %w", err)
 	}
+	// failures are only replayed when the task caches them
+	if meta.ExitCode != 0 && !opts.CacheFailures {
+		return CacheResult{Hit: false, Hash: hash}, nil
+	}
+	// entries with a missing or corrupted outputs archive are considered a miss
+	if hasOutputsArchive(opts) && meta.ExitCode == 0 {
+		if _, err := verifyOutputsArchive(entryDir); err != nil {
+			return CacheResult{Hit: false, Hash: hash}, err
+		}
+	}
 	return CacheResult{Hit: true, Hash: hash, CacheDir: entryDir}, nil
 }
 
 // Save writes metadata, the task output, and (in restore mode) archives the
 // output files. Should be called after a task completes successfully.
-// The output string is stored so it can be replayed on subsequent cache hits.
+// The output string is stored as stdout so it can be replayed on subsequent
+// cache hits, use SaveOutput to keep the output streams separated.
 func Save(opts CacheOptions, hash string, output string) error {
-	entryDir := cacheEntryDir(opts.MonospaceRoot, opts.ProjectName, opts.TaskName, hash)
-	if err := os.MkdirAll(entryDir, 0750); err != nil {
-		return fmt.Errorf("creating cache entry dir: %w", err)
+	taskOutput := TaskOutput{}
+	if output != "" {
+		taskOutput.Chunks = []OutputChunk{{Stream: StreamStdout, Data: output}}
 	}
+	return SaveOutput(opts, hash, taskOutput)
+}
+
+// SaveOutput is like Save but records the task output streams, their timing
+// and the task duration. Outputs with a non zero exit code are saved as cached
+// failures, without output files.
+// The entry is written in a temporary directory and committed once complete,
+// replacing any existing entry for the same hash.
+func SaveOutput(opts CacheOptions, hash string, output TaskOutput) error {
+	tmpDir, err := newCacheEntryTempDir(cacheTaskDir(opts.MonospaceRoot, opts.ProjectName, opts.TaskName), hash)
+	if err != nil {
+		return err
+	}
+	// no-op once the entry is committed
+	defer os.RemoveAll(tmpDir)
 
 	meta := CacheMetadata{
 		Hash:      hash,
@@ -287,31 +473,35 @@ func Save(opts CacheOptions, hash string, output string) error {
 		Project:   opts.ProjectName,
 		Task:      opts.TaskName,
 		Strategy:  opts.Strategy,
+		Duration:  output.Duration,
+		ExitCode:  output.ExitCode,
 	}
 	data, err := json.Marshal(meta)
 	if err != nil {
 		return fmt.Errorf("marshaling cache metadata: %w", err)
 	}
-	if err := os.WriteFile(filepath.Join(entryDir, "metadata.json"), data, 0640); err != nil {
+	if err := os.WriteFile(filepath.Join(tmpDir, "metadata.json"), data, 0640); err != nil {
 		return fmt.Errorf("writing cache metadata: %w", err)
 	}
 
 	// Always persist the command output for replay on cache hits.
-	if err := os.WriteFile(filepath.Join(entryDir, "output.txt"), []byte(output), 0640); err != nil {
+	if err := os.WriteFile(filepath.Join(tmpDir, "output.txt"), []byte(output.Combined()), 0640); err != nil {
 		return fmt.Errorf("writing cached output: %w", err)
 	}
-
-	// Prune old entries beyond the configured limit.
-	if opts.MaxEntries > 0 {
-		_ = PruneTaskCache(opts.MonospaceRoot, opts.ProjectName, opts.TaskName, opts.MaxEntries)
+	if err := writeOutputStreams(tmpDir, output); err != nil {
+		return err
 	}
 
-	// Save output files only in restore mode
-	if opts.Mode == "restore" && len(opts.Outputs) > 0 {
-		outputsDir := filepath.Join(entryDir, "outputs")
-		if err := os.MkdirAll(outputsDir, 0750); err != nil {
-			return fmt.Errorf("creating outputs dir: %w", err)
+	if opts.HashManifest != nil {
+		if err := writeHashManifest(tmpDir, *opts.HashManifest); err != nil {
+			return err
 		}
+	}
+
+	// Save output files only in restore mode, as a single archive with a manifest
+	if hasOutputsArchive(opts) && output.ExitCode == 0 {
+		seen := make(map[string]struct{})
+		var files []string
 		fsys := os.DirFS(opts.ProjectPath)
 		for _, pattern := range opts.Outputs {
 			matches, err := doublestar.Glob(fsys, pattern)
@@ -319,45 +509,70 @@ func Save(opts CacheOptions, hash string, output string) error {
 				continue
 			}
 			for _, match := range matches {
-				src := filepath.Join(opts.ProjectPath, match)
-				info, err := os.Stat(src)
+				info, err := os.Stat(filepath.Join(opts.ProjectPath, match))
 				if err != nil || info.IsDir() {
 					continue
 				}
-				dst := filepath.Join(outputsDir, match)
-				if err := copyFile(src, dst); err != nil {
-					return fmt.Errorf("caching output file %s: %w", match, err)
+				if _, exists := seen[match]; !exists {
+					seen[match] = struct{}{}
+					files = append(files, match)
 				}
 			}
 		}
+		if err := saveOutputsArchive(tmpDir, opts.ProjectPath, files); err != nil {
+			return err
+		}
+	}
+
+	// keep a valid entry committed in the meantime by a concurrent run, so
+	// readers restoring it are not disturbed
+	localOpts := opts
+	localOpts.Remote = nil
+	existing, _ := Check(localOpts, hash)
+	if err := commitCacheEntry(tmpDir, cacheEntryDir(opts.MonospaceRoot, opts.ProjectName, opts.TaskName, hash), !existing.Hit); err != nil {
+		return err
+	}
+
+	// Prune old entries beyond the configured limit.
+	if opts.MaxEntries > 0 {
+		_ = PruneTaskCache(opts.MonospaceRoot, opts.ProjectName, opts.TaskName, opts.MaxEntries)
+	}
+
+	if opts.Remote != nil && !opts.Remote.ReadOnly {
+		if err := pushRemote(opts, hash); err != nil {
+			return fmt.Errorf("uploading to remote cache: %w", err)
+		}
 	}
 	return nil
 }
 
-// Restore copies cached output files back to the project directory.
+// hasOutputsArchive returns true when cache entries of the task store output files
+func hasOutputsArchive(opts CacheOptions) bool {
+	return opts.Mode == "restore" && len(opts.Outputs) > 0
+}
+
+// Restore extracts cached output files back to the project directory.
 // Only useful in restore mode on a cache hit.
+// Every file is checked against the entry manifest before anything is written
+// to the project, an error wrapping ErrCorruptCacheEntry is returned on mismatch.
 func Restore(opts CacheOptions, result CacheResult) error {
-	outputsDir := filepath.Join(result.CacheDir, "outputs")
-	if _, err := os.Stat(outputsDir); err != nil {
-		if os.IsNotExist(err) {
-			return nil // nothing to restore
-		}
-		return fmt.Errorf("stat outputs dir %s: %w", outputsDir, err)
+	archivePath := filepath.Join(result.CacheDir, outputsArchiveName)
+	if !utils.FileExistsNoErr(archivePath) && !utils.FileExistsNoErr(filepath.Join(result.CacheDir, outputsManifestName)) {
+		return nil // nothing to restore
 	}
-	return filepath.WalkDir(outputsDir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return fmt.Errorf("walk outputs dir %s: %w", outputsDir, err)
-		}
-		if d.IsDir() {
-			return nil
-		}
-		rel, err := filepath.Rel(outputsDir, path)
-		if err != nil {
-			return fmt.Errorf("get relative path for %s: %w", path, err)
-		}
-		dst := filepath.Join(opts.ProjectPath, rel)
-		return copyFile(path, dst)
-	})
+	manifest, err := verifyOutputsArchive(result.CacheDir)
+	if err != nil {
+		return err
+	}
+	if err := verifyOutputsFiles(result.CacheDir, manifest); err != nil {
+		return err
+	}
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("opening outputs archive: %w", err)
+	}
+	defer archive.Close()
+	return unpackDir(archive, opts.ProjectPath)
 }
 
 // readCachedOutput reads the output stored by Save for the given cache entry.
@@ -433,7 +648,7 @@ func GetCacheStatus(monospaceRoot string, filters []string) ([]CacheStatusEntry,
 			continue
 		}
 		for _, hashEntry := range hashEntries {
-			if !hashEntry.IsDir() {
+			if !hashEntry.IsDir() || strings.HasPrefix(hashEntry.Name(), ".") {
 				continue
 			}
 			metaPath := filepath.Join(taskDir, hashEntry.Name(), "metadata.json")
@@ -450,15 +665,16 @@ func GetCacheStatus(monospaceRoot string, filters []string) ([]CacheStatusEntry,
 				Task:     taskName,
 				Hash:     meta.Hash,
 				CachedAt: meta.Timestamp,
+				ExitCode: meta.ExitCode,
 			})
 		}
 	}
 	return result, nil
 }
 
-// PruneTaskCache removes the oldest cache entries for a given project+task pair,
-// keeping at most maxEntries. Entries are sorted by their recorded timestamp;
-// the newest ones are kept.
+// PruneTaskCache removes the least recently used cache entries for a given
+// project+task pair, keeping at most maxEntries. Entries are sorted by their
+// last access time (creation or last cache hit); the newest ones are kept.
 func PruneTaskCache(monospaceRoot, project, task string, maxEntries int) error {
 	taskDir := cacheTaskDir(monospaceRoot, project, task)
 	rawEntries, err := os.ReadDir(taskDir)
@@ -470,69 +686,33 @@ func PruneTaskCache(monospaceRoot, project, task string, maxEntries int) error {
 	}
 
 	type hashEntry struct {
-		dir       string
-		timestamp time.Time
+		dir        string
+		lastAccess time.Time
 	}
 	var entries []hashEntry
 	for _, e := range rawEntries {
-		if !e.IsDir() {
+		// skip files and temporary entries
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
 			continue
 		}
-		metaPath := filepath.Join(taskDir, e.Name(), "metadata.json")
-		data, err := os.ReadFile(metaPath)
+		entryDir := filepath.Join(taskDir, e.Name())
+		lastAccess, err := cacheEntryLastAccess(entryDir)
 		if err != nil {
 			continue
 		}
-		var meta CacheMetadata
-		if err := json.Unmarshal(data, &meta); err != nil {
-			continue
-		}
-		entries = append(entries, hashEntry{
-			dir:       filepath.Join(taskDir, e.Name()),
-			timestamp: meta.Timestamp,
-		})
+		entries = append(entries, hashEntry{dir: entryDir, lastAccess: lastAccess})
 	}
 
 	if len(entries) <= maxEntries {
 		return nil
 	}
 
-	// Keep the newest entries; remove the rest.
+	// Keep the most recently used entries; remove the rest.
 	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].timestamp.After(entries[j].timestamp)
+		return entries[i].lastAccess.After(entries[j].lastAccess)
 	})
 	for _, e := range entries[maxEntries:] {
-		_ = os.RemoveAll(e.dir)
+		_, _ = evictCacheEntry(e.dir, e.lastAccess)
 	}
 	return nil
 }
-
-// copyFile copies a file from src to dst, creating parent directories as needed.
-// It preserves the source file's permissions.
-func copyFile(src, dst string) error {
-	if err := os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
-		return err
-	}
-	srcInfo, err := os.Stat(src)
-	if err != nil {
-		return err
-	}
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer in.Close()
-
-	mode := srcInfo.Mode().Perm()
-	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	if _, err := io.Copy(out, in); err != nil {
-		return err
-	}
-	// Ensure the destination has the same permissions as the source
-	return os.Chmod(dst, mode)
-}
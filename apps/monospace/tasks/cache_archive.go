@@ -0,0 +1,262 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	outputsArchiveName  = "outputs.tar.gz"
+	outputsManifestName = "outputs-manifest.json"
+)
+
+var ErrCorruptCacheEntry = errors.New("corrupt cache entry")
+
+// ArchiveFile describes a file stored in a cache archive.
+type ArchiveFile struct {
+	Path   string      `json:"path"` // slash separated path relative to the archive root
+	Size   int64       `json:"size"`
+	Mode   fs.FileMode `json:"mode"`
+	Sha256 string      `json:"sha256"`
+}
+
+// OutputsManifest is stored next to the outputs archive of a cache entry to
+// check its integrity before restoring it.
+type OutputsManifest struct {
+	ArchiveSha256 string        `json:"archiveSha256"`
+	Files         []ArchiveFile `json:"files"`
+}
+
+// writeArchive writes the given files (slash separated paths relative to root)
+// into w as a gzip compressed tar archive and returns their description.
+func writeArchive(w io.Writer, root string, files []string) ([]ArchiveFile, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	res := make([]ArchiveFile, 0, len(files))
+	for _, file := range files {
+		archived, err := writeArchiveFile(tw, root, file)
+		if err != nil {
+			return nil, fmt.Errorf("archiving %s: %w", file, err)
+		}
+		res = append(res, archived)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return res, gz.Close()
+}
+
+func writeArchiveFile(tw *tar.Writer, root string, file string) (ArchiveFile, error) {
+	path := filepath.Join(root, filepath.FromSlash(file))
+	info, err := os.Stat(path)
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+	header := &tar.Header{
+		Name:    file,
+		Mode:    int64(info.Mode().Perm()),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return ArchiveFile{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, h), f); err != nil {
+		return ArchiveFile{}, err
+	}
+	return ArchiveFile{Path: file, Size: info.Size(), Mode: info.Mode().Perm(), Sha256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// walkArchive calls fn for each regular file of a gzip compressed tar archive.
+// It refuses entries that would be extracted outside of the archive root.
+func walkArchive(r io.Reader, fn func(header *tar.Header, content io.Reader) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("reading archive: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(header.Name)) {
+			return fmt.Errorf("reading archive: invalid path %s", header.Name)
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// packDir writes the regular files of dir into w as a gzip compressed tar
+// archive. Paths in the archive are relative to dir and slash separated.
+func packDir(dir string, w io.Writer) error {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("archiving %s: %w", dir, err)
+	}
+	_, err = writeArchive(w, dir, files)
+	return err
+}
+
+// unpackDir extracts a gzip compressed tar archive into dir.
+func unpackDir(r io.Reader, dir string) error {
+	return walkArchive(r, func(header *tar.Header, content io.Reader) error {
+		if err := extractFile(content, filepath.Join(dir, filepath.FromSlash(header.Name)), os.FileMode(header.Mode).Perm()); err != nil {
+			return fmt.Errorf("extracting %s: %w", header.Name, err)
+		}
+		return nil
+	})
+}
+
+func extractFile(r io.Reader, dst string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// ensure an already existing file gets the archived permissions
+	return os.Chmod(dst, mode)
+}
+
+// saveOutputsArchive stores the given output files of the project in a single
+// archive inside entryDir along with its manifest.
+func saveOutputsArchive(entryDir string, projectPath string, files []string) error {
+	sort.Strings(files)
+	archive, err := os.OpenFile(filepath.Join(entryDir, outputsArchiveName), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return fmt.Errorf("creating outputs archive: %w", err)
+	}
+	h := sha256.New()
+	archived, err := writeArchive(io.MultiWriter(archive, h), projectPath, files)
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing outputs archive: %w", err)
+	}
+	data, err := json.Marshal(OutputsManifest{ArchiveSha256: hex.EncodeToString(h.Sum(nil)), Files: archived})
+	if err != nil {
+		return fmt.Errorf("marshaling outputs manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(entryDir, outputsManifestName), data, 0640); err != nil {
+		return fmt.Errorf("writing outputs manifest: %w", err)
+	}
+	return nil
+}
+
+// verifyOutputsArchive reads the outputs manifest of the cache entry and checks
+// the archive checksum matches. It returns an error wrapping
+// ErrCorruptCacheEntry if the manifest or archive is missing or doesn't match.
+func verifyOutputsArchive(entryDir string) (OutputsManifest, error) {
+	var manifest OutputsManifest
+	data, err := os.ReadFile(filepath.Join(entryDir, outputsManifestName))
+	if err != nil {
+		return manifest, fmt.Errorf("%w: reading outputs manifest: %s", ErrCorruptCacheEntry, err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("%w: unmarshaling outputs manifest: %s", ErrCorruptCacheEntry, err)
+	}
+	archive, err := os.Open(filepath.Join(entryDir, outputsArchiveName))
+	if err != nil {
+		return manifest, fmt.Errorf("%w: %s", ErrCorruptCacheEntry, err)
+	}
+	defer archive.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, archive); err != nil {
+		return manifest, fmt.Errorf("%w: reading outputs archive: %s", ErrCorruptCacheEntry, err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != manifest.ArchiveSha256 {
+		return manifest, fmt.Errorf("%w: outputs archive checksum mismatch", ErrCorruptCacheEntry)
+	}
+	return manifest, nil
+}
+
+// verifyOutputsFiles checks every file of the outputs archive matches the
+// manifest (and that no file is missing or unexpected) without extracting them.
+func verifyOutputsFiles(entryDir string, manifest OutputsManifest) error {
+	expected := make(map[string]string, len(manifest.Files))
+	for _, file := range manifest.Files {
+		expected[file.Path] = file.Sha256
+	}
+	archive, err := os.Open(filepath.Join(entryDir, outputsArchiveName))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrCorruptCacheEntry, err)
+	}
+	defer archive.Close()
+	err = walkArchive(archive, func(header *tar.Header, content io.Reader) error {
+		sum, ok := expected[header.Name]
+		if !ok {
+			return fmt.Errorf("%w: unexpected file %s in outputs archive", ErrCorruptCacheEntry, header.Name)
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, content); err != nil {
+			return fmt.Errorf("%w: reading %s: %s", ErrCorruptCacheEntry, header.Name, err)
+		}
+		if hex.EncodeToString(h.Sum(nil)) != sum {
+			return fmt.Errorf("%w: checksum mismatch for %s", ErrCorruptCacheEntry, header.Name)
+		}
+		delete(expected, header.Name)
+		return nil
+	})
+	if err != nil {
+		if !errors.Is(err, ErrCorruptCacheEntry) {
+			err = fmt.Errorf("%w: %s", ErrCorruptCacheEntry, err)
+		}
+		return err
+	}
+	if len(expected) > 0 {
+		return fmt.Errorf("%w: %d files missing from outputs archive", ErrCorruptCacheEntry, len(expected))
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/software-t-rex/monospace/app"
+)
+
+// cacheEntryInfo describes a cache entry on disk
+type cacheEntryInfo struct {
+	dir        string
+	size       int64
+	lastAccess time.Time
+}
+
+// The last access time of a cache entry is the modification time of its
+// metadata file, updated on each cache hit.
+func touchCacheEntry(entryDir string) {
+	now := time.Now()
+	_ = os.Chtimes(filepath.Join(entryDir, "metadata.json"), now, now)
+}
+
+func cacheEntryLastAccess(entryDir string) (time.Time, error) {
+	info, err := os.Stat(filepath.Join(entryDir, "metadata.json"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// evictCacheEntry removes the entry while holding its task lock. Entries used
+// since lastAccess (ie: being restored by a concurrent run) are kept, it
+// returns false in that case.
+func evictCacheEntry(entryDir string, lastAccess time.Time) (bool, error) {
+	taskDir := filepath.Dir(entryDir)
+	unlock, err := lockCacheTask(taskDir)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	if current, err := cacheEntryLastAccess(entryDir); err != nil || current.After(lastAccess) {
+		return false, nil
+	}
+	// move the entry out of the way first so readers never see a partial entry
+	trashDir, err := newCacheEntryTempDir(taskDir, "evicted")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(trashDir)
+	if err := os.Rename(entryDir, filepath.Join(trashDir, filepath.Base(entryDir))); err != nil {
+		return false, fmt.Errorf("removing cache entry: %w", err)
+	}
+	return true, nil
+}
+
+// list the task cache directories names, skipping hidden ones
+func listCacheTaskDirs(monospaceRoot string) ([]string, error) {
+	entries, err := os.ReadDir(cacheBaseDir(monospaceRoot))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading cache dir: %w", err)
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") && strings.Contains(entry.Name(), "#") {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	return dirs, nil
+}
+
+// list all the entries of the local cache
+func listCacheEntries(monospaceRoot string) ([]cacheEntryInfo, error) {
+	taskDirs, err := listCacheTaskDirs(monospaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	var res []cacheEntryInfo
+	for _, taskDir := range taskDirs {
+		taskPath := filepath.Join(cacheBaseDir(monospaceRoot), taskDir)
+		entries, err := os.ReadDir(taskPath)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			entryDir := filepath.Join(taskPath, entry.Name())
+			lastAccess, err := cacheEntryLastAccess(entryDir)
+			if err != nil {
+				continue
+			}
+			res = append(res, cacheEntryInfo{dir: entryDir, size: dirSize(entryDir), lastAccess: lastAccess})
+		}
+	}
+	return res, nil
+}
+
+// EvictCache removes the local cache entries not used for more than maxAge,
+// then the least recently used ones until the cache size is under maxSize.
+// A zero maxSize or maxAge disables the corresponding limit, the cache is not
+// even listed when both are disabled. Entries are removed under their task
+// lock and entries used in the meantime are kept.
+// It returns the number of removed entries.
+func EvictCache(monospaceRoot string, maxSize int64, maxAge time.Duration) (int, error) {
+	if maxSize <= 0 && maxAge <= 0 {
+		return 0, nil
+	}
+	entries, err := listCacheEntries(monospaceRoot)
+	if err != nil {
+		return 0, err
+	}
+	// least recently used first
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastAccess.Before(entries[j].lastAccess)
+	})
+	var total int64
+	for _, entry := range entries {
+		total += entry.size
+	}
+	removed := 0
+	for _, entry := range entries {
+		expired := maxAge > 0 && time.Since(entry.lastAccess) > maxAge
+		oversized := maxSize > 0 && total > maxSize
+		if !expired && !oversized {
+			break
+		}
+		evicted, err := evictCacheEntry(entry.dir, entry.lastAccess)
+		if errors.Is(err, ErrCacheLocked) {
+			continue // busy task cache, try again next time
+		} else if err != nil {
+			return removed, err
+		} else if !evicted {
+			continue
+		}
+		total -= entry.size
+		removed++
+	}
+	return removed, nil
+}
+
+// EvictCacheFromConfig applies the cache_max_size and cache_max_age settings
+// of the config to the local cache.
+func EvictCacheFromConfig(monospaceRoot string, config *app.MonospaceConfig) (int, error) {
+	maxSize, err := config.GetCacheMaxSize()
+	if err != nil {
+		return 0, err
+	}
+	maxAge, err := config.GetCacheMaxAge()
+	if err != nil {
+		return 0, err
+	}
+	return EvictCache(monospaceRoot, maxSize, maxAge)
+}
+
+// PruneOrphanCache removes the cache of tasks for which exists returns false.
+// It returns the removed tasks names in project#task form.
+func PruneOrphanCache(monospaceRoot string, exists func(project, task string) bool) ([]string, error) {
+	taskDirs, err := listCacheTaskDirs(monospaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, taskDir := range taskDirs {
+		lastHash := strings.LastIndex(taskDir, "#")
+		project := strings.ReplaceAll(taskDir[:lastHash], "__", "/")
+		task := taskDir[lastHash+1:]
+		if exists(project, task) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(cacheBaseDir(monospaceRoot), taskDir)); err != nil {
+			return removed, fmt.Errorf("removing cache of %s#%s: %w", project, task, err)
+		}
+		removed = append(removed, project+"#"+task)
+	}
+	return removed, nil
+}
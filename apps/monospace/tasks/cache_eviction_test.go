@@ -0,0 +1,154 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// save a cache entry for the given task with an output of size bytes last
+// accessed at the given time and return its dir
+func saveTestEntry(t *testing.T, root, project, task, hash string, size int, lastAccess time.Time) string {
+	t.Helper()
+	opts := baseOpts(t.TempDir(), root)
+	opts.ProjectName = project
+	opts.TaskName = task
+	if err := Save(opts, hash, strings.Repeat("x", size)); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	entryDir := cacheEntryDir(root, project, task, hash)
+	if err := os.Chtimes(filepath.Join(entryDir, "metadata.json"), lastAccess, lastAccess); err != nil {
+		t.Fatal(err)
+	}
+	return entryDir
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestCheck_HitUpdatesLastAccess(t *testing.T) {
+	root := t.TempDir()
+	past := time.Now().Add(-time.Hour)
+	entryDir := saveTestEntry(t, root, "myproject", "build", "abc", 10, past)
+	opts := baseOpts(t.TempDir(), root)
+	if result, _ := Check(opts, "abc"); !result.Hit {
+		t.Fatal("expected cache hit")
+	}
+	if lastAccess, _ := cacheEntryLastAccess(entryDir); !lastAccess.After(past) {
+		t.Error("cache hit should update the entry last access time")
+	}
+}
+
+func TestEvictCache_MaxAge(t *testing.T) {
+	root := t.TempDir()
+	old := saveTestEntry(t, root, "a", "build", "old", 10, time.Now().Add(-48*time.Hour))
+	recent := saveTestEntry(t, root, "b", "build", "recent", 10, time.Now().Add(-time.Hour))
+	removed, err := EvictCache(root, 0, 24*time.Hour)
+	if err != nil || removed != 1 {
+		t.Fatalf("EvictCache: removed %d, %v", removed, err)
+	}
+	if pathExists(old) || !pathExists(recent) {
+		t.Error("only entries unused for longer than max age should be removed")
+	}
+}
+
+func TestEvictCache_MaxSizeIsLRU(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	lru := saveTestEntry(t, root, "a", "build", "lru", 1000, now.Add(-3*time.Hour))
+	mid := saveTestEntry(t, root, "b", "build", "mid", 1000, now.Add(-2*time.Hour))
+	mru := saveTestEntry(t, root, "a", "test", "mru", 1000, now.Add(-time.Hour))
+	total := dirSize(lru) + dirSize(mid) + dirSize(mru)
+	removed, err := EvictCache(root, total-1, 0)
+	if err != nil || removed != 1 {
+		t.Fatalf("EvictCache: removed %d, %v", removed, err)
+	}
+	if pathExists(lru) || !pathExists(mid) || !pathExists(mru) {
+		t.Error("least recently used entry should be removed first")
+	}
+	if removed, _ := EvictCache(root, 0, 0); removed != 0 {
+		t.Error("no limits should not remove anything")
+	}
+}
+
+func TestEvictCache_WaitsForTaskLock(t *testing.T) {
+	root := t.TempDir()
+	entryDir := saveTestEntry(t, root, "a", "build", "old", 10, time.Now().Add(-48*time.Hour))
+	unlock, err := lockCacheTask(filepath.Dir(entryDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan int)
+	go func() {
+		removed, _ := EvictCache(root, 0, 24*time.Hour)
+		done <- removed
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if !pathExists(entryDir) {
+		t.Fatal("entry should not be removed while its task cache is locked")
+	}
+	unlock()
+	if removed := <-done; removed != 1 || pathExists(entryDir) {
+		t.Errorf("entry should be removed once the lock is released, removed %d", removed)
+	}
+}
+
+func TestEvictCache_KeepsEntriesUsedMeanwhile(t *testing.T) {
+	root := t.TempDir()
+	listedAccess := time.Now().Add(-48 * time.Hour)
+	entryDir := saveTestEntry(t, root, "a", "build", "used", 10, listedAccess)
+	// a concurrent run hits the entry after it was listed for eviction
+	touchCacheEntry(entryDir)
+	if evicted, err := evictCacheEntry(entryDir, listedAccess); err != nil || evicted {
+		t.Fatalf("evictCacheEntry: evicted %v, %v", evicted, err)
+	}
+	if !pathExists(entryDir) {
+		t.Error("entry used since it was listed should be kept")
+	}
+}
+
+func TestPruneTaskCache_KeepsMostRecentlyUsed(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	// created first but used recently
+	used := saveTestEntry(t, root, "a", "build", "used", 10, now)
+	unused := saveTestEntry(t, root, "a", "build", "unused", 10, now.Add(-time.Hour))
+	if err := PruneTaskCache(root, "a", "build", 1); err != nil {
+		t.Fatalf("PruneTaskCache: %v", err)
+	}
+	if !pathExists(used) || pathExists(unused) {
+		t.Error("pruning should keep the most recently used entries")
+	}
+}
+
+func TestPruneOrphanCache(t *testing.T) {
+	root := t.TempDir()
+	now := time.Now()
+	saveTestEntry(t, root, "apps/web", "build", "a", 10, now)
+	saveTestEntry(t, root, "apps/web", "deleted", "b", 10, now)
+	saveTestEntry(t, root, "gone", "build", "c", 10, now)
+	removed, err := PruneOrphanCache(root, func(project, task string) bool {
+		return project == "apps/web" && task == "build"
+	})
+	if err != nil {
+		t.Fatalf("PruneOrphanCache: %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"apps/web#deleted", "gone#build"}) {
+		t.Errorf("unexpected removed tasks %v", removed)
+	}
+	if !pathExists(cacheTaskDir(root, "apps/web", "build")) {
+		t.Error("cache of existing tasks should be kept")
+	}
+}
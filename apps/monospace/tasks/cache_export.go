@@ -0,0 +1,161 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/software-t-rex/monospace/gomodules/utils"
+)
+
+// CacheExportFilter selects the local cache entries to export
+type CacheExportFilter struct {
+	Filters  []string      // project#task, project or task names (empty = all)
+	Projects []string      // only entries of these projects, in addition to Filters (nil = all)
+	MaxAge   time.Duration // only entries cached more recently (0 = no limit)
+}
+
+// CacheImportResult lists the entries found in an imported archive
+type CacheImportResult struct {
+	Imported []CacheStatusEntry
+	Skipped  []CacheStatusEntry // already in the local cache
+}
+
+// ExportCache writes the selected local cache entries to w as a single gzip
+// compressed tar archive, paths being relative to the cache directory.
+// It returns the exported entries.
+func ExportCache(monospaceRoot string, w io.Writer, filter CacheExportFilter) ([]CacheStatusEntry, error) {
+	entries, err := GetCacheStatus(monospaceRoot, filter.Filters)
+	if err != nil {
+		return nil, err
+	}
+	base := cacheBaseDir(monospaceRoot)
+	var exported []CacheStatusEntry
+	var files []string
+	for _, entry := range entries {
+		if filter.MaxAge > 0 && time.Since(entry.CachedAt) > filter.MaxAge {
+			continue
+		}
+		if filter.Projects != nil && !slices.Contains(filter.Projects, entry.Project) {
+			continue
+		}
+		entryDir := cacheEntryDir(monospaceRoot, entry.Project, entry.Task, entry.Hash)
+		err := filepath.WalkDir(entryDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("exporting cache of %s#%s: %w", entry.Project, entry.Task, err)
+		}
+		exported = append(exported, entry)
+	}
+	if _, err := writeArchive(w, base, files); err != nil {
+		return nil, fmt.Errorf("writing cache export: %w", err)
+	}
+	return exported, nil
+}
+
+// ImportCache merges the entries of an archive written by ExportCache into the
+// local cache. Entries already in the local cache are skipped. Nothing is
+// imported when any entry of the archive is invalid.
+func ImportCache(monospaceRoot string, r io.Reader) (CacheImportResult, error) {
+	var res CacheImportResult
+	base := cacheBaseDir(monospaceRoot)
+	tmpDir, err := newCacheEntryTempDir(base, "import")
+	if err != nil {
+		return res, err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := unpackDir(r, tmpDir); err != nil {
+		return res, fmt.Errorf("reading cache import: %w", err)
+	}
+	taskDirs, err := os.ReadDir(tmpDir)
+	if err != nil {
+		return res, fmt.Errorf("reading cache import: %w", err)
+	}
+	type importedEntry struct {
+		dir   string
+		entry CacheStatusEntry
+	}
+	var pending []importedEntry
+	for _, taskDir := range taskDirs {
+		if !taskDir.IsDir() {
+			return res, fmt.Errorf("%w: unexpected file %s in cache import", ErrCorruptCacheEntry, taskDir.Name())
+		}
+		hashDirs, err := os.ReadDir(filepath.Join(tmpDir, taskDir.Name()))
+		if err != nil {
+			return res, fmt.Errorf("reading cache import: %w", err)
+		}
+		for _, hashDir := range hashDirs {
+			importedDir := filepath.Join(tmpDir, taskDir.Name(), hashDir.Name())
+			meta, err := checkImportedCacheEntry(importedDir, taskDir.Name(), hashDir.Name())
+			if err != nil {
+				return res, err
+			}
+			pending = append(pending, importedEntry{importedDir, CacheStatusEntry{Project: meta.Project, Task: meta.Task, Hash: meta.Hash, CachedAt: meta.Timestamp, ExitCode: meta.ExitCode}})
+		}
+	}
+	for _, imported := range pending {
+		entry := imported.entry
+		entryDir := cacheEntryDir(monospaceRoot, entry.Project, entry.Task, entry.Hash)
+		if utils.FileExistsNoErr(filepath.Join(entryDir, "metadata.json")) {
+			res.Skipped = append(res.Skipped, entry)
+			continue
+		}
+		if err := commitCacheEntry(imported.dir, entryDir, false); err != nil {
+			return res, err
+		}
+		res.Imported = append(res.Imported, entry)
+	}
+	return res, nil
+}
+
+// checkImportedCacheEntry checks the metadata of an imported entry matches its
+// location in the archive and that its outputs archive is intact.
+func checkImportedCacheEntry(entryDir string, taskDirName string, hash string) (CacheMetadata, error) {
+	var meta CacheMetadata
+	location := taskDirName + "/" + hash
+	if !strings.Contains(taskDirName, "#") || strings.HasPrefix(taskDirName, ".") || strings.HasPrefix(hash, ".") {
+		return meta, fmt.Errorf("%w: unexpected entry %s in cache import", ErrCorruptCacheEntry, location)
+	}
+	data, err := os.ReadFile(filepath.Join(entryDir, "metadata.json"))
+	if err != nil {
+		return meta, fmt.Errorf("%w: reading metadata of %s: %s", ErrCorruptCacheEntry, location, err)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("%w: unmarshaling metadata of %s: %s", ErrCorruptCacheEntry, location, err)
+	}
+	if meta.Hash != hash || meta.Project == "" || meta.Task == "" || filepath.Base(cacheTaskDir("", meta.Project, meta.Task)) != taskDirName {
+		return meta, fmt.Errorf("%w: metadata of %s doesn't match its location", ErrCorruptCacheEntry, location)
+	}
+	if utils.FileExistsNoErr(filepath.Join(entryDir, outputsArchiveName)) || utils.FileExistsNoErr(filepath.Join(entryDir, outputsManifestName)) {
+		manifest, err := verifyOutputsArchive(entryDir)
+		if err == nil {
+			err = verifyOutputsFiles(entryDir, manifest)
+		}
+		if err != nil {
+			return meta, fmt.Errorf("%s: %w", location, err)
+		}
+	}
+	return meta, nil
+}
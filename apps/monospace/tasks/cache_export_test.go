@@ -0,0 +1,158 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// saveExportTestEntries saves a restore mode entry for myproject#build and a
+// skip mode entry for other#lint in root and returns their options and hashes
+func saveExportTestEntries(t *testing.T, root string) (CacheOptions, string, CacheOptions, string) {
+	t.Helper()
+	dir := makeTestProject(t, map[string]string{"src/main.go": "main", "dist/out.js": "built"})
+	build := baseOpts(dir, root)
+	build.Mode = "restore"
+	build.Outputs = []string{"dist/**"}
+	buildHash, _ := ComputeHash(build, baseTaskDef([]string{"build"}))
+	if err := Save(build, buildHash, "build output"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	lint := baseOpts(dir, root)
+	lint.ProjectName = "other"
+	lint.TaskName = "lint"
+	lintHash, _ := ComputeHash(lint, baseTaskDef([]string{"lint"}))
+	if err := Save(lint, lintHash, "lint output"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	return build, buildHash, lint, lintHash
+}
+
+func TestExportImportCache_RoundTrip(t *testing.T) {
+	src := t.TempDir()
+	build, buildHash, lint, lintHash := saveExportTestEntries(t, src)
+
+	var archive bytes.Buffer
+	exported, err := ExportCache(src, &archive, CacheExportFilter{Filters: []string{"myproject#build"}})
+	if err != nil {
+		t.Fatalf("ExportCache: %v", err)
+	}
+	if len(exported) != 1 || exported[0].Hash != buildHash {
+		t.Fatalf("unexpected exported entries %+v", exported)
+	}
+
+	dst := t.TempDir()
+	res, err := ImportCache(dst, bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("ImportCache: %v", err)
+	}
+	if len(res.Imported) != 1 || len(res.Skipped) != 0 {
+		t.Fatalf("unexpected import result %+v", res)
+	}
+	build.MonospaceRoot = dst
+	result, err := Check(build, buildHash)
+	if err != nil || !result.Hit {
+		t.Fatalf("imported entry should be a hit, got %+v, %v", result, err)
+	}
+	if out, _ := readCachedOutput(result.CacheDir); out != "build output" {
+		t.Errorf("unexpected imported output %q", out)
+	}
+	lint.MonospaceRoot = dst
+	if result, _ := Check(lint, lintHash); result.Hit {
+		t.Error("filtered out entries should not be exported")
+	}
+
+	// importing again skips the existing entries
+	res, err = ImportCache(dst, bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("ImportCache: %v", err)
+	}
+	if len(res.Imported) != 0 || len(res.Skipped) != 1 {
+		t.Errorf("existing entries should be skipped, got %+v", res)
+	}
+}
+
+func TestExportCache_MaxAge(t *testing.T) {
+	root := t.TempDir()
+	build, buildHash, _, _ := saveExportTestEntries(t, root)
+	// make the build entry an old one
+	metaPath := filepath.Join(cacheEntryDir(root, build.ProjectName, build.TaskName, buildHash), "metadata.json")
+	data, _ := os.ReadFile(metaPath)
+	data = bytes.Replace(data, []byte(`"timestamp":"`+time.Now().Format("2006")), []byte(`"timestamp":"2000`), 1)
+	os.WriteFile(metaPath, data, 0640)
+
+	exported, err := ExportCache(root, &bytes.Buffer{}, CacheExportFilter{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("ExportCache: %v", err)
+	}
+	if len(exported) != 1 || exported[0].Task != "lint" {
+		t.Errorf("only recent entries should be exported, got %+v", exported)
+	}
+}
+
+func TestExportCache_TaskFilterWithProjects(t *testing.T) {
+	root := t.TempDir()
+	saveExportTestEntries(t, root)
+	tests := []struct {
+		name   string
+		filter CacheExportFilter
+		want   []string
+	}{
+		{"all entries", CacheExportFilter{}, []string{"myproject#build", "other#lint"}},
+		{"task filter", CacheExportFilter{Filters: []string{"myproject#build"}}, []string{"myproject#build"}},
+		{"projects only", CacheExportFilter{Projects: []string{"other"}}, []string{"other#lint"}},
+		{"task filter within projects", CacheExportFilter{Filters: []string{"build", "lint"}, Projects: []string{"myproject", "web"}}, []string{"myproject#build"}},
+		{"task filter outside projects", CacheExportFilter{Filters: []string{"myproject#build"}, Projects: []string{"other"}}, nil},
+		{"no matching project", CacheExportFilter{Projects: []string{}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exported, err := ExportCache(root, &bytes.Buffer{}, tt.filter)
+			if err != nil {
+				t.Fatalf("ExportCache: %v", err)
+			}
+			var got []string
+			for _, entry := range exported {
+				got = append(got, entry.Project+"#"+entry.Task)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportCache_RejectsMismatchingMetadata(t *testing.T) {
+	src := t.TempDir()
+	_, buildHash, _, _ := saveExportTestEntries(t, src)
+	// archive an entry under another hash
+	data, err := os.ReadFile(filepath.Join(cacheEntryDir(src, "myproject", "build", buildHash), "metadata.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := makeTestProject(t, map[string]string{"myproject#build/otherhash/metadata.json": string(data)})
+	var archive bytes.Buffer
+	if _, err := writeArchive(&archive, tampered, []string{"myproject#build/otherhash/metadata.json"}); err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	if _, err := ImportCache(dst, &archive); !errors.Is(err, ErrCorruptCacheEntry) {
+		t.Fatalf("expected ErrCorruptCacheEntry, got %v", err)
+	}
+	if entries, _ := GetCacheStatus(dst, nil); len(entries) != 0 {
+		t.Errorf("nothing should be imported from an invalid archive, got %+v", entries)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const cacheLockName = ".lock"
+
+// The lock is only held while committing an entry so a lock older than this
+// was left by a killed process.
+const cacheLockStaleAfter = 30 * time.Second
+const cacheLockTimeout = 10 * time.Second
+
+var ErrCacheLocked = errors.New("cache task directory is locked")
+
+// lockCacheTask acquires the lock file of a task cache directory and returns a
+// function to release it. It works across processes and goroutines.
+func lockCacheTask(taskDir string) (func(), error) {
+	if err := os.MkdirAll(taskDir, 0750); err != nil {
+		return nil, fmt.Errorf("creating cache task dir: %w", err)
+	}
+	lockPath := filepath.Join(taskDir, cacheLockName)
+	deadline := time.Now().Add(cacheLockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("creating cache lock: %w", err)
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > cacheLockStaleAfter {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: %s", ErrCacheLocked, taskDir)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newCacheEntryTempDir creates a hidden temporary directory in the task cache
+// directory to prepare an entry before committing it.
+func newCacheEntryTempDir(taskDir string, prefix string) (string, error) {
+	if err := os.MkdirAll(taskDir, 0750); err != nil {
+		return "", fmt.Errorf("creating cache task dir: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(taskDir, "."+prefix+"-")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary cache dir: %w", err)
+	}
+	return tmpDir, nil
+}
+
+// commitCacheEntry atomically moves a fully written temporary entry to
+// entryDir while holding the task lock. An existing entry is replaced only
+// when replace is true, otherwise the temporary entry is left to the caller.
+func commitCacheEntry(tmpDir string, entryDir string, replace bool) error {
+	taskDir := filepath.Dir(entryDir)
+	unlock, err := lockCacheTask(taskDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := os.Stat(entryDir); err == nil {
+		if !replace {
+			return nil
+		}
+		// move the replaced entry out of the way first so readers never see a partial entry
+		oldDir, err := newCacheEntryTempDir(taskDir, "old")
+		if err != nil {
+			return err
+		}
+		trash := filepath.Join(oldDir, filepath.Base(entryDir))
+		if err := os.Rename(entryDir, trash); err != nil {
+			os.RemoveAll(oldDir)
+			return fmt.Errorf("replacing cache entry: %w", err)
+		}
+		defer os.RemoveAll(oldDir)
+	}
+	if err := os.Rename(tmpDir, entryDir); err != nil {
+		return fmt.Errorf("committing cache entry: %w", err)
+	}
+	return nil
+}
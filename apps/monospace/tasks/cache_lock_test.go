@@ -0,0 +1,114 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSave_ConcurrentWritesOfSameEntry(t *testing.T) {
+	root := t.TempDir()
+	dir := makeTestProject(t, map[string]string{"dist/out.js": "built"})
+	opts := baseOpts(dir, root)
+	opts.Mode = "restore"
+	opts.Outputs = []string{"dist/**"}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			errs <- Save(opts, "samehash", fmt.Sprintf("output %d", i))
+		}(i)
+		go func() {
+			defer wg.Done()
+			// a hit must always be a complete entry
+			if result, err := Check(opts, "samehash"); err != nil {
+				errs <- err
+			} else if result.Hit {
+				errs <- Restore(opts, result)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent cache access: %v", err)
+		}
+	}
+	taskDir := cacheTaskDir(root, opts.ProjectName, opts.TaskName)
+	entries, _ := os.ReadDir(taskDir)
+	if len(entries) != 1 || entries[0].Name() != "samehash" {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("expected only the committed entry to remain, got %v", names)
+	}
+}
+
+func TestCheck_IgnoresUncommittedEntries(t *testing.T) {
+	root := t.TempDir()
+	opts := baseOpts(t.TempDir(), root)
+	tmpDir, err := newCacheEntryTempDir(cacheTaskDir(root, opts.ProjectName, opts.TaskName), "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(tmpDir, "metadata.json"), []byte(`{"hash":"abc"}`), 0640)
+	if result, _ := Check(opts, "abc"); result.Hit {
+		t.Error("an uncommitted entry should not be a hit")
+	}
+	if entries, _ := GetCacheStatus(root, nil); len(entries) != 0 {
+		t.Errorf("uncommitted entries should not be listed, got %v", entries)
+	}
+}
+
+func TestLockCacheTask(t *testing.T) {
+	taskDir := filepath.Join(t.TempDir(), "proj#build")
+	unlock, err := lockCacheTask(taskDir)
+	if err != nil {
+		t.Fatalf("lockCacheTask: %v", err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		unlock2, err := lockCacheTask(taskDir)
+		if err == nil {
+			unlock2()
+		}
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("lock should not be acquired twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("lock should be acquired once released")
+	}
+
+	// stale locks left by killed processes are ignored
+	lockPath := filepath.Join(taskDir, cacheLockName)
+	os.WriteFile(lockPath, []byte("1"), 0640)
+	stale := time.Now().Add(-2 * cacheLockStaleAfter)
+	os.Chtimes(lockPath, stale, stale)
+	unlock, err = lockCacheTask(taskDir)
+	if err != nil {
+		t.Fatalf("stale lock should be taken over: %v", err)
+	}
+	unlock()
+}
@@ -0,0 +1,295 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/software-t-rex/monospace/app"
+	"github.com/software-t-rex/monospace/mono"
+)
+
+const hashManifestName = "hash-manifest.json"
+
+// HashManifest records each component folded into a task hash so we can later
+// tell what changed. Env values are stored as their SHA256 to avoid leaking
+// secrets into the cache, as are parameters values.
+type HashManifest struct {
+	Cmd            string            `json:"cmd,omitempty"`
+	Script         string            `json:"script,omitempty"`         // package.json script body when cmd is not set
+	Env            map[string]string `json:"env,omitempty"`            // task env variable name -> value hash
+	Params         map[string]string `json:"params,omitempty"`         // task parameter name -> value hash
+	Inputs         []string          `json:"inputs,omitempty"`         // sorted input patterns
+	Outputs        []string          `json:"outputs,omitempty"`        // sorted output patterns
+	Dependencies   map[string]string `json:"dependencies,omitempty"`   // dependency task name -> task hash
+	Files          map[string]string `json:"files,omitempty"`          // input file path relative to the project -> file hash
+	GlobalInputs   map[string]string `json:"globalInputs,omitempty"`   // path relative to monospace root -> file hash
+	HashEnv        map[string]string `json:"hashEnv,omitempty"`        // hash_env variable name -> value hash ("" when unset)
+	PassThroughEnv map[string]string `json:"passThroughEnv,omitempty"` // pass_through_env variable name -> value hash ("" when unset)
+}
+
+// hashGlobals returns the global inputs and hashed environment variables
+// values for the task.
+func hashGlobals(opts CacheOptions) (HashManifest, error) {
+	manifest := HashManifest{HashEnv: hashEnvValues(opts.HashEnv), PassThroughEnv: hashEnvValues(opts.PassThroughEnv)}
+	if len(opts.GlobalInputs) == 0 {
+		return manifest, nil
+	}
+	manifest.GlobalInputs = map[string]string{}
+	fsys := os.DirFS(opts.MonospaceRoot)
+	for _, pattern := range opts.GlobalInputs {
+		matches, err := doublestar.Glob(fsys, pattern)
+		if err != nil {
+			return manifest, fmt.Errorf("invalid global input pattern %q: %w", pattern, err)
+		}
+		for _, match := range matches {
+			if _, seen := manifest.GlobalInputs[match]; seen {
+				continue
+			}
+			abs := filepath.Join(opts.MonospaceRoot, match)
+			info, err := os.Stat(abs)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			fileHash, err := hashFile(abs, info, opts.Strategy, opts.fileHashes)
+			if err != nil {
+				return manifest, fmt.Errorf("hashing global input %q for %s#%s: %w", match, opts.ProjectName, opts.TaskName, err)
+			}
+			manifest.GlobalInputs[match] = fileHash
+		}
+	}
+	return manifest, nil
+}
+
+// hashEnvValues returns the hash of the value of each given environment
+// variable, "" for unset variables
+func hashEnvValues(names []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(names))
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			res[name] = hashString(value)
+		} else {
+			res[name] = ""
+		}
+	}
+	return res
+}
+
+// hashFile returns the hash of a single file according to the cache strategy
+func hashFile(path string, info os.FileInfo, strategy string, index *fileHashIndex) (string, error) {
+	if strategy == app.CacheStrategyMtime {
+		return fmt.Sprintf("%d:%d", info.Size(), info.ModTime().Unix()), nil
+	}
+	return index.hashFile(path)
+}
+
+func hashString(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// write the global inputs and hashed env values into the task hash
+func (m HashManifest) writeGlobalsTo(w io.Writer) {
+	for _, name := range sortedKeys(m.HashEnv) {
+		fmt.Fprintf(w, "hash_env:%s=%s\n", name, m.HashEnv[name])
+	}
+	for _, name := range sortedKeys(m.PassThroughEnv) {
+		fmt.Fprintf(w, "pass_through_env:%s=%s\n", name, m.PassThroughEnv[name])
+	}
+	for _, path := range sortedKeys(m.GlobalInputs) {
+		fmt.Fprintf(w, "global:%s:%s\n", path, m.GlobalInputs[path])
+	}
+}
+
+// Changes returns a description of the components that differ between the
+// manifest and the current one.
+func (m HashManifest) Changes(current HashManifest) []string {
+	var changes []string
+	if m.Cmd != current.Cmd {
+		changes = append(changes, "cmd")
+	}
+	if m.Script != current.Script {
+		changes = append(changes, "package.json script")
+	}
+	changes = append(changes, mapChanges("env", m.Env, current.Env)...)
+	changes = append(changes, mapChanges("param", m.Params, current.Params)...)
+	if strings.Join(m.Inputs, ",") != strings.Join(current.Inputs, ",") {
+		changes = append(changes, "inputs patterns")
+	}
+	if strings.Join(m.Outputs, ",") != strings.Join(current.Outputs, ",") {
+		changes = append(changes, "outputs patterns")
+	}
+	changes = append(changes, mapChanges("hash_env", m.HashEnv, current.HashEnv)...)
+	changes = append(changes, mapChanges("pass_through_env", m.PassThroughEnv, current.PassThroughEnv)...)
+	changes = append(changes, mapChanges("dependency", m.Dependencies, current.Dependencies)...)
+	changes = append(changes, mapChanges("global input", m.GlobalInputs, current.GlobalInputs)...)
+	changes = append(changes, mapChanges("file", m.Files, current.Files)...)
+	return changes
+}
+
+// return "label key" for each key whose value differs between stored and current
+func mapChanges(label string, stored, current map[string]string) []string {
+	var changes []string
+	for _, key := range sortedKeys(mergeKeys(stored, current)) {
+		was, wasSet := stored[key]
+		now, isSet := current[key]
+		switch {
+		case !wasSet:
+			changes = append(changes, label+" "+key+" (added)")
+		case !isSet:
+			changes = append(changes, label+" "+key+" (removed)")
+		case was != now:
+			changes = append(changes, label+" "+key)
+		}
+	}
+	return changes
+}
+
+// CacheEntryChanges returns what changed in global inputs and hashed
+// environment variables since the given cache entry was created. Entries
+// created without a hash manifest return no changes.
+func CacheEntryChanges(opts CacheOptions, hash string) ([]string, error) {
+	stored, err := readHashManifest(cacheEntryDir(opts.MonospaceRoot, opts.ProjectName, opts.TaskName, hash))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	current, err := hashGlobals(opts)
+	if err != nil {
+		return nil, err
+	}
+	// only compare global values, other components are specific to each run
+	stored = HashManifest{GlobalInputs: stored.GlobalInputs, HashEnv: stored.HashEnv, PassThroughEnv: stored.PassThroughEnv}
+	return stored.Changes(current), nil
+}
+
+// CacheExplanation compares the current hash manifest of a task with the one
+// of its most recent cache entry.
+type CacheExplanation struct {
+	Task        string
+	Hash        string            // current task hash
+	Entry       *CacheStatusEntry // entry matching the current hash, else the most recent one, nil if none
+	HasManifest bool              // false when the entry was cached without a hash manifest
+	Changes     []string
+}
+
+// ExplainTaskCache computes the current hash of the given task (in project#task
+// form) with the given params values (see TaskList.SetParams). When no cache
+// entry matches that hash, it lists what changed since the most recent entry
+// of the task.
+func ExplainTaskCache(taskName string, params map[string]string, config *app.MonospaceConfig) (CacheExplanation, error) {
+	var res CacheExplanation
+	pipeline, err := GetStandardizedPipeline(config, true)
+	if err != nil {
+		return res, err
+	}
+	name := ParseTaskName(taskName, config)
+	task := pipeline.TaskLookup(name.Task, name.Project, config)
+	if task == nil {
+		return res, fmt.Errorf("%w: %s", ErrUnknownTask, taskName)
+	}
+	res.Task = task.String()
+	if task.TaskDef.Cache != "skip" && task.TaskDef.Cache != "restore" {
+		return res, fmt.Errorf("task %s has no cache enabled", res.Task)
+	}
+	monospaceRoot := mono.SpaceGetRoot()
+	taskList := pipeline.NewTaskList(config)
+	taskList.AddTask(task, true)
+	if err := taskList.SetParams(params); err != nil {
+		return res, err
+	}
+	hash, current, err := newTaskHasher(taskList, monospaceRoot, nil).memoizedHash(task)
+	if err != nil {
+		return res, err
+	}
+	res.Hash = hash
+
+	entries, err := GetCacheStatus(monospaceRoot, []string{res.Task})
+	if err != nil {
+		return res, err
+	}
+	for i, entry := range entries {
+		if entry.Hash == hash {
+			// an older entry can still be hit (ie: after switching back to a branch)
+			res.Entry = &entries[i]
+			break
+		}
+		if res.Entry == nil || entry.CachedAt.After(res.Entry.CachedAt) {
+			res.Entry = &entries[i]
+		}
+	}
+	if res.Entry == nil || res.Entry.Hash == hash {
+		res.HasManifest = res.Entry != nil
+		return res, nil
+	}
+	stored, err := readHashManifest(cacheEntryDir(monospaceRoot, task.Name.Project, task.Name.Task, res.Entry.Hash))
+	if os.IsNotExist(err) {
+		return res, nil
+	} else if err != nil {
+		return res, err
+	}
+	res.HasManifest = true
+	res.Changes = stored.Changes(current)
+	return res, nil
+}
+
+func readHashManifest(entryDir string) (HashManifest, error) {
+	var manifest HashManifest
+	data, err := os.ReadFile(filepath.Join(entryDir, hashManifestName))
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("unmarshaling hash manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+func writeHashManifest(entryDir string, manifest HashManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("marshaling hash manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(entryDir, hashManifestName), data, 0640); err != nil {
+		return fmt.Errorf("writing hash manifest: %w", err)
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func mergeKeys(a, b map[string]string) map[string]string {
+	res := make(map[string]string, len(a)+len(b))
+	for k := range a {
+		res[k] = ""
+	}
+	for k := range b {
+		res[k] = ""
+	}
+	return res
+}
@@ -0,0 +1,277 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/software-t-rex/monospace/app"
+)
+
+func TestComputeHash_GlobalInputChangeBustsCache(t *testing.T) {
+	root := makeTestProject(t, map[string]string{"pnpm-lock.yaml": "v1", "tsconfig.base.json": "{}"})
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, root)
+	opts.GlobalInputs = []string{"pnpm-lock.yaml", "*.json"}
+	taskDef := baseTaskDef([]string{"go", "build"})
+
+	h1, err := ComputeHash(opts, taskDef)
+	if err != nil {
+		t.Fatalf("ComputeHash: %v", err)
+	}
+	os.WriteFile(filepath.Join(root, "pnpm-lock.yaml"), []byte("v2"), 0644)
+	h2, _ := ComputeHash(opts, taskDef)
+	if h1 == h2 {
+		t.Error("expected hash to change when a global input changes")
+	}
+}
+
+func TestComputeHash_HashEnvValueBustsCache(t *testing.T) {
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, t.TempDir())
+	opts.HashEnv = []string{"MONOSPACE_TEST_HASH_ENV"}
+	taskDef := baseTaskDef([]string{"go", "build"})
+
+	t.Setenv("MONOSPACE_TEST_HASH_ENV", "development")
+	h1, _ := ComputeHash(opts, taskDef)
+	t.Setenv("MONOSPACE_TEST_HASH_ENV", "production")
+	h2, _ := ComputeHash(opts, taskDef)
+	if h1 == h2 {
+		t.Error("expected hash to change when a hash_env value changes")
+	}
+}
+
+func TestComputeHash_ParamValueBustsCache(t *testing.T) {
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, t.TempDir())
+	taskDef := baseTaskDef([]string{"./deploy.sh", "${env}"})
+
+	opts.Params = map[string]string{"env": "dev"}
+	h1, m1, _ := computeHash(opts, taskDef)
+	opts.Params = map[string]string{"env": "prod"}
+	h2, m2, _ := computeHash(opts, taskDef)
+	if h1 == h2 {
+		t.Error("expected hash to change when a param value changes")
+	}
+	if changes := m1.Changes(m2); !reflect.DeepEqual(changes, []string{"param env"}) {
+		t.Errorf("expected param env change, got %v", changes)
+	}
+}
+
+func TestComputeHash_PassThroughEnvValueBustsCache(t *testing.T) {
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, t.TempDir())
+	taskDef := baseTaskDef([]string{"go", "build"})
+	opts.PassThroughEnv = []string{"MONOSPACE_TEST_TOKEN"}
+
+	t.Setenv("MONOSPACE_TEST_TOKEN", "one")
+	h1, m1, _ := computeHash(opts, taskDef)
+	t.Setenv("MONOSPACE_TEST_TOKEN", "two")
+	h2, m2, _ := computeHash(opts, taskDef)
+	if h1 == h2 {
+		t.Error("expected hash to change when a pass_through_env value changes")
+	}
+	if changes := m1.Changes(m2); !reflect.DeepEqual(changes, []string{"pass_through_env MONOSPACE_TEST_TOKEN"}) {
+		t.Errorf("expected pass_through_env change, got %v", changes)
+	}
+}
+
+func TestCacheEntryChanges(t *testing.T) {
+	root := makeTestProject(t, map[string]string{"pnpm-lock.yaml": "v1"})
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, root)
+	opts.GlobalInputs = []string{"*.yaml", "*.json"}
+	opts.HashEnv = []string{"MONOSPACE_TEST_HASH_ENV"}
+	t.Setenv("MONOSPACE_TEST_HASH_ENV", "development")
+
+	hash, manifest, err := computeHash(opts, baseTaskDef([]string{"go", "build"}))
+	if err != nil {
+		t.Fatalf("computeHash: %v", err)
+	}
+	opts.HashManifest = &manifest
+	if err := Save(opts, hash, "out"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	opts.HashManifest = nil
+
+	changes, err := CacheEntryChanges(opts, hash)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v, %v", changes, err)
+	}
+
+	os.WriteFile(filepath.Join(root, "pnpm-lock.yaml"), []byte("v2"), 0644)
+	os.WriteFile(filepath.Join(root, "tsconfig.json"), []byte("{}"), 0644)
+	t.Setenv("MONOSPACE_TEST_HASH_ENV", "production")
+	changes, err = CacheEntryChanges(opts, hash)
+	if err != nil {
+		t.Fatalf("CacheEntryChanges: %v", err)
+	}
+	expected := []string{"hash_env MONOSPACE_TEST_HASH_ENV", "global input pnpm-lock.yaml", "global input tsconfig.json (added)"}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("got %v, want %v", changes, expected)
+	}
+}
+
+func TestCacheEntryChanges_NoManifest(t *testing.T) {
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, t.TempDir())
+	hash, _ := ComputeHash(opts, baseTaskDef([]string{"go", "build"}))
+	if err := Save(opts, hash, "out"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	changes, err := CacheEntryChanges(opts, hash)
+	if err != nil || changes != nil {
+		t.Errorf("expected no changes for entries without manifest, got %v, %v", changes, err)
+	}
+}
+
+func TestHashManifest_Changes(t *testing.T) {
+	dir := makeTestProject(t, map[string]string{"a.go": "a", "b.go": "b"})
+	opts := baseOpts(dir, t.TempDir())
+	opts.DependencyHashes = map[string]string{"lib#build": "abc"}
+	_, stored, err := computeHash(opts, baseTaskDef([]string{"go", "build"}))
+	if err != nil {
+		t.Fatalf("computeHash: %v", err)
+	}
+	if len(stored.Files) != 2 || stored.Cmd != "go build" || stored.Dependencies["lib#build"] != "abc" {
+		t.Fatalf("unexpected manifest %+v", stored)
+	}
+
+	os.WriteFile(filepath.Join(dir, "a.go"), []byte("changed"), 0644)
+	os.Remove(filepath.Join(dir, "b.go"))
+	os.WriteFile(filepath.Join(dir, "c.go"), []byte("c"), 0644)
+	opts.DependencyHashes["lib#build"] = "def"
+	opts.Inputs = []string{"*.go"}
+	taskDef := baseTaskDef([]string{"go", "build", "-v"})
+	taskDef.Env = map[string]string{"GOOS": "linux"}
+	_, current, err := computeHash(opts, taskDef)
+	if err != nil {
+		t.Fatalf("computeHash: %v", err)
+	}
+	expected := []string{
+		"cmd",
+		"env GOOS (added)",
+		"inputs patterns",
+		"dependency lib#build",
+		"file a.go",
+		"file b.go (removed)",
+		"file c.go (added)",
+	}
+	if changes := stored.Changes(current); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("got %v, want %v", changes, expected)
+	}
+}
+
+func TestExplainTaskCache(t *testing.T) {
+	root := makeTestProject(t, map[string]string{
+		".monospace/monospace.yml": "projects: {}",
+		"lib/a.go":                 "a",
+	})
+	useTestMonospace(t, root)
+	config := &app.MonospaceConfig{
+		Projects: map[string]string{"lib": "internal"},
+		Pipeline: map[string]app.MonospaceConfigTask{"lib#build": {Cmd: []string{"build"}, Cache: "skip"}},
+	}
+
+	explanation, err := ExplainTaskCache("lib#build", nil, config)
+	if err != nil {
+		t.Fatalf("ExplainTaskCache: %v", err)
+	}
+	if explanation.Entry != nil {
+		t.Fatalf("expected no cache entry, got %+v", explanation.Entry)
+	}
+
+	// save an entry the same way a run would
+	task := NewTask("lib#build", config.Pipeline["lib#build"])
+	opts := TaskCacheOptions(task, config, root)
+	hash, manifest, _ := computeHash(opts, task.TaskDef)
+	opts.HashManifest = &manifest
+	if err := Save(opts, hash, "out"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheEntryDir(root, "lib", "build", hash), hashManifestName)); err != nil {
+		t.Fatalf("hash manifest not saved next to metadata: %v", err)
+	}
+	explanation, _ = ExplainTaskCache("lib#build", nil, config)
+	if explanation.Entry == nil || explanation.Hash != hash || len(explanation.Changes) != 0 {
+		t.Fatalf("expected a matching entry, got %+v", explanation)
+	}
+
+	os.WriteFile(filepath.Join(root, "lib/a.go"), []byte("changed"), 0644)
+	explanation, err = ExplainTaskCache("lib#build", nil, config)
+	if err != nil {
+		t.Fatalf("ExplainTaskCache: %v", err)
+	}
+	if !explanation.HasManifest || !reflect.DeepEqual(explanation.Changes, []string{"file a.go"}) {
+		t.Errorf("expected a.go change, got %+v", explanation)
+	}
+
+	// an older entry matching the current hash is a hit, not the newest one
+	changedHash, changedManifest, _ := computeHash(opts, task.TaskDef)
+	opts.HashManifest = &changedManifest
+	Save(opts, changedHash, "out")
+	os.WriteFile(filepath.Join(root, "lib/a.go"), []byte("a"), 0644)
+	explanation, _ = ExplainTaskCache("lib#build", nil, config)
+	if explanation.Entry == nil || explanation.Entry.Hash != hash || explanation.Hash != hash || len(explanation.Changes) != 0 {
+		t.Errorf("expected the first entry to match, got %+v", explanation)
+	}
+
+	if _, err := ExplainTaskCache("lib#test", nil, config); !errors.Is(err, ErrUnknownTask) {
+		t.Errorf("expected ErrUnknownTask, got %v", err)
+	}
+}
+
+func TestExplainTaskCache_Params(t *testing.T) {
+	root := makeTestProject(t, map[string]string{
+		".monospace/monospace.yml": "projects: {}",
+		"web/a.go":                 "a",
+	})
+	useTestMonospace(t, root)
+	config := &app.MonospaceConfig{
+		Projects: map[string]string{"web": "internal"},
+		Pipeline: map[string]app.MonospaceConfigTask{"web#deploy": {
+			Cmd:    []string{"deploy", "${env}"},
+			Cache:  "skip",
+			Params: map[string]app.MonospaceConfigTaskParam{"env": {Default: "dev", Choices: []string{"dev", "prod"}}},
+		}},
+	}
+
+	// save an entry the same way a run without --param would
+	taskList := TaskList{List: map[string]*Task{"web#deploy": NewTask("web#deploy", config.Pipeline["web#deploy"])}}
+	if err := taskList.SetParams(nil); err != nil {
+		t.Fatalf("SetParams: %v", err)
+	}
+	opts := TaskCacheOptions(taskList.List["web#deploy"], config, root)
+	hash, manifest, _ := computeHash(opts, taskList.List["web#deploy"].TaskDef)
+	opts.HashManifest = &manifest
+	if err := Save(opts, hash, "out"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	explanation, err := ExplainTaskCache("web#deploy", nil, config)
+	if err != nil {
+		t.Fatalf("ExplainTaskCache: %v", err)
+	}
+	if explanation.Entry == nil || explanation.Hash != hash {
+		t.Errorf("default params should match the saved entry, got %+v", explanation)
+	}
+	explanation, err = ExplainTaskCache("web#deploy", map[string]string{"env": "prod"}, config)
+	if err != nil {
+		t.Fatalf("ExplainTaskCache: %v", err)
+	}
+	if explanation.Hash == hash || !reflect.DeepEqual(explanation.Changes, []string{"param env"}) {
+		t.Errorf("expected env param change, got %+v", explanation)
+	}
+	if _, err := ExplainTaskCache("web#deploy", map[string]string{"env": "staging"}, config); !errors.Is(err, app.ErrInvalidTaskParam) {
+		t.Errorf("expected ErrInvalidTaskParam, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	outputStreamsName = "output-streams.json"
+	StreamStdout      = "stdout"
+	StreamStderr      = "stderr"
+)
+
+// OutputChunk is a piece of a task output as written by the task
+type OutputChunk struct {
+	Stream string        `json:"stream"` // StreamStdout | StreamStderr
+	Offset time.Duration `json:"offset"` // elapsed time since the task start
+	Data   string        `json:"data"`
+}
+
+// TaskOutput is the recorded output of a task run
+type TaskOutput struct {
+	Duration time.Duration `json:"duration"`
+	ExitCode int           `json:"exitCode"`
+	Chunks   []OutputChunk `json:"chunks"`
+}
+
+// Combined returns stdout and stderr as they were interleaved during the run
+func (o TaskOutput) Combined() string {
+	sb := strings.Builder{}
+	for _, chunk := range o.Chunks {
+		sb.WriteString(chunk.Data)
+	}
+	return sb.String()
+}
+
+// Replay writes each chunk to the writer of its stream, nil writers discard
+// their stream.
+func (o TaskOutput) Replay(stdout io.Writer, stderr io.Writer) {
+	for _, chunk := range o.Chunks {
+		w := stdout
+		if chunk.Stream == StreamStderr {
+			w = stderr
+		}
+		if w != nil {
+			io.WriteString(w, chunk.Data)
+		}
+	}
+}
+
+// outputRecorder records what a command writes to its stdout and stderr,
+// optionally forwarding it to other writers as it comes.
+type outputRecorder struct {
+	mu     sync.Mutex
+	start  time.Time
+	output TaskOutput
+}
+
+type recorderWriter struct {
+	recorder *outputRecorder
+	stream   string
+	forward  io.Writer
+}
+
+func newOutputRecorder() *outputRecorder {
+	return &outputRecorder{start: time.Now()}
+}
+
+// writer returns a writer recording the given stream and forwarding it to
+// forward when not nil
+func (r *outputRecorder) writer(stream string, forward io.Writer) io.Writer {
+	return &recorderWriter{recorder: r, stream: stream, forward: forward}
+}
+
+func (w *recorderWriter) Write(p []byte) (int, error) {
+	r := w.recorder
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.output.Chunks = append(r.output.Chunks, OutputChunk{Stream: w.stream, Offset: time.Since(r.start), Data: string(p)})
+	if w.forward != nil {
+		// forwarding errors must not fail the command
+		w.forward.Write(p)
+	}
+	return len(p), nil
+}
+
+// done returns the recorded output
+func (r *outputRecorder) done(exitCode int) TaskOutput {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.output.Duration = time.Since(r.start)
+	r.output.ExitCode = exitCode
+	return r.output
+}
+
+func writeOutputStreams(entryDir string, output TaskOutput) error {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return fmt.Errorf("marshaling cached output streams: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(entryDir, outputStreamsName), data, 0640); err != nil {
+		return fmt.Errorf("writing cached output streams: %w", err)
+	}
+	return nil
+}
+
+// readCachedStreams reads the output streams stored by SaveOutput for the
+// given cache entry. Entries without streams return their combined output as
+// stdout.
+func readCachedStreams(cacheDir string) (TaskOutput, error) {
+	var output TaskOutput
+	data, err := os.ReadFile(filepath.Join(cacheDir, outputStreamsName))
+	if os.IsNotExist(err) {
+		combined, err := readCachedOutput(cacheDir)
+		if combined != "" {
+			output.Chunks = []OutputChunk{{Stream: StreamStdout, Data: combined}}
+		}
+		return output, err
+	} else if err != nil {
+		return output, err
+	}
+	if err := json.Unmarshal(data, &output); err != nil {
+		return output, fmt.Errorf("unmarshaling cached output streams: %w", err)
+	}
+	return output, nil
+}
@@ -0,0 +1,273 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/software-t-rex/monospace/app"
+)
+
+var ErrRemoteCacheMiss = errors.New("remote cache entry not found")
+
+// CacheStorage is a shared storage for cache entries. Entries are gzip
+// compressed tar archives of a local cache entry directory, identified by a
+// slash separated key ("{project}#{task}/{hash}.tar.gz").
+type CacheStorage interface {
+	// Get returns the archive stored under key or ErrRemoteCacheMiss
+	Get(key string) (io.ReadCloser, error)
+	// Put stores the archive read from r under key
+	Put(key string, r io.Reader) error
+	// Exists returns true if an archive is stored under key, without reading it
+	Exists(key string) (bool, error)
+}
+
+// RemoteCache is a CacheStorage with its access mode as set in monospace.yml
+type RemoteCache struct {
+	Storage  CacheStorage
+	ReadOnly bool
+}
+
+// NewRemoteCache returns the remote cache configured in monospace.yml or nil
+// if none is configured. url starting with http:// or https:// use the http
+// backend, anything else is considered a directory path (relative paths are
+// relative to the monospace root).
+func NewRemoteCache(config *app.MonospaceConfigRemoteCache, monospaceRoot string) (*RemoteCache, error) {
+	if config == nil || config.Url == "" {
+		return nil, nil
+	}
+	var readOnly bool
+	switch config.Mode {
+	case "", app.RemoteCacheModeReadWrite:
+	case app.RemoteCacheModeReadOnly:
+		readOnly = true
+	default:
+		return nil, fmt.Errorf("remote_cache: invalid mode %s", config.Mode)
+	}
+	if strings.HasPrefix(config.Url, "http://") || strings.HasPrefix(config.Url, "https://") {
+		headers := make(map[string]string, len(config.Headers))
+		for k, v := range config.Headers {
+			headers[k] = os.ExpandEnv(v)
+		}
+		return &RemoteCache{Storage: NewHttpCacheStorage(config.Url, headers), ReadOnly: readOnly}, nil
+	}
+	dir := strings.TrimPrefix(os.ExpandEnv(config.Url), "file://")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(monospaceRoot, dir)
+	}
+	return &RemoteCache{Storage: NewDirCacheStorage(dir), ReadOnly: readOnly}, nil
+}
+
+// remoteCacheKey returns the key of the entry for given task and hash
+func remoteCacheKey(project, task, hash string) string {
+	return strings.ReplaceAll(project, "/", "__") + "#" + task + "/" + hash + ".tar.gz"
+}
+
+// fetchRemote downloads the entry for hash from the remote cache and extracts it
+// in the local cache. It returns ErrRemoteCacheMiss if the remote has no such entry.
+func fetchRemote(opts CacheOptions, hash string) error {
+	body, err := opts.Remote.Storage.Get(remoteCacheKey(opts.ProjectName, opts.TaskName, hash))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	// extract in a temporary dir so a partial download never looks like a valid entry
+	tmpDir, err := newCacheEntryTempDir(cacheTaskDir(opts.MonospaceRoot, opts.ProjectName, opts.TaskName), "remote")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := unpackDir(body, tmpDir); err != nil {
+		return err
+	}
+	return commitCacheEntry(tmpDir, cacheEntryDir(opts.MonospaceRoot, opts.ProjectName, opts.TaskName, hash), true)
+}
+
+// existsRemote returns true if the remote cache has an entry for hash, the
+// entry is neither downloaded nor checked.
+func existsRemote(opts CacheOptions, hash string) (bool, error) {
+	return opts.Remote.Storage.Exists(remoteCacheKey(opts.ProjectName, opts.TaskName, hash))
+}
+
+// pushRemote uploads the local cache entry for hash to the remote cache.
+// The entry is packed to a temporary file first so the upload is streamed from
+// disk with a known size instead of holding the whole archive in memory.
+func pushRemote(opts CacheOptions, hash string) error {
+	taskDir := cacheTaskDir(opts.MonospaceRoot, opts.ProjectName, opts.TaskName)
+	archive, err := os.CreateTemp(taskDir, ".push-*.tar.gz")
+	if err != nil {
+		return fmt.Errorf("creating temporary cache archive: %w", err)
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	if err := packDir(cacheEntryDir(opts.MonospaceRoot, opts.ProjectName, opts.TaskName, hash), archive); err != nil {
+		return err
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return opts.Remote.Storage.Put(remoteCacheKey(opts.ProjectName, opts.TaskName, hash), archive)
+}
+
+//######################### http storage #########################//
+
+type httpCacheStorage struct {
+	baseUrl string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewHttpCacheStorage returns a CacheStorage that GET and PUT entries at
+// baseUrl/{key} (key segments are path escaped) and checks their existence
+// with HEAD requests. A 404 response is a cache miss. Given headers are added to every request.
+func NewHttpCacheStorage(baseUrl string, headers map[string]string) CacheStorage {
+	return &httpCacheStorage{
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		headers: headers,
+		client:  &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+func (s *httpCacheStorage) url(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return s.baseUrl + "/" + strings.Join(segments, "/")
+}
+
+func (s *httpCacheStorage) do(method string, key string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	// http.NewRequest only knows the length of in memory bodies, set it for
+	// files so uploads are not sent with chunked encoding
+	if f, ok := body.(*os.File); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = info.Size()
+	}
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/gzip")
+	}
+	return s.client.Do(req)
+}
+
+func (s *httpCacheStorage) Get(key string) (io.ReadCloser, error) {
+	res, err := s.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("remote cache: %w", err)
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, ErrRemoteCacheMiss
+	} else if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		return nil, fmt.Errorf("remote cache: GET %s: %s", key, res.Status)
+	}
+	return res.Body, nil
+}
+
+func (s *httpCacheStorage) Exists(key string) (bool, error) {
+	res, err := s.do(http.MethodHead, key, nil)
+	if err != nil {
+		return false, fmt.Errorf("remote cache: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	} else if res.StatusCode < 200 || res.StatusCode > 299 {
+		return false, fmt.Errorf("remote cache: HEAD %s: %s", key, res.Status)
+	}
+	return true, nil
+}
+
+func (s *httpCacheStorage) Put(key string, r io.Reader) error {
+	res, err := s.do(http.MethodPut, key, r)
+	if err != nil {
+		return fmt.Errorf("remote cache: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("remote cache: PUT %s: %s", key, res.Status)
+	}
+	return nil
+}
+
+//######################### directory storage #########################//
+
+type dirCacheStorage struct {
+	dir string
+}
+
+// NewDirCacheStorage returns a CacheStorage that keeps entries as files in dir,
+// typically a shared network mount.
+func NewDirCacheStorage(dir string) CacheStorage {
+	return &dirCacheStorage{dir: dir}
+}
+
+func (s *dirCacheStorage) Get(key string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrRemoteCacheMiss
+	} else if err != nil {
+		return nil, fmt.Errorf("remote cache: %w", err)
+	}
+	return f, nil
+}
+
+func (s *dirCacheStorage) Exists(key string) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("remote cache: %w", err)
+	}
+	return true, nil
+}
+
+func (s *dirCacheStorage) Put(key string, r io.Reader) error {
+	dst := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dst), 0750); err != nil {
+		return fmt.Errorf("remote cache: %w", err)
+	}
+	// write to a temporary file first so readers never see partial entries
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("remote cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("remote cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("remote cache: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0640); err != nil {
+		return fmt.Errorf("remote cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return fmt.Errorf("remote cache: %w", err)
+	}
+	return nil
+}
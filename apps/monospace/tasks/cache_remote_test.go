@@ -0,0 +1,210 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/software-t-rex/monospace/app"
+)
+
+// newTestCacheServer starts an in memory GET/PUT cache server and returns it
+// with the map of stored entries indexed by request path. Like most object
+// stores, it rejects uploads without a Content-Length.
+func newTestCacheServer(t *testing.T, token string) (*httptest.Server, map[string][]byte) {
+	t.Helper()
+	var mu sync.Mutex
+	entries := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			data, ok := entries[r.URL.EscapedPath()]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		case http.MethodPut:
+			if r.ContentLength < 0 {
+				w.WriteHeader(http.StatusLengthRequired)
+				return
+			}
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			entries[r.URL.EscapedPath()] = data
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, entries
+}
+
+// ─── NewRemoteCache ────────────────────────────────────────────────────────────
+
+func TestNewRemoteCache(t *testing.T) {
+	root := t.TempDir()
+	remote, err := NewRemoteCache(nil, root)
+	if err != nil || remote != nil {
+		t.Errorf("no config should return no remote cache, got %v, %v", remote, err)
+	}
+	remote, err = NewRemoteCache(&app.MonospaceConfigRemoteCache{Url: "https://cache.example.com", Mode: app.RemoteCacheModeReadOnly}, root)
+	if err != nil {
+		t.Fatalf("NewRemoteCache: %v", err)
+	}
+	if _, ok := remote.Storage.(*httpCacheStorage); !ok || !remote.ReadOnly {
+		t.Errorf("expected read-only http storage, got %#v", remote)
+	}
+	remote, err = NewRemoteCache(&app.MonospaceConfigRemoteCache{Url: "shared/cache"}, root)
+	if err != nil {
+		t.Fatalf("NewRemoteCache: %v", err)
+	}
+	if storage, ok := remote.Storage.(*dirCacheStorage); !ok || remote.ReadOnly || storage.dir != filepath.Join(root, "shared/cache") {
+		t.Errorf("expected read-write directory storage relative to root, got %#v", remote)
+	}
+	if _, err := NewRemoteCache(&app.MonospaceConfigRemoteCache{Url: "shared", Mode: "write-only"}, root); err == nil {
+		t.Error("expected error on invalid mode")
+	}
+}
+
+// ─── http storage ──────────────────────────────────────────────────────────────
+
+func TestHttpCacheStorage_MissOnNotFound(t *testing.T) {
+	server, _ := newTestCacheServer(t, "")
+	_, err := NewHttpCacheStorage(server.URL, nil).Get("proj#build/abc.tar.gz")
+	if !errors.Is(err, ErrRemoteCacheMiss) {
+		t.Errorf("expected ErrRemoteCacheMiss, got %v", err)
+	}
+}
+
+func TestHttpCacheStorage_ErrorOnUnauthorized(t *testing.T) {
+	server, _ := newTestCacheServer(t, "secret")
+	storage := NewHttpCacheStorage(server.URL, map[string]string{"Authorization": "Bearer wrong"})
+	if _, err := storage.Get("proj#build/abc.tar.gz"); err == nil || errors.Is(err, ErrRemoteCacheMiss) {
+		t.Errorf("expected an error which is not a miss, got %v", err)
+	}
+}
+
+func TestRemoteCache_HttpRoundTrip(t *testing.T) {
+	server, entries := newTestCacheServer(t, "secret")
+	remote := &RemoteCache{Storage: NewHttpCacheStorage(server.URL+"/", map[string]string{"Authorization": "Bearer secret"})}
+
+	// first machine: run and save with outputs
+	dir := makeTestProject(t, map[string]string{"src/main.go": "main", "dist/out.js": "built"})
+	opts := baseOpts(dir, t.TempDir())
+	opts.Mode = "restore"
+	opts.Outputs = []string{"dist/**"}
+	opts.Remote = remote
+	hash, _ := ComputeHash(opts, baseTaskDef([]string{"build"}))
+	if err := Save(opts, hash, "test output"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, ok := entries["/myproject%23build/"+hash+".tar.gz"]; !ok {
+		t.Fatalf("entry not uploaded, server has %v", entries)
+	}
+	if exists, err := existsRemote(opts, hash); err != nil || !exists {
+		t.Errorf("uploaded entry should exist: %v, %v", exists, err)
+	}
+	if exists, err := existsRemote(opts, "unknown"); err != nil || exists {
+		t.Errorf("unknown entry should not exist: %v, %v", exists, err)
+	}
+	if tmpFiles, _ := filepath.Glob(filepath.Join(cacheTaskDir(opts.MonospaceRoot, opts.ProjectName, opts.TaskName), ".push-*")); len(tmpFiles) > 0 {
+		t.Errorf("temporary upload archives left in cache: %v", tmpFiles)
+	}
+
+	// second machine: empty local cache
+	os.Remove(filepath.Join(dir, "dist/out.js"))
+	opts.MonospaceRoot = t.TempDir()
+	result, err := Check(opts, hash)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if !result.Hit || !result.Fetched {
+		t.Fatalf("expected cache hit fetched from remote, got %+v", result)
+	}
+	if output, _ := readCachedOutput(result.CacheDir); output != "test output" {
+		t.Errorf("cached output: got %q, want %q", output, "test output")
+	}
+	if err := Restore(opts, result); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "dist/out.js")); err != nil || string(data) != "built" {
+		t.Errorf("restored content: got %q, %v", string(data), err)
+	}
+}
+
+func TestRemoteCache_ReadOnlyDoesNotUpload(t *testing.T) {
+	server, entries := newTestCacheServer(t, "")
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, t.TempDir())
+	opts.Remote = &RemoteCache{Storage: NewHttpCacheStorage(server.URL, nil), ReadOnly: true}
+	hash, _ := ComputeHash(opts, baseTaskDef([]string{"go", "build"}))
+	if err := Save(opts, hash, "out"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("read-only remote cache should not receive entries, got %v", entries)
+	}
+}
+
+func TestRemoteCache_MissWhenNotInRemote(t *testing.T) {
+	server, _ := newTestCacheServer(t, "")
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, t.TempDir())
+	opts.Remote = &RemoteCache{Storage: NewHttpCacheStorage(server.URL, nil)}
+	result, err := Check(opts, "deadbeef")
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if result.Hit {
+		t.Error("expected cache miss")
+	}
+}
+
+// ─── directory storage ─────────────────────────────────────────────────────────
+
+func TestRemoteCache_DirRoundTrip(t *testing.T) {
+	shared := t.TempDir()
+	remote := &RemoteCache{Storage: NewDirCacheStorage(shared)}
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, t.TempDir())
+	opts.Remote = remote
+	hash, _ := ComputeHash(opts, baseTaskDef([]string{"go", "build"}))
+	if err := Save(opts, hash, "dir output"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(shared, "myproject#build", hash+".tar.gz")); err != nil {
+		t.Fatalf("entry not stored in shared dir: %v", err)
+	}
+
+	opts.MonospaceRoot = t.TempDir()
+	result, err := Check(opts, hash)
+	if err != nil || !result.Hit {
+		t.Fatalf("expected cache hit from shared dir, got %v, %v", result, err)
+	}
+	if output, _ := readCachedOutput(result.CacheDir); output != "dir output" {
+		t.Errorf("cached output: got %q, want %q", output, "dir output")
+	}
+}
@@ -8,9 +8,14 @@ SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
 package tasks
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 	"testing"
@@ -79,7 +84,7 @@ func TestWalkProjectFiles_FollowsSymlinkDirectory(t *testing.T) {
 		t.Skip("cannot create symbolic links on this OS")
 	}
 
-	files, err := walkProjectFiles(projectDir)
+	files, err := walkProjectFiles(projectDir, nil)
 	if err != nil {
 		t.Fatalf("walkProjectFiles: %v", err)
 	}
@@ -127,7 +132,7 @@ func TestWalkProjectFiles_SymlinkCycleNoHang(t *testing.T) {
 
 	done := make(chan []string, 1)
 	go func() {
-		files, _ := walkProjectFiles(projectDir)
+		files, _ := walkProjectFiles(projectDir, nil)
 		done <- files
 	}()
 
@@ -284,6 +289,39 @@ func TestComputeHash_WithInputGlobs(t *testing.T) {
 	}
 }
 
+func TestComputeHash_DependencyHashChangeBustsCache(t *testing.T) {
+	root := t.TempDir()
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, root)
+	taskDef := baseTaskDef([]string{"go", "build"})
+
+	h1, _ := ComputeHash(opts, taskDef)
+	opts.DependencyHashes = map[string]string{"lib#build": "abc"}
+	h2, _ := ComputeHash(opts, taskDef)
+	opts.DependencyHashes = map[string]string{"lib#build": "def"}
+	h3, _ := ComputeHash(opts, taskDef)
+
+	if h1 == h2 || h2 == h3 {
+		t.Error("changing dependencies hashes should change the hash")
+	}
+}
+
+func TestComputeHash_PackageJsonScriptChangeBustsCache(t *testing.T) {
+	root := t.TempDir()
+	dir := makeTestProject(t, map[string]string{"package.json": `{"scripts":{"build":"tsc"}}`})
+	opts := baseOpts(dir, root)
+	opts.Inputs = []string{"src/**"} // exclude package.json from hashed files
+	taskDef := baseTaskDef(nil)
+
+	h1, _ := ComputeHash(opts, taskDef)
+	os.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"scripts":{"build":"tsc --strict"}}`), 0640)
+	h2, _ := ComputeHash(opts, taskDef)
+
+	if h1 == h2 {
+		t.Error("changing the package.json script body should change the hash")
+	}
+}
+
 func TestComputeHash_TaskDefChangeBustsCache(t *testing.T) {
 	root := t.TempDir()
 	dir := makeTestProject(t, map[string]string{"a.go": "a"})
@@ -404,14 +442,25 @@ func TestCheckAndSave_RestoreMode_SavesOutputs(t *testing.T) {
 		t.Fatalf("Save: %v", err)
 	}
 
-	// verify output file was archived
-	cachedOutput := filepath.Join(cacheEntryDir(root, opts.ProjectName, opts.TaskName, hash), "outputs", "dist", "out.js")
-	data, err := os.ReadFile(cachedOutput)
+	// verify output file was archived with its checksum in the manifest
+	entryDir := cacheEntryDir(root, opts.ProjectName, opts.TaskName, hash)
+	if _, err := os.Stat(filepath.Join(entryDir, outputsArchiveName)); err != nil {
+		t.Fatalf("outputs archive not found: %v", err)
+	}
+	manifest, err := verifyOutputsArchive(entryDir)
 	if err != nil {
-		t.Fatalf("cached output not found: %v", err)
+		t.Fatalf("verifyOutputsArchive: %v", err)
 	}
-	if string(data) != "built" {
-		t.Errorf("cached output content: got %q, want %q", string(data), "built")
+	sum := sha256.Sum256([]byte("built"))
+	want := []ArchiveFile{{Path: "dist/out.js", Size: 5, Mode: 0640, Sha256: hex.EncodeToString(sum[:])}}
+	if !reflect.DeepEqual(manifest.Files, want) {
+		t.Errorf("manifest files: got %+v, want %+v", manifest.Files, want)
+	}
+	if err := verifyOutputsFiles(entryDir, manifest); err != nil {
+		t.Errorf("verifyOutputsFiles: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(entryDir, "outputs")); !os.IsNotExist(err) {
+		t.Error("outputs should not be copied file by file anymore")
 	}
 }
 
@@ -650,53 +699,94 @@ func TestComputeHash_ErrorOnStatFail(t *testing.T) {
 	}
 }
 
-func TestRestore_PropagatesWalkError(t *testing.T) {
+func TestCheck_CorruptArchiveIsAMiss(t *testing.T) {
 	root := t.TempDir()
-	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	dir := makeTestProject(t, map[string]string{"dist/out.js": "built"})
 	opts := baseOpts(dir, root)
 	opts.Mode = "restore"
-	opts.Outputs = []string{"**"}
-	taskDef := baseTaskDef([]string{"build"})
+	opts.Outputs = []string{"dist/**"}
 
-	hash, _ := ComputeHash(opts, taskDef)
-	Save(opts, hash, "output")
+	hash, _ := ComputeHash(opts, baseTaskDef([]string{"build"}))
+	if err := Save(opts, hash, "output"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	entryDir := cacheEntryDir(root, opts.ProjectName, opts.TaskName, hash)
+	if err := os.WriteFile(filepath.Join(entryDir, outputsArchiveName), []byte("garbage"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Check(opts, hash)
+	if !errors.Is(err, ErrCorruptCacheEntry) {
+		t.Errorf("Check: expected ErrCorruptCacheEntry, got %v", err)
+	}
+	if result.Hit {
+		t.Error("Check: corrupt entry should be a miss")
+	}
+}
+
+func TestRestore_RejectsTamperedFiles(t *testing.T) {
+	root := t.TempDir()
+	dir := makeTestProject(t, map[string]string{"dist/out.js": "built"})
+	opts := baseOpts(dir, root)
+	opts.Mode = "restore"
+	opts.Outputs = []string{"dist/**"}
 
+	hash, _ := ComputeHash(opts, baseTaskDef([]string{"build"}))
+	if err := Save(opts, hash, "output"); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
 	result, _ := Check(opts, hash)
-	// Remove the outputs directory to cause WalkDir to fail
-	os.RemoveAll(filepath.Join(result.CacheDir, "outputs"))
-	// Actually, let's create a bad scenario: make outputs dir unreadable
-	outputsDir := filepath.Join(result.CacheDir, "outputs")
-	if err := os.MkdirAll(outputsDir, 0750); err != nil {
+
+	// replace the archive content and update the archive checksum so only
+	// the per file checksums can detect the tampering
+	tampered := makeTestProject(t, map[string]string{"dist/out.js": "evil!"})
+	var buf bytes.Buffer
+	if _, err := writeArchive(&buf, tampered, []string{"dist/out.js"}); err != nil {
 		t.Fatal(err)
 	}
-	// Write a file
-	os.WriteFile(filepath.Join(outputsDir, "test.txt"), []byte("test"), 0640)
-	// Restore should succeed with valid outputs
-	if err := Restore(opts, result); err != nil {
-		t.Fatalf("Restore: unexpected error: %v", err)
+	os.WriteFile(filepath.Join(result.CacheDir, outputsArchiveName), buf.Bytes(), 0640)
+	manifest, _ := verifyOutputsArchive(result.CacheDir)
+	sum := sha256.Sum256(buf.Bytes())
+	manifest.ArchiveSha256 = hex.EncodeToString(sum[:])
+	data, _ := json.Marshal(manifest)
+	os.WriteFile(filepath.Join(result.CacheDir, outputsManifestName), data, 0640)
+
+	os.WriteFile(filepath.Join(dir, "dist/out.js"), []byte("local"), 0640)
+	if err := Restore(opts, result); !errors.Is(err, ErrCorruptCacheEntry) {
+		t.Fatalf("Restore: expected ErrCorruptCacheEntry, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "dist/out.js")); string(data) != "local" {
+		t.Errorf("Restore: project file should be left untouched, got %q", string(data))
 	}
 }
 
-func TestCopyFile_PreservesPermissions(t *testing.T) {
+func TestRestore_PreservesPermissions(t *testing.T) {
 	root := t.TempDir()
-	src := filepath.Join(root, "src.txt")
-	dst := filepath.Join(root, "dst.txt")
-
-	// Create source with specific permissions (executable)
+	dir := makeTestProject(t, map[string]string{"src/main.go": "main"})
+	src := filepath.Join(dir, "dist/run.sh")
+	if err := os.MkdirAll(filepath.Dir(src), 0750); err != nil {
+		t.Fatal(err)
+	}
+	// Create output with specific permissions (executable)
 	if err := os.WriteFile(src, []byte("test"), 0755); err != nil {
 		t.Fatal(err)
 	}
+	opts := baseOpts(dir, root)
+	opts.Mode = "restore"
+	opts.Outputs = []string{"dist/**"}
+	hash, _ := ComputeHash(opts, baseTaskDef([]string{"build"}))
+	Save(opts, hash, "")
+	result, _ := Check(opts, hash)
+	os.Remove(src)
 
-	if err := copyFile(src, dst); err != nil {
-		t.Fatalf("copyFile: %v", err)
+	if err := Restore(opts, result); err != nil {
+		t.Fatalf("Restore: %v", err)
 	}
-
-	info, err := os.Stat(dst)
+	info, err := os.Stat(src)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if info.Mode().Perm() != 0755 {
-		t.Errorf("copyFile: permission not preserved, got %o, want %o", info.Mode().Perm(), 0755)
+		t.Errorf("Restore: permission not preserved, got %o, want %o", info.Mode().Perm(), 0755)
 	}
 }
 
@@ -0,0 +1,243 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	jobExecutor "github.com/software-t-rex/go-jobExecutor/v2"
+	"github.com/software-t-rex/monospace/gomodules/ui"
+	"github.com/software-t-rex/monospace/gomodules/utils"
+	"github.com/software-t-rex/monospace/mono"
+)
+
+const (
+	PlanCacheDisabled = "disabled"
+	PlanCacheHit      = "hit"
+	PlanCacheMiss     = "miss"
+	PlanCacheError    = "error"
+)
+
+// TaskPlan describes how a task would be executed by Run
+type TaskPlan struct {
+	Task       string            `json:"task"`
+	Cmd        []string          `json:"cmd"` // empty for tasks only running their dependencies
+	Dir        string            `json:"dir"`
+	Env        map[string]string `json:"env,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	DependsOn  []string          `json:"dependsOn"`
+	Persistent bool              `json:"persistent,omitempty"`
+	Exclusive  bool              `json:"exclusive,omitempty"`
+	Cache      TaskPlanCache     `json:"cache"`
+}
+
+// TaskPlanCache is the cache status of a planned task
+type TaskPlanCache struct {
+	Mode   string `json:"mode,omitempty"` // "skip" | "restore"
+	Status string `json:"status"`         // PlanCacheDisabled | PlanCacheHit | PlanCacheMiss | PlanCacheError
+	Hash   string `json:"hash,omitempty"`
+	Remote bool   `json:"remote,omitempty"` // the hit comes from the remote cache
+	Error  string `json:"error,omitempty"`
+}
+
+// TopologicalOrder returns the tasks of the list, each task coming after the
+// tasks it depends on. Independent tasks are sorted by name.
+func (t TaskList) TopologicalOrder() ([]*Task, error) {
+	remainingDeps := make(map[string]int, t.Len())
+	dependents := make(map[string][]string, t.Len())
+	var ready []string
+	for name, task := range t.List {
+		for _, dep := range task.TaskDef.DependsOn {
+			if _, ok := t.List[dep]; !ok {
+				return nil, fmt.Errorf("%s: missing dependency task %s", name, dep)
+			}
+			dependents[dep] = append(dependents[dep], name)
+		}
+		remainingDeps[name] = len(task.TaskDef.DependsOn)
+		if remainingDeps[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	res := make([]*Task, 0, t.Len())
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		res = append(res, t.List[name])
+		for _, dependent := range dependents[name] {
+			remainingDeps[dependent]--
+			if remainingDeps[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if len(res) != t.Len() {
+		return nil, jobExecutor.ErrCyclicDependencyDetected
+	}
+	return res, nil
+}
+
+// GetPlan returns the execution plan of the task list in topological order.
+// Cache status is computed against the local cache, then the remote cache if
+// configured, without modifying any of them.
+func (t TaskList) GetPlan(opts RunOptions) ([]TaskPlan, error) {
+	ordered, err := t.TopologicalOrder()
+	if err != nil {
+		return nil, err
+	}
+	var remoteCache *RemoteCache
+	if !opts.NoCache {
+		remoteCache, err = NewRemoteCache(t.config.RemoteCache, mono.SpaceGetRoot())
+		if err != nil {
+			utils.PrintWarning(err.Error() + " => using local cache only")
+		}
+	}
+	hasher := newTaskHasher(t, mono.SpaceGetRoot(), remoteCache)
+	plan := make([]TaskPlan, 0, len(ordered))
+	for _, task := range ordered {
+		taskPlan := TaskPlan{
+			Task:       task.Name.String(),
+			Dir:        mono.ProjectGetPath(task.Name.Project),
+			Env:        task.GetEnv(),
+			Params:     task.Params,
+			DependsOn:  append([]string{}, task.TaskDef.DependsOn...),
+			Persistent: task.TaskDef.Persistent,
+			Exclusive:  task.TaskDef.Exclusive,
+			Cache:      TaskPlanCache{Status: PlanCacheDisabled},
+		}
+		sort.Strings(taskPlan.DependsOn)
+		runner := task.GetJobRunner(opts.taskArgs(task), t.config.JSPM)
+		if runner == nil && len(task.TaskDef.DependsOn) == 0 {
+			return nil, fmt.Errorf("%s task has no cmd, no package.json script or dependencies", taskPlan.Task)
+		}
+		if runner != nil {
+			taskPlan.Cmd = runner.Args
+			taskPlan.Dir = runner.Dir
+			if isTaskCached(task, opts) {
+				taskPlan.Cache = planTaskCache(task, hasher)
+			}
+		}
+		plan = append(plan, taskPlan)
+	}
+	return plan, nil
+}
+
+func planTaskCache(task *Task, hasher *taskHasher) TaskPlanCache {
+	res := TaskPlanCache{Mode: task.TaskDef.Cache}
+	hash, _, err := hasher.memoizedHash(task)
+	if err != nil {
+		res.Status = PlanCacheError
+		res.Error = err.Error()
+		return res
+	}
+	res.Hash = hash
+	// a dry run must neither fetch remote entries nor update last access times
+	opts := hasher.cacheOptions(task)
+	result, err := lookupCache(opts, hash)
+	if err != nil {
+		res.Status = PlanCacheError
+		res.Error = err.Error()
+		return res
+	}
+	res.Status = PlanCacheMiss
+	entryDir := cacheEntryDir(opts.MonospaceRoot, opts.ProjectName, opts.TaskName, hash)
+	if result.Hit {
+		res.Status = PlanCacheHit
+	} else if opts.Remote != nil && !utils.FileExistsNoErr(filepath.Join(entryDir, "metadata.json")) {
+		// like Check, only look at the remote cache when there's no local entry
+		if exists, err := existsRemote(opts, hash); err != nil {
+			res.Error = err.Error() // run would warn and consider it a miss
+		} else if exists {
+			res.Status = PlanCacheHit
+			res.Remote = true
+		}
+	}
+	return res
+}
+
+// DryRun prints the execution plan of the task list instead of running it.
+// format is either "text" or "json".
+func DryRun(taskList TaskList, opts RunOptions, format string) {
+	if taskList.Len() == 0 {
+		exit("no tasks found")
+	}
+	plan, err := taskList.GetPlan(opts)
+	if err != nil {
+		exit(err.Error())
+	}
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			exit(err.Error())
+		}
+		fmt.Println(string(data))
+	case "text":
+		fmt.Print(formatPlan(plan))
+	default:
+		exit(fmt.Sprintf("invalid dry-run format %q, expected text or json", format))
+	}
+}
+
+func formatPlan(plan []TaskPlan) string {
+	theme := ui.GetTheme()
+	sb := strings.Builder{}
+	sb.WriteString(theme.Bold(fmt.Sprintf("Execution plan for %d tasks:\n", len(plan))))
+	for i, task := range plan {
+		sb.WriteString(fmt.Sprintf("\n%d. %s", i+1, theme.Bold(task.Task)))
+		if task.Persistent {
+			sb.WriteString(" (persistent)")
+		}
+		if task.Exclusive {
+			sb.WriteString(" (exclusive)")
+		}
+		sb.WriteString("\n")
+		if len(task.Cmd) == 0 {
+			sb.WriteString("  cmd:        none, only runs its dependencies\n")
+		} else {
+			sb.WriteString(fmt.Sprintf("  cmd:        %s\n", strings.Join(task.Cmd, " ")))
+		}
+		sb.WriteString(fmt.Sprintf("  dir:        %s\n", task.Dir))
+		if len(task.Env) > 0 {
+			envKeys := utils.MapGetKeys(task.Env)
+			sort.Strings(envKeys)
+			sb.WriteString("  env:\n")
+			for _, k := range envKeys {
+				sb.WriteString(fmt.Sprintf("    %s=%s\n", k, task.Env[k]))
+			}
+		}
+		if len(task.Params) > 0 {
+			sb.WriteString("  params:\n")
+			for _, k := range sortedKeys(task.Params) {
+				sb.WriteString(fmt.Sprintf("    %s=%s\n", k, task.Params[k]))
+			}
+		}
+		if len(task.DependsOn) > 0 {
+			sb.WriteString(fmt.Sprintf("  depends on: %s\n", strings.Join(task.DependsOn, ", ")))
+		}
+		switch task.Cache.Status {
+		case PlanCacheHit:
+			source := utils.If(task.Cache.Remote, "remote", "local")
+			sb.WriteString(theme.Success(fmt.Sprintf("  cache:      %s, would hit %s cache (%s)\n", task.Cache.Mode, source, task.Cache.Hash[:8])))
+		case PlanCacheMiss:
+			sb.WriteString(fmt.Sprintf("  cache:      %s, would miss (%s)\n", task.Cache.Mode, task.Cache.Hash[:8]))
+			if task.Cache.Error != "" {
+				sb.WriteString(theme.Warning(fmt.Sprintf("              %s\n", task.Cache.Error)))
+			}
+		case PlanCacheError:
+			sb.WriteString(theme.Warning(fmt.Sprintf("  cache:      %s, can't compute hash: %s\n", task.Cache.Mode, task.Cache.Error)))
+		default:
+			sb.WriteString("  cache:      disabled\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,145 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	jobExecutor "github.com/software-t-rex/go-jobExecutor/v2"
+	"github.com/software-t-rex/monospace/app"
+)
+
+func TestTaskList_TopologicalOrder(t *testing.T) {
+	taskList := TaskList{List: map[string]*Task{
+		"app#build":  NewTask("app#build", app.MonospaceConfigTask{DependsOn: []string{"lib#build", "util#build"}}),
+		"lib#build":  NewTask("lib#build", app.MonospaceConfigTask{DependsOn: []string{"util#build"}}),
+		"util#build": NewTask("util#build", app.MonospaceConfigTask{}),
+		"app#lint":   NewTask("app#lint", app.MonospaceConfigTask{}),
+	}}
+	ordered, err := taskList.TopologicalOrder()
+	if err != nil {
+		t.Fatalf("TopologicalOrder: %v", err)
+	}
+	var names []string
+	for _, task := range ordered {
+		names = append(names, task.Name.String())
+	}
+	want := []string{"app#lint", "util#build", "lib#build", "app#build"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+}
+
+func TestTaskList_TopologicalOrderDetectsCycles(t *testing.T) {
+	taskList := TaskList{List: map[string]*Task{
+		"a#build": NewTask("a#build", app.MonospaceConfigTask{DependsOn: []string{"b#build"}}),
+		"b#build": NewTask("b#build", app.MonospaceConfigTask{DependsOn: []string{"a#build"}}),
+	}}
+	if _, err := taskList.TopologicalOrder(); !errors.Is(err, jobExecutor.ErrCyclicDependencyDetected) {
+		t.Errorf("expected a cyclic dependency error, got %v", err)
+	}
+}
+
+func TestTaskList_GetPlan(t *testing.T) {
+	root := makeTestProject(t, map[string]string{
+		".monospace/monospace.yml": "projects: {}",
+		"lib/a.go":                 "a",
+	})
+	useTestMonospace(t, root)
+	taskList := TaskList{List: map[string]*Task{
+		"lib#build": NewTask("lib#build", app.MonospaceConfigTask{Cmd: []string{"go", "build"}, Cache: "skip", Env: map[string]string{"CGO_ENABLED": "0"}}),
+		"lib#all":   NewTask("lib#all", app.MonospaceConfigTask{DependsOn: []string{"lib#build"}}),
+	}, config: &app.MonospaceConfig{Projects: map[string]string{"lib": "internal"}}}
+
+	plan, err := taskList.GetPlan(RunOptions{AdditionalArgs: []string{"-v"}})
+	if err != nil {
+		t.Fatalf("GetPlan: %v", err)
+	}
+	if len(plan) != 2 || plan[0].Task != "lib#build" || plan[1].Task != "lib#all" {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	build := plan[0]
+	if !reflect.DeepEqual(build.Cmd, []string{"go", "build", "-v"}) || !strings.HasSuffix(build.Dir, "lib") || build.Env["CGO_ENABLED"] != "0" {
+		t.Errorf("unexpected build plan %+v", build)
+	}
+	if build.Cache.Status != PlanCacheMiss || build.Cache.Hash == "" {
+		t.Errorf("expected a cache miss, got %+v", build.Cache)
+	}
+	if len(plan[1].Cmd) != 0 || plan[1].Cache.Status != PlanCacheDisabled || !reflect.DeepEqual(plan[1].DependsOn, []string{"lib#build"}) {
+		t.Errorf("unexpected dummy task plan %+v", plan[1])
+	}
+
+	hasher := newTaskHasher(taskList, root, nil)
+	if err := Save(hasher.cacheOptions(taskList.List["lib#build"]), build.Cache.Hash, ""); err != nil {
+		t.Fatal(err)
+	}
+	entryDir := cacheEntryDir(root, "lib", "build", build.Cache.Hash)
+	lastAccess := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(filepath.Join(entryDir, "metadata.json"), lastAccess, lastAccess)
+	plan, _ = taskList.GetPlan(RunOptions{AdditionalArgs: []string{"-v"}})
+	if plan[0].Cache.Status != PlanCacheHit {
+		t.Errorf("expected a cache hit, got %+v", plan[0].Cache)
+	}
+	if accessed, _ := cacheEntryLastAccess(entryDir); !accessed.Equal(lastAccess) {
+		t.Errorf("dry run should not update the entry last access time, got %v", accessed)
+	}
+	if text := formatPlan(plan); !strings.Contains(text, "would hit") || !strings.Contains(text, "CGO_ENABLED=0") {
+		t.Errorf("unexpected text plan:\n%s", text)
+	}
+
+	plan, _ = taskList.GetPlan(RunOptions{NoCache: true})
+	if plan[0].Cache.Status != PlanCacheDisabled {
+		t.Errorf("cache should be disabled with --no-cache, got %+v", plan[0].Cache)
+	}
+}
+
+func TestTaskList_GetPlanRemoteCache(t *testing.T) {
+	root := makeTestProject(t, map[string]string{
+		".monospace/monospace.yml": "projects: {}",
+		"lib/a.go":                 "a",
+	})
+	useTestMonospace(t, root)
+	shared := t.TempDir()
+	config := &app.MonospaceConfig{Projects: map[string]string{"lib": "internal"}, RemoteCache: &app.MonospaceConfigRemoteCache{Url: shared}}
+	taskList := TaskList{List: map[string]*Task{
+		"lib#build": NewTask("lib#build", app.MonospaceConfigTask{Cmd: []string{"go", "build"}, Cache: "skip"}),
+	}, config: config}
+
+	plan, err := taskList.GetPlan(RunOptions{})
+	if err != nil {
+		t.Fatalf("GetPlan: %v", err)
+	}
+	if plan[0].Cache.Status != PlanCacheMiss {
+		t.Fatalf("expected a cache miss, got %+v", plan[0].Cache)
+	}
+	// another machine populated the remote cache
+	hash := plan[0].Cache.Hash
+	opts := newTaskHasher(taskList, root, nil).cacheOptions(taskList.List["lib#build"])
+	opts.MonospaceRoot = t.TempDir()
+	opts.Remote = &RemoteCache{Storage: NewDirCacheStorage(shared)}
+	if err := Save(opts, hash, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, _ = taskList.GetPlan(RunOptions{})
+	if plan[0].Cache.Status != PlanCacheHit || !plan[0].Cache.Remote {
+		t.Errorf("expected a remote cache hit, got %+v", plan[0].Cache)
+	}
+	if pathExists(cacheEntryDir(root, "lib", "build", hash)) {
+		t.Error("dry run should not download remote entries")
+	}
+	if text := formatPlan(plan); !strings.Contains(text, "would hit remote cache") {
+		t.Errorf("unexpected text plan:\n%s", text)
+	}
+}
@@ -0,0 +1,53 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"sync"
+
+	exctr "github.com/software-t-rex/go-jobExecutor/v2"
+)
+
+// exclusiveJobs prevents exclusive jobs to run concurrently with any other job.
+// Other jobs share a read lock while exclusive jobs take the write lock.
+type exclusiveJobs struct {
+	mu   sync.RWMutex
+	jobs map[int]bool // exclusive job ids
+}
+
+func newExclusiveJobs() *exclusiveJobs {
+	return &exclusiveJobs{jobs: map[int]bool{}}
+}
+
+func (x *exclusiveJobs) add(jobId int) {
+	x.jobs[jobId] = true
+}
+
+// attach locks around each job of the executor. Job start hooks are called by
+// the scheduler so it waits for the lock before starting any further job, and
+// done hooks are called before the scheduler is notified so it never waits on
+// a job that is itself waiting.
+func (x *exclusiveJobs) attach(e *exctr.JobExecutor) {
+	if len(x.jobs) == 0 {
+		return
+	}
+	e.OnJobStart(func(jobs exctr.JobList, jobId int) {
+		if x.jobs[jobId] {
+			x.mu.Lock()
+		} else {
+			x.mu.RLock()
+		}
+	})
+	e.OnJobDone(func(jobs exctr.JobList, jobId int) {
+		if x.jobs[jobId] {
+			x.mu.Unlock()
+		} else {
+			x.mu.RUnlock()
+		}
+	})
+}
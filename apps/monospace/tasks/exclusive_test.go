@@ -0,0 +1,77 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	exctr "github.com/software-t-rex/go-jobExecutor/v2"
+	"github.com/software-t-rex/monospace/app"
+)
+
+func TestExclusiveJobs_NeverOverlap(t *testing.T) {
+	t.Cleanup(SetMaxConcurrentJobs(4))
+
+	var mu sync.Mutex
+	running, maxRunning, exclusiveRunning := 0, 0, 0
+	overlaps := []string{}
+	job := func(name string, exclusive bool) func() (string, error) {
+		return func() (string, error) {
+			mu.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			if exclusive {
+				exclusiveRunning++
+			}
+			if exclusiveRunning > 0 && running > 1 {
+				overlaps = append(overlaps, name)
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			running--
+			if exclusive {
+				exclusiveRunning--
+			}
+			mu.Unlock()
+			return name, nil
+		}
+	}
+
+	e := exctr.NewExecutor()
+	exclusive := newExclusiveJobs()
+	for _, name := range []string{"a", "b", "serve", "c", "d", "port", "e"} {
+		isExclusive := name == "serve" || name == "port"
+		j := e.AddJob(exctr.NamedJob{Name: name, Job: job(name, isExclusive)})
+		if isExclusive {
+			exclusive.add(j.Id())
+		}
+	}
+	exclusive.attach(e)
+	if errs := e.DagExecute(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(overlaps) > 0 {
+		t.Errorf("exclusive jobs overlapped with other jobs: %s", strings.Join(overlaps, ", "))
+	}
+	if maxRunning < 2 {
+		t.Errorf("non exclusive jobs should still run concurrently, max running %d", maxRunning)
+	}
+}
+
+func TestGetStandardizedPipeline_PersistentExclusive(t *testing.T) {
+	config := &app.MonospaceConfig{Pipeline: map[string]app.MonospaceConfigTask{
+		"serve": {Persistent: true, Exclusive: true},
+	}}
+	if _, err := GetStandardizedPipeline(config, true); err == nil || !strings.Contains(err.Error(), "can't be exclusive") {
+		t.Errorf("expected persistent exclusive task to be rejected, got %v", err)
+	}
+}
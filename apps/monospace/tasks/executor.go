@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	exctr "github.com/software-t-rex/go-jobExecutor/v2"
@@ -29,8 +30,45 @@ func setInterleavedOutputDisplayNames(jobs exctr.JobList) {
 	}
 }
 
+// concurrency limit currently set on the job executor package
+var maxConcurrentJobs struct {
+	mu sync.Mutex
+	n  int
+}
+
+// SetMaxConcurrentJobs sets the maximum number of jobs run at the same time by
+// executors (0 means the number of cpus) and returns a function restoring the
+// previous limit. The limit is shared by all executors and running jobs release
+// their slot on the limit set when they end, so it must only be changed while
+// no executor is running.
+func SetMaxConcurrentJobs(n int) (restore func()) {
+	maxConcurrentJobs.mu.Lock()
+	defer maxConcurrentJobs.mu.Unlock()
+	previous := maxConcurrentJobs.n
+	maxConcurrentJobs.n = n
+	exctr.SetMaxConcurrentJobs(n)
+	return func() {
+		maxConcurrentJobs.mu.Lock()
+		defer maxConcurrentJobs.mu.Unlock()
+		maxConcurrentJobs.n = previous
+		exctr.SetMaxConcurrentJobs(previous)
+	}
+}
+
 func NewExecutor(outputMode string) *exctr.JobExecutor {
+	return newExecutor(outputMode, nil, nil)
+}
+
+// newExecutor returns an executor for the given output mode, task jobs
+// output streams are redirected by the output mode as commands ones are.
+// When set, failures policy is applied to jobs before any output is made.
+func newExecutor(outputMode string, taskJobs *taskJobs, failures *failureHandler) *exctr.JobExecutor {
+	if outputMode == "tui" && !dashboardSupported() {
+		utils.PrintWarning("tui output mode requires an interactive terminal, using interleaved output")
+		outputMode = "interleaved"
+	}
 	e := exctr.NewExecutor()
+	failures.attach(e)
 	startTime := time.Now()
 	theme := ui.GetTheme()
 	successIndicator := theme.SuccessIndicator()
@@ -38,8 +76,10 @@ func NewExecutor(outputMode string) *exctr.JobExecutor {
 	e.OnJobsStart(func(jobs exctr.JobList) {
 		fmt.Printf(theme.Bold("Starting %d tasks...\n"), len(jobs))
 	})
-	switch outputMode { //grouped,interleaved,status-only,errors-only,none
+	switch outputMode { //grouped,interleaved,status-only,errors-only,tui,none
 	case "none": // do nothing
+	case "tui":
+		newDashboard().attach(e, taskJobs, failures)
 	case "errors-only":
 		fallthrough
 	case "interleaved":
@@ -49,13 +89,26 @@ func NewExecutor(outputMode string) *exctr.JobExecutor {
 		}
 		e.OnJobsStart(func(jobs exctr.JobList) {
 			setInterleavedOutputDisplayNames(jobs)
-			for _, job := range jobs {
+			for jobId, job := range jobs {
 				pw := exctr.NewPrefixedWriter(os.Stdout, job.Name()+": ")
 				if job.Cmd != nil {
 					if withStdout {
 						job.Cmd.Stdout = pw
 					}
 					job.Cmd.Stderr = pw
+				} else if tj := taskJobs.get(jobId); tj != nil {
+					if withStdout {
+						tj.stdout = pw
+					}
+					tj.stderr = pw
+					fn := job.Fn
+					job.Fn = func() (string, error) {
+						res, err := fn()
+						if err != nil {
+							pw.Write([]byte(theme.Error(err.Error())))
+						}
+						return res, err
+					}
 				} else if job.Fn != nil {
 					fn := job.Fn
 					job.Fn = func() (string, error) {
@@ -78,6 +131,8 @@ func NewExecutor(outputMode string) *exctr.JobExecutor {
 				status := "⏳"
 				if j.IsState(exctr.JobStateRunning) {
 					status = "🏃"
+				} else if j.IsState(exctr.JobStateFailed) && isSkippedJobErr(j.Err) {
+					status = "⏭"
 				} else if j.IsState(exctr.JobStateFailed) {
 					status = failureIndicator
 				} else if j.IsState(exctr.JobStateSucceed) {
@@ -98,15 +153,35 @@ func NewExecutor(outputMode string) *exctr.JobExecutor {
 		e.OnJobDone(printProgress)
 		e.OnJobStart(printProgress)
 	default: // grouped is the default
+		// output of ready persistent tasks can't be grouped anymore
+		e.OnJobsStart(func(jobs exctr.JobList) {
+			for jobId, job := range jobs {
+				if tj := taskJobs.get(jobId); tj != nil && tj.task.Persists() {
+					tj.afterReady = exctr.NewPrefixedWriter(os.Stdout, job.Name()+": ")
+				}
+			}
+		})
 		e.OnJobDone(func(jobs exctr.JobList, jobId int) {
 			job := jobs[jobId]
 			indicator := failureIndicator
 			verb := "failed"
-			if job.IsState(exctr.JobStateSucceed) {
+			if tj := taskJobs.get(jobId); tj != nil && tj.isReady {
+				verb = "ready"
+				indicator = successIndicator
+			} else if job.IsState(exctr.JobStateSucceed) {
 				verb = "succeed"
 				indicator = successIndicator
+			} else if isSkippedJobErr(job.Err) {
+				verb = "skipped"
+				indicator = "⏭"
+			} else if failures.isCancelled(jobId) {
+				verb = "cancelled"
+			}
+			attempts := ""
+			if tj := taskJobs.get(jobId); tj != nil && tj.retries > 0 && tj.attempts > 0 {
+				attempts = fmt.Sprintf(" (attempt %d/%d)", tj.attempts, tj.retries+1)
 			}
-			statusLine := fmt.Sprintf("%s %s %s in %v\n", indicator, theme.Bold(job.Name()), verb, job.Duration)
+			statusLine := fmt.Sprintf("%s %s %s in %v%s\n", indicator, theme.Bold(job.Name()), verb, job.Duration, attempts)
 			err := ""
 			res := ""
 			if job.Err != nil {
@@ -121,11 +196,14 @@ func NewExecutor(outputMode string) *exctr.JobExecutor {
 	e.OnJobsDone(func(jobs exctr.JobList) {
 		var succeed int
 		var failed int
+		var skipped []string
 		allGreenIndicator := failureIndicator
 		elapsed := time.Since(startTime)
 		for _, job := range jobs {
 			if job.IsState(exctr.JobStateSucceed) {
 				succeed++
+			} else if isSkippedJobErr(job.Err) {
+				skipped = append(skipped, job.Name())
 			} else {
 				failed++
 			}
@@ -148,12 +226,23 @@ func NewExecutor(outputMode string) *exctr.JobExecutor {
 			sb.WriteString(theme.Error(fmt.Sprintf("%d failed", failed)))
 			sb.WriteString(" / ")
 		}
+		if len(skipped) > 0 {
+			sb.WriteString(theme.Warning(fmt.Sprintf("%d skipped", len(skipped))))
+			sb.WriteString(" / ")
+		}
 		sb.WriteString(fmt.Sprintf("%d total", len(jobs)))
 		if ui.EnhancedEnabled() {
 			sb.WriteString(ui.SGRResetSequence())
 		}
 		sb.WriteString("\n")
-		sb.WriteString(theme.Bold(fmt.Sprintf("total time: %v\n", elapsed)))
+		if len(skipped) > 0 {
+			sb.WriteString(fmt.Sprintf("skipped because of a failure: %s\n", strings.Join(skipped, ", ")))
+		}
+		if saved := taskJobs.Saved(); saved > 0 {
+			sb.WriteString(theme.Bold(fmt.Sprintf("total time: %v (saved %v)\n", elapsed, saved.Round(time.Millisecond))))
+		} else {
+			sb.WriteString(theme.Bold(fmt.Sprintf("total time: %v\n", elapsed)))
+		}
 		fmt.Print(sb.String())
 	})
 	return e
@@ -0,0 +1,98 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	exctr "github.com/software-t-rex/go-jobExecutor/v2"
+)
+
+// What happens to other tasks when a task fails
+const (
+	FailurePolicyContinue = ""     // run every task not depending on a failed one (default)
+	FailurePolicyBail     = "bail" // cancel running tasks and don't start new ones
+)
+
+var ErrSkippedAfterFailure = errors.New("skipped after another task failure")
+var ErrCancelledAfterFailure = errors.New("cancelled after another task failure")
+
+// isSkippedJobErr returns true for errors of jobs which were never started
+func isSkippedJobErr(err error) bool {
+	return errors.Is(err, exctr.ErrRequiredJobFailed) || errors.Is(err, ErrSkippedAfterFailure)
+}
+
+// failureHandler applies a failure policy to the jobs of an executor
+type failureHandler struct {
+	policy    string
+	failed    atomic.Bool
+	ctx       context.Context // cancelled on first failure with FailurePolicyBail
+	cancel    context.CancelFunc
+	mu        sync.Mutex
+	cancelled map[int]bool // ids of jobs that failed because they were cancelled
+}
+
+func newFailureHandler(policy string) *failureHandler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &failureHandler{policy: policy, ctx: ctx, cancel: cancel, cancelled: map[int]bool{}}
+}
+
+// isCancelled returns true if the job with given id was killed after another
+// task failure. Job errors are left untouched as other hooks may read them.
+func (f *failureHandler) isCancelled(jobId int) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cancelled[jobId]
+}
+
+// context returns the context cancelled when running tasks are cancelled
+func (f *failureHandler) context() context.Context {
+	if f == nil {
+		return context.Background()
+	}
+	return f.ctx
+}
+
+// attach must be called before any other job hooks are registered so that
+// they see skipped and cancelled jobs.
+func (f *failureHandler) attach(e *exctr.JobExecutor) {
+	if f == nil {
+		return
+	}
+	e.OnJobStart(func(jobs exctr.JobList, jobId int) {
+		if f.policy != FailurePolicyBail || !f.failed.Load() {
+			return
+		}
+		// start hooks are called by the scheduler before the job is run
+		job := jobs[jobId]
+		job.Cmd = nil
+		job.Fn = func() (string, error) { return "", ErrSkippedAfterFailure }
+	})
+	e.OnJobDone(func(jobs exctr.JobList, jobId int) {
+		job := jobs[jobId]
+		if job.Err == nil || isSkippedJobErr(job.Err) {
+			return
+		}
+		if f.policy == FailurePolicyBail && f.ctx.Err() != nil {
+			f.mu.Lock()
+			f.cancelled[jobId] = true
+			f.mu.Unlock()
+			return
+		}
+		f.failed.Store(true)
+		if f.policy == FailurePolicyBail {
+			f.cancel()
+		}
+	})
+}
@@ -0,0 +1,79 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	exctr "github.com/software-t-rex/go-jobExecutor/v2"
+	"github.com/software-t-rex/monospace/app"
+)
+
+// run jobs: ko fails immediately, dependent depends on ko, gate runs a child
+// process for gateDuration and later depends on gate. Jobs are built as for a
+// run with given policy. Returns jobs errors by name.
+func runFailurePolicy(t *testing.T, policy string, gateDuration string) (map[string]error, *failureHandler, time.Duration) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	t.Cleanup(SetMaxConcurrentJobs(4))
+	failures := newFailureHandler(policy)
+	e := newExecutor("none", nil, failures)
+	job := func(name string, script string) exctr.NamedJob {
+		runner := exec.Command("sh", "-c", script)
+		if tj := newTaskJob(failures.context(), runner, NewTask("lib#"+name, app.MonospaceConfigTask{}), RunOptions{FailurePolicy: policy}, nil); tj != nil {
+			return exctr.NamedJob{Name: name, Job: tj.run}
+		}
+		return exctr.NamedJob{Name: name, Job: runner}
+	}
+	ko := e.AddJob(job("ko", "exit 1"))
+	dependent := e.AddJob(job("dependent", "true"))
+	gate := e.AddJob(job("gate", "sleep "+gateDuration+" & wait"))
+	later := e.AddJob(job("later", "true"))
+	e.AddJobDependency(dependent, ko)
+	e.AddJobDependency(later, gate)
+	errs := map[string]error{}
+	var mu sync.Mutex
+	e.OnJobDone(func(jobs exctr.JobList, jobId int) {
+		mu.Lock()
+		errs[jobs[jobId].Name()] = jobs[jobId].Err
+		mu.Unlock()
+	})
+	start := time.Now()
+	e.DagExecute()
+	return errs, failures, time.Since(start)
+}
+
+func TestFailurePolicy_Continue(t *testing.T) {
+	errs, _, _ := runFailurePolicy(t, FailurePolicyContinue, "0.5")
+	if !errors.Is(errs["dependent"], exctr.ErrRequiredJobFailed) {
+		t.Errorf("tasks depending on a failed task should be skipped, got %v", errs["dependent"])
+	}
+	if errs["gate"] != nil || errs["later"] != nil {
+		t.Errorf("independent tasks should run, got %v", errs)
+	}
+}
+
+func TestFailurePolicy_Bail(t *testing.T) {
+	errs, failures, elapsed := runFailurePolicy(t, FailurePolicyBail, "10")
+	if elapsed > 5*time.Second {
+		t.Errorf("running tasks should be cancelled, took %v", elapsed)
+	}
+	if errs["gate"] == nil || !failures.isCancelled(2) || failures.isCancelled(0) {
+		t.Errorf("gate should be cancelled, got %v", errs["gate"])
+	}
+	if !isSkippedJobErr(errs["later"]) || !isSkippedJobErr(errs["dependent"]) {
+		t.Errorf("pending tasks should be skipped, got %v", errs)
+	}
+}
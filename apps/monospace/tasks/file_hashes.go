@@ -0,0 +1,197 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const fileHashIndexName = "file-hashes.json"
+
+// Files modified less than fileHashRacyDelay before being hashed are not
+// memoized: a later change keeping the same size within the filesystem mtime
+// granularity would otherwise go unnoticed.
+const fileHashRacyDelay = 2 * time.Second
+
+type fileHashEntry struct {
+	Size   int64  `json:"size"`
+	Mtime  int64  `json:"mtime"` // unix nanoseconds
+	Inode  uint64 `json:"inode"`
+	Sha256 string `json:"sha256"`
+}
+
+// fileHashIndex memoizes files content hashes across runs. A file is only read
+// again when its size, modification time or inode changed. The index is
+// persisted in the cache directory of the monospace.
+type fileHashIndex struct {
+	path    string // empty for an in memory only index
+	once    sync.Once
+	mu      sync.Mutex
+	entries map[string]fileHashEntry // indexed by absolute file path
+	dirty   bool
+}
+
+func newFileHashIndex(monospaceRoot string) *fileHashIndex {
+	idx := &fileHashIndex{}
+	if monospaceRoot != "" {
+		idx.path = filepath.Join(cacheBaseDir(monospaceRoot), fileHashIndexName)
+	}
+	return idx
+}
+
+func (idx *fileHashIndex) load() {
+	idx.entries = map[string]fileHashEntry{}
+	if idx.path == "" {
+		return
+	}
+	data, err := os.ReadFile(idx.path)
+	if err != nil {
+		return
+	}
+	// a corrupted index is ignored and rebuilt
+	if err := json.Unmarshal(data, &idx.entries); err != nil {
+		idx.entries = map[string]fileHashEntry{}
+	}
+}
+
+// hashFile returns the SHA256 of the file content, reading it only when it is
+// not in the index or changed since it was indexed. A nil index always reads
+// the file.
+func (idx *fileHashIndex) hashFile(path string) (string, error) {
+	if idx == nil {
+		return sha256File(path)
+	}
+	idx.once.Do(idx.load)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	entry := fileHashEntry{Size: info.Size(), Mtime: info.ModTime().UnixNano(), Inode: fileInode(info)}
+	idx.mu.Lock()
+	stored, ok := idx.entries[path]
+	idx.mu.Unlock()
+	if ok && stored.Size == entry.Size && stored.Mtime == entry.Mtime && stored.Inode == entry.Inode {
+		return stored.Sha256, nil
+	}
+	if entry.Sha256, err = sha256File(path); err != nil {
+		return "", err
+	}
+	if time.Since(info.ModTime()) > fileHashRacyDelay {
+		idx.mu.Lock()
+		idx.entries[path] = entry
+		idx.dirty = true
+		idx.mu.Unlock()
+	}
+	return entry.Sha256, nil
+}
+
+// hashFiles returns the content hashes of the given files in the same order,
+// hashing them with a pool of workers.
+func (idx *fileHashIndex) hashFiles(files []string) ([]string, error) {
+	sums := make([]string, len(files))
+	errs := make([]error, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(runtime.NumCPU(), len(files)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				sums[i], errs[i] = idx.hashFile(files[i])
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sums, nil
+}
+
+// save persists the index if it changed since it was loaded
+func (idx *fileHashIndex) save() error {
+	if idx == nil || idx.path == "" {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.dirty {
+		return nil
+	}
+	data, err := json.Marshal(idx.entries)
+	if err != nil {
+		return fmt.Errorf("marshaling file hash index: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0750); err != nil {
+		return fmt.Errorf("creating cache dir: %w", err)
+	}
+	// write to a temporary file first so concurrent runs never read a partial index
+	tmp, err := os.CreateTemp(filepath.Dir(idx.path), "."+fileHashIndexName+"-*")
+	if err != nil {
+		return fmt.Errorf("writing file hash index: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), idx.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("writing file hash index: %w", err)
+	}
+	idx.dirty = false
+	return nil
+}
+
+// PruneFileHashIndex removes the files that no longer exist from the index
+// persisted in the cache directory. It returns the number of removed files.
+func PruneFileHashIndex(monospaceRoot string) (int, error) {
+	idx := newFileHashIndex(monospaceRoot)
+	idx.once.Do(idx.load)
+	removed := 0
+	for path := range idx.entries {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			delete(idx.entries, path)
+			removed++
+		}
+	}
+	idx.dirty = removed > 0
+	return removed, idx.save()
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
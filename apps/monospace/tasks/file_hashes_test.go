@@ -0,0 +1,128 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// write content to path and set its modification time in the past so it can be indexed
+func writeOldFile(t *testing.T, path string, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileHashIndex_PersistsAndReusesHashes(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(t.TempDir(), "a.js")
+	mtime := time.Now().Add(-time.Hour)
+	writeOldFile(t, file, "aaa", mtime)
+	expected, _ := sha256File(file)
+
+	idx := newFileHashIndex(root)
+	if sum, err := idx.hashFile(file); err != nil || sum != expected {
+		t.Fatalf("hashFile: got %s, %v", sum, err)
+	}
+	if err := idx.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheBaseDir(root), fileHashIndexName)); err != nil {
+		t.Fatalf("index not saved: %v", err)
+	}
+
+	// same size and mtime: a new index loaded from disk doesn't read the file again
+	writeOldFile(t, file, "bbb", mtime)
+	if sum, _ := newFileHashIndex(root).hashFile(file); sum != expected {
+		t.Error("expected memoized hash when size, mtime and inode are unchanged")
+	}
+
+	// changed mtime: the file is read again
+	writeOldFile(t, file, "bbb", mtime.Add(time.Second))
+	if sum, _ := newFileHashIndex(root).hashFile(file); sum == expected {
+		t.Error("expected file to be hashed again when its mtime changed")
+	}
+}
+
+func TestPruneFileHashIndex(t *testing.T) {
+	root := t.TempDir()
+	dir := t.TempDir()
+	kept, deleted := filepath.Join(dir, "kept.js"), filepath.Join(dir, "deleted.js")
+	writeOldFile(t, kept, "kept", time.Now().Add(-time.Hour))
+	writeOldFile(t, deleted, "deleted", time.Now().Add(-time.Hour))
+	idx := newFileHashIndex(root)
+	idx.hashFiles([]string{kept, deleted})
+	idx.save()
+	os.Remove(deleted)
+
+	if removed, err := PruneFileHashIndex(root); err != nil || removed != 1 {
+		t.Fatalf("expected 1 removed file, got %d (%v)", removed, err)
+	}
+	idx = newFileHashIndex(root)
+	idx.once.Do(idx.load)
+	if _, ok := idx.entries[kept]; !ok || len(idx.entries) != 1 {
+		t.Errorf("expected only %s to be kept, got %v", kept, idx.entries)
+	}
+}
+
+func TestFileHashIndex_RecentFilesAreNotMemoized(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.js")
+	if err := os.WriteFile(file, []byte("aaa"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	idx := newFileHashIndex(t.TempDir())
+	idx.hashFile(file)
+	if len(idx.entries) != 0 || idx.dirty {
+		t.Error("recently modified files should not be memoized")
+	}
+}
+
+func TestFileHashIndex_HashFilesKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+	var files, expected []string
+	for i := 0; i < 50; i++ {
+		file := filepath.Join(dir, fmt.Sprintf("f%d", i))
+		os.WriteFile(file, []byte(fmt.Sprintf("content %d", i)), 0640)
+		sum, _ := sha256File(file)
+		files = append(files, file)
+		expected = append(expected, sum)
+	}
+	for _, idx := range []*fileHashIndex{nil, newFileHashIndex(t.TempDir())} {
+		sums, err := idx.hashFiles(files)
+		if err != nil {
+			t.Fatalf("hashFiles: %v", err)
+		}
+		if !reflect.DeepEqual(sums, expected) {
+			t.Error("hashes should be returned in the files order")
+		}
+	}
+	if _, err := newFileHashIndex("").hashFiles(append(files, filepath.Join(dir, "missing"))); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestComputeHash_ContentHashUnchangedWithIndex(t *testing.T) {
+	dir := makeTestProject(t, map[string]string{"a.go": "a", "b.go": "b"})
+	opts := baseOpts(dir, t.TempDir())
+	taskDef := baseTaskDef([]string{"go", "build"})
+	h1, _ := ComputeHash(opts, taskDef)
+	opts.fileHashes = newFileHashIndex(opts.MonospaceRoot)
+	h2, _ := ComputeHash(opts, taskDef)
+	if h1 != h2 {
+		t.Error("using a file hash index should not change the task hash")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import "os"
+
+// file index numbers are not exposed by os.FileInfo on windows, size and
+// modification time are used alone
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
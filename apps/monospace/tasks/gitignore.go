@@ -0,0 +1,120 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// ignoreRule is a single pattern of a .gitignore file
+type ignoreRule struct {
+	base     string // directory of the .gitignore file
+	pattern  string
+	negate   bool // pattern starts with "!"
+	dirOnly  bool // pattern ends with "/"
+	anchored bool // pattern contains a "/" and so is relative to base
+}
+
+// ignoreRules is a list of .gitignore rules, later rules take precedence
+type ignoreRules []ignoreRule
+
+// withFile returns the rules extended with the ones of the .gitignore file in
+// dir, if any. The receiver is never modified so it can be shared between
+// sibling directories.
+func (r ignoreRules) withFile(dir string) ignoreRules {
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return r
+	}
+	defer f.Close()
+	res := r[:len(r):len(r)]
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: dir}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:] // escaped leading "!" or "#"
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		res = append(res, rule)
+	}
+	return res
+}
+
+// ignored reports whether the file or directory at the given absolute path is
+// ignored by the rules
+func (r ignoreRules) ignored(absPath string, isDir bool) bool {
+	ignored := false
+	for _, rule := range r {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(rule.base, absPath)
+		if err != nil || !filepath.IsLocal(rel) {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if !rule.anchored {
+			rel = path.Base(rel)
+		}
+		if match, _ := doublestar.Match(rule.pattern, rel); match {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// loadParentIgnoreRules returns the rules of the .gitignore files in the
+// parent directories of dir, up to the root of the git repository containing
+// dir or the monospace root, whichever comes first.
+func loadParentIgnoreRules(dir string, monospaceRoot string) ignoreRules {
+	var parents []string
+	current := filepath.Clean(dir)
+	for {
+		if current == filepath.Clean(monospaceRoot) {
+			break
+		}
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(current)
+		if parent == current || monospaceRoot == "" {
+			break
+		}
+		current = parent
+		parents = append(parents, current)
+	}
+	var rules ignoreRules
+	for i := len(parents) - 1; i >= 0; i-- {
+		rules = rules.withFile(parents[i])
+	}
+	return rules
+}
@@ -0,0 +1,118 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// return files relative to dir, slash separated and sorted
+func relFiles(t *testing.T, dir string, files []string) []string {
+	t.Helper()
+	res := make([]string, 0, len(files))
+	for _, file := range files {
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, filepath.ToSlash(rel))
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestWalkProjectFiles_RespectsGitignore(t *testing.T) {
+	root := makeTestProject(t, map[string]string{
+		".gitignore":                    "node_modules\n*.log\n/coverage\n",
+		"apps/web/.gitignore":           "dist/\n!keep.log\n",
+		"apps/web/src/main.js":          "main",
+		"apps/web/src/debug.log":        "ignored by root",
+		"apps/web/keep.log":             "re-included",
+		"apps/web/dist/out.js":          "ignored by nested",
+		"apps/web/node_modules/a/i.js":  "ignored by root",
+		"apps/web/coverage/report.html": "anchored to root so not ignored",
+		"apps/web/src/dist":             "file named like an ignored dir",
+		"apps/web/lib/.gitignore":       "*.tmp\n",
+		"apps/web/lib/a.tmp":            "ignored",
+		"apps/web/lib/b.js":             "b",
+	})
+	projectDir := filepath.Join(root, "apps/web")
+	files, err := walkProjectFiles(projectDir, loadParentIgnoreRules(projectDir, root))
+	if err != nil {
+		t.Fatalf("walkProjectFiles: %v", err)
+	}
+	expected := []string{".gitignore", "coverage/report.html", "keep.log", "lib/.gitignore", "lib/b.js", "src/dist", "src/main.js"}
+	if got := relFiles(t, projectDir, files); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, want %v", got, expected)
+	}
+}
+
+func TestLoadParentIgnoreRules_StopsAtRepositoryRoot(t *testing.T) {
+	root := makeTestProject(t, map[string]string{
+		".gitignore":             "*.js\n",
+		"apps/ext/.git/HEAD":     "ref: refs/heads/main",
+		"apps/ext/lib/index.js":  "external repo file",
+		"apps/internal/index.js": "ignored",
+		"apps/internal/index.ts": "kept",
+	})
+	if rules := loadParentIgnoreRules(filepath.Join(root, "apps/ext/lib"), root); rules.ignored(filepath.Join(root, "apps/ext/lib/index.js"), false) {
+		t.Error("rules of the monospace repository should not apply to nested repositories")
+	}
+	rules := loadParentIgnoreRules(filepath.Join(root, "apps/internal"), root)
+	if !rules.ignored(filepath.Join(root, "apps/internal/index.js"), false) || rules.ignored(filepath.Join(root, "apps/internal/index.ts"), false) {
+		t.Error("rules of the monospace root should apply to internal projects")
+	}
+}
+
+func TestResolveInputFiles_NegatedPatterns(t *testing.T) {
+	dir := makeTestProject(t, map[string]string{
+		"src/a.go":      "a",
+		"src/a_test.go": "test",
+		"README.md":     "readme",
+	})
+	opts := baseOpts(dir, dir)
+	opts.Inputs = []string{"src/**", "!**/*_test.go"}
+	files, err := resolveInputFiles(opts)
+	if err != nil {
+		t.Fatalf("resolveInputFiles: %v", err)
+	}
+	if got := relFiles(t, dir, files); !reflect.DeepEqual(got, []string{"src/a.go"}) {
+		t.Errorf("got %v", got)
+	}
+
+	// only negated patterns start from all project files
+	opts.Inputs = []string{"!*.md"}
+	files, _ = resolveInputFiles(opts)
+	if got := relFiles(t, dir, files); !reflect.DeepEqual(got, []string{"src/a.go", "src/a_test.go"}) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestResolveInputFiles_GitLsFiles(t *testing.T) {
+	dir := makeTestProject(t, map[string]string{
+		".gitignore": "dist/\n",
+		"a.go":       "a",
+		"dist/b.js":  "ignored",
+	})
+	if out, err := exec.Command("git", "init", "-q", dir).CombinedOutput(); err != nil {
+		t.Skipf("git init failed: %s", out)
+	}
+	opts := baseOpts(dir, dir)
+	opts.UseGitFiles = true
+	files, err := resolveInputFiles(opts)
+	if err != nil {
+		t.Fatalf("resolveInputFiles: %v", err)
+	}
+	if got := relFiles(t, dir, files); !reflect.DeepEqual(got, []string{".gitignore", "a.go"}) {
+		t.Errorf("got %v", got)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/software-t-rex/monospace/app"
+	"github.com/software-t-rex/monospace/gomodules/utils"
+	"github.com/software-t-rex/monospace/mono"
+)
+
+type memoizedHash struct {
+	once     sync.Once
+	hash     string
+	manifest HashManifest
+	err      error
+}
+
+// taskHasher computes cache hashes of the tasks in a task list. A task hash
+// covers the hashes of all the tasks it depends on so a change in a dependency
+// busts the cache of its dependents. Hashes are memoized: as dependencies are
+// executed first, a dependency hash is computed when the dependency starts if it
+// is cached, or when first required by a dependent otherwise.
+type taskHasher struct {
+	taskList      TaskList
+	monospaceRoot string
+	remote        *RemoteCache
+	fileHashes    *fileHashIndex
+	mu            sync.Mutex
+	hashes        map[string]*memoizedHash
+}
+
+func newTaskHasher(taskList TaskList, monospaceRoot string, remote *RemoteCache) *taskHasher {
+	return &taskHasher{
+		taskList:      taskList,
+		monospaceRoot: monospaceRoot,
+		remote:        remote,
+		fileHashes:    newFileHashIndex(monospaceRoot),
+		hashes:        make(map[string]*memoizedHash, taskList.Len()),
+	}
+}
+
+// TaskCacheOptions returns the cache options for the given task as defined in
+// the pipeline, merging global settings of the config with the task ones.
+// It doesn't set remote cache nor dependencies hashes.
+func TaskCacheOptions(task *Task, config *app.MonospaceConfig, monospaceRoot string) CacheOptions {
+	strategy := task.TaskDef.CacheStrategy
+	if strategy == "" {
+		strategy = app.CacheStrategyContent
+	}
+	if config == nil {
+		config = &app.MonospaceConfig{}
+	}
+	maxEntries := task.TaskDef.CacheMaxEntries
+	if maxEntries == 0 {
+		maxEntries = config.CacheMaxEntries
+	}
+	if maxEntries == 0 {
+		maxEntries = app.DefaultCacheMaxEntries
+	}
+	return CacheOptions{
+		ProjectName:    task.Name.Project,
+		TaskName:       task.Name.Task,
+		ProjectPath:    mono.ProjectGetPath(task.Name.Project),
+		Mode:           task.TaskDef.Cache,
+		Strategy:       strategy,
+		Inputs:         task.TaskDef.Inputs,
+		Outputs:        task.TaskDef.Outputs,
+		MonospaceRoot:  monospaceRoot,
+		MaxEntries:     maxEntries,
+		GlobalInputs:   mergeLists(config.GlobalInputs, task.TaskDef.GlobalInputs),
+		HashEnv:        mergeLists(config.HashEnv, task.TaskDef.HashEnv),
+		PassThroughEnv: mergeLists(config.PassThroughEnv, task.TaskDef.PassThroughEnv),
+		UseGitFiles:    config.CacheGitLsFiles,
+		CacheFailures:  task.TaskDef.CacheFailures,
+		Params:         task.Params,
+	}
+}
+
+// return a sorted list of unique values from given lists
+func mergeLists(lists ...[]string) []string {
+	var res []string
+	for _, list := range lists {
+		for _, v := range list {
+			if !utils.SliceContains(res, v) {
+				res = append(res, v)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// return cache options for the given task without dependencies hashes
+func (h *taskHasher) cacheOptions(task *Task) CacheOptions {
+	opts := TaskCacheOptions(task, h.taskList.config, h.monospaceRoot)
+	opts.Remote = h.remote
+	opts.fileHashes = h.fileHashes
+	return opts
+}
+
+// compute the task hash including its dependencies hashes
+func (h *taskHasher) hash(task *Task) (string, HashManifest, error) {
+	opts := h.cacheOptions(task)
+	opts.DependencyHashes = make(map[string]string, len(task.TaskDef.DependsOn))
+	for _, depName := range task.TaskDef.DependsOn {
+		dep, ok := h.taskList.List[depName]
+		if !ok {
+			return "", HashManifest{}, fmt.Errorf("hashing %s: unknown dependency %s", task.String(), depName)
+		}
+		depHash, _, err := h.memoizedHash(dep)
+		if err != nil {
+			return "", HashManifest{}, fmt.Errorf("hashing %s dependency: %w", task.String(), err)
+		}
+		opts.DependencyHashes[depName] = depHash
+	}
+	return computeHash(opts, task.TaskDef)
+}
+
+// return the memoized hash of the task, computing it if needed. Concurrent
+// calls for the same task wait for the first one to complete.
+func (h *taskHasher) memoizedHash(task *Task) (string, HashManifest, error) {
+	h.mu.Lock()
+	entry, ok := h.hashes[task.String()]
+	if !ok {
+		entry = &memoizedHash{}
+		h.hashes[task.String()] = entry
+	}
+	h.mu.Unlock()
+	entry.once.Do(func() {
+		entry.hash, entry.manifest, entry.err = h.hash(task)
+	})
+	return entry.hash, entry.manifest, entry.err
+}
+
+// saveFileHashes persists the files hashes memoized while hashing tasks, it
+// must be called once all tasks are hashed
+func (h *taskHasher) saveFileHashes() {
+	if err := h.fileHashes.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+}
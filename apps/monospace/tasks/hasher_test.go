@@ -0,0 +1,106 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/software-t-rex/monospace/app"
+	"github.com/software-t-rex/monospace/mono"
+)
+
+// useTestMonospace makes root the current monospace root for the duration of the test
+func useTestMonospace(t *testing.T, root string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	mono.SpaceGetRootNoCache()
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		mono.SpaceGetRootNoCache()
+	})
+}
+
+func TestTaskHasher_DependencyChangeBustsDependentHash(t *testing.T) {
+	root := makeTestProject(t, map[string]string{
+		".monospace/monospace.yml": "projects: {}",
+		"lib/a.go":                 "a",
+		"app/b.go":                 "b",
+	})
+	useTestMonospace(t, root)
+	config := &app.MonospaceConfig{Projects: map[string]string{"lib": "internal", "app": "internal"}}
+	taskList := TaskList{List: map[string]*Task{
+		"lib#build": NewTask("lib#build", app.MonospaceConfigTask{Cmd: []string{"build"}}),
+		"app#build": NewTask("app#build", app.MonospaceConfigTask{Cmd: []string{"build"}, Cache: "skip", DependsOn: []string{"lib#build"}}),
+	}, config: config}
+
+	h1, _, err := newTaskHasher(taskList, root, nil).memoizedHash(taskList.List["app#build"])
+	if err != nil {
+		t.Fatalf("memoizedHash: %v", err)
+	}
+	// app files are unchanged, only its dependency project changed
+	os.WriteFile(filepath.Join(root, "lib/a.go"), []byte("changed"), 0640)
+	h2, _, err := newTaskHasher(taskList, root, nil).memoizedHash(taskList.List["app#build"])
+	if err != nil {
+		t.Fatalf("memoizedHash: %v", err)
+	}
+	if h1 == h2 {
+		t.Error("a change in a dependency should change the dependent task hash")
+	}
+}
+
+func TestTaskHasher_Memoized(t *testing.T) {
+	root := makeTestProject(t, map[string]string{
+		".monospace/monospace.yml": "projects: {}",
+		"lib/a.go":                 "a",
+	})
+	useTestMonospace(t, root)
+	taskList := TaskList{List: map[string]*Task{
+		"lib#build": NewTask("lib#build", app.MonospaceConfigTask{Cmd: []string{"build"}}),
+	}, config: &app.MonospaceConfig{Projects: map[string]string{"lib": "internal"}}}
+	hasher := newTaskHasher(taskList, root, nil)
+	h1, _, _ := hasher.memoizedHash(taskList.List["lib#build"])
+	os.WriteFile(filepath.Join(root, "lib/a.go"), []byte("changed"), 0640)
+	h2, _, _ := hasher.memoizedHash(taskList.List["lib#build"])
+	if h1 != h2 {
+		t.Error("hash should be computed only once per task during an execution")
+	}
+}
+
+func TestTaskCacheOptions_MergesGlobalSettings(t *testing.T) {
+	config := &app.MonospaceConfig{
+		GlobalInputs: []string{"pnpm-lock.yaml"},
+		HashEnv:      []string{"NODE_ENV", "CI"},
+	}
+	task := NewTask("lib#build", app.MonospaceConfigTask{
+		Cache:          "skip",
+		GlobalInputs:   []string{"tsconfig.base.json", "pnpm-lock.yaml"},
+		PassThroughEnv: []string{"NPM_TOKEN"},
+	})
+	opts := TaskCacheOptions(task, config, "/root")
+	if !reflect.DeepEqual(opts.GlobalInputs, []string{"pnpm-lock.yaml", "tsconfig.base.json"}) {
+		t.Errorf("unexpected global inputs %v", opts.GlobalInputs)
+	}
+	if !reflect.DeepEqual(opts.HashEnv, []string{"CI", "NODE_ENV"}) {
+		t.Errorf("unexpected hash env %v", opts.HashEnv)
+	}
+	if !reflect.DeepEqual(opts.PassThroughEnv, []string{"NPM_TOKEN"}) {
+		t.Errorf("unexpected pass through env %v", opts.PassThroughEnv)
+	}
+	if opts.MaxEntries != app.DefaultCacheMaxEntries {
+		t.Errorf("expected default max entries, got %d", opts.MaxEntries)
+	}
+}
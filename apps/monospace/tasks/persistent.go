@@ -0,0 +1,262 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/software-t-rex/monospace/app"
+	"github.com/software-t-rex/monospace/gomodules/utils"
+)
+
+// ErrTaskNotReady is returned by persistent tasks not meeting their ready
+// condition
+var ErrTaskNotReady = errors.New("not ready")
+
+// interval between checks of tcp and http ready conditions
+var readyPollInterval = 250 * time.Millisecond
+
+var readyHttpClient = &http.Client{Timeout: 2 * time.Second}
+
+// persistentProcess is a ready persistent task still running
+type persistentProcess struct {
+	name   string
+	exited <-chan error
+}
+
+func (c *taskJobs) addPersistent(name string, exited <-chan error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.persistent = append(c.persistent, persistentProcess{name, exited})
+}
+
+// waitPersistent waits for ready persistent tasks to exit, it returns false if
+// one of them failed
+func (c *taskJobs) waitPersistent() bool {
+	c.mu.Lock()
+	persistent := c.persistent
+	c.mu.Unlock()
+	ok := true
+	for _, process := range persistent {
+		if err := <-process.exited; err != nil {
+			utils.PrintError(fmt.Errorf("persistent task %s exited: %w", process.name, err))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// runPersistent runs a persistent task in its own process group, which is
+// stopped gracefully on interruption. Tasks without ready condition end with
+// their process, others as soon as the condition is met and their process is
+// then waited by waitPersistent (or handed to watchExit when set).
+func (j *taskJob) runPersistent() (string, error) {
+	ready := j.task.TaskDef.Ready
+	var pattern *regexp.Regexp
+	if ready != nil && ready.Output != "" {
+		pattern = regexp.MustCompile(ready.Output) // validated by GetStandardizedPipeline
+	}
+	output := newPersistentOutput(pattern)
+	ctx, stop := context.WithCancel(j.ctx)
+	defer func() {
+		if !j.isReady {
+			stop()
+		}
+	}()
+	cmd := commandContext(ctx, j.runner)
+	cmd.Stdout = output.writer(j.stdout, j.afterReady)
+	cmd.Stderr = output.writer(j.stderr, j.afterReady)
+	exited := make(chan error, 1)
+	go func() {
+		exited <- runInProcessGroup(cmd, j.grace)
+		stop()
+	}()
+	if ready == nil {
+		err := <-exited
+		return output.String(), err
+	}
+
+	timeout, _ := ready.GetTimeout()
+	readyCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	probed := make(chan error, 1)
+	go func() { probed <- waitReady(readyCtx, ready, output) }()
+	select {
+	case err := <-exited:
+		if err == nil {
+			err = errors.New("exited")
+		}
+		return output.String(), fmt.Errorf("%w: %w", ErrTaskNotReady, err)
+	case err := <-probed:
+		if err != nil {
+			stop()
+			<-exited
+			if errors.Is(err, context.DeadlineExceeded) && j.ctx.Err() == nil {
+				err = fmt.Errorf("%w after %v", ErrTaskNotReady, timeout)
+			}
+			return output.String(), err
+		}
+	}
+	j.isReady = true
+	if j.watchExit != nil {
+		j.watchExit(exited)
+	} else {
+		j.jobs.addPersistent(j.task.Name.String(), exited)
+	}
+	return output.setReady(), nil
+}
+
+// waitReady waits for the ready condition of a persistent task to be met, it
+// returns the context error when done before
+func waitReady(ctx context.Context, ready *app.MonospaceConfigTaskReady, output *persistentOutput) error {
+	if ready.Output != "" {
+		select {
+		case <-output.matched:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	for {
+		if probeReady(ctx, ready) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeReady returns true if the tcp address accepts connections or if the
+// http url answers a non error status
+func probeReady(ctx context.Context, ready *app.MonospaceConfigTaskReady) bool {
+	if ready.Tcp != "" {
+		address, _ := ready.GetTcpAddress()
+		conn, err := (&net.Dialer{Timeout: time.Second}).DialContext(ctx, "tcp", address)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ready.Http, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := readyHttpClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 400
+}
+
+// persistentOutput keeps the output of a persistent task until it's ready and
+// matches its lines against the ready pattern if any
+type persistentOutput struct {
+	mu      sync.Mutex
+	pattern *regexp.Regexp
+	kept    strings.Builder
+	ready   bool
+	matched chan struct{} // closed when a line matches the pattern
+}
+
+func newPersistentOutput(pattern *regexp.Regexp) *persistentOutput {
+	return &persistentOutput{pattern: pattern, matched: make(chan struct{})}
+}
+
+// writer returns a writer for an output stream of the task, forwarding to
+// live, and to afterReady once the task is ready
+func (o *persistentOutput) writer(live io.Writer, afterReady io.Writer) io.Writer {
+	return &persistentStream{output: o, live: live, afterReady: afterReady}
+}
+
+// write keeps p and checks completed lines against the pattern, it returns
+// true if the task was already ready
+func (o *persistentOutput) write(p []byte, line *[]byte) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.ready {
+		return true
+	}
+	o.kept.Write(p)
+	if o.pattern == nil {
+		return false
+	}
+	*line = append(*line, p...)
+	for {
+		end := bytes.IndexByte(*line, '\n')
+		if end < 0 {
+			break
+		}
+		o.match((*line)[:end])
+		*line = (*line)[end+1:]
+	}
+	// also match incomplete lines like prompts
+	o.match(*line)
+	return false
+}
+
+func (o *persistentOutput) match(line []byte) {
+	select {
+	case <-o.matched:
+		return
+	default:
+	}
+	if o.pattern.Match(ansiEscapeRegexp.ReplaceAll(line, nil)) {
+		close(o.matched)
+	}
+}
+
+// setReady stops keeping the output and returns the kept one
+func (o *persistentOutput) setReady() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ready = true
+	res := o.kept.String()
+	o.kept.Reset()
+	return res
+}
+
+func (o *persistentOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.kept.String()
+}
+
+type persistentStream struct {
+	output     *persistentOutput
+	live       io.Writer
+	afterReady io.Writer
+	line       []byte // incomplete line
+}
+
+func (s *persistentStream) Write(p []byte) (int, error) {
+	ready := s.output.write(p, &s.line)
+	if s.live != nil {
+		s.live.Write(p)
+	}
+	if ready && s.afterReady != nil {
+		s.afterReady.Write(p)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,169 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/software-t-rex/monospace/app"
+)
+
+func TestGetStandardizedPipeline_ReadyPersistentDependency(t *testing.T) {
+	config := &app.MonospaceConfig{Pipeline: map[string]app.MonospaceConfigTask{
+		"dev": {Persistent: true, Ready: &app.MonospaceConfigTaskReady{Tcp: "3000"}},
+		"e2e": {DependsOn: []string{"dev"}},
+	}}
+	if _, err := GetStandardizedPipeline(config, true); err != nil {
+		t.Errorf("depending on a persistent task with a ready condition should be allowed, got %v", err)
+	}
+	config.Pipeline["dev"] = app.MonospaceConfigTask{Persistent: true}
+	if _, err := GetStandardizedPipeline(config, true); err == nil {
+		t.Error("depending on a persistent task without ready condition should fail")
+	}
+	config.Pipeline = map[string]app.MonospaceConfigTask{"build": {Ready: &app.MonospaceConfigTaskReady{Tcp: "3000"}}}
+	if _, err := GetStandardizedPipeline(config, true); !errors.Is(err, app.ErrInvalidTaskConfig) {
+		t.Errorf("ready condition on a non persistent task should fail, got %v", err)
+	}
+}
+
+// start a persistent task job, cancelling ctx stops it
+func startPersistentJob(t *testing.T, ctx context.Context, taskDef app.MonospaceConfigTask, script string) (*taskJob, *taskJobs, string, error) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	taskDef.Persistent = true
+	jobs := newTaskJobs()
+	tj := newTaskJob(ctx, exec.Command("sh", "-c", script), NewTask("api#dev", taskDef), RunOptions{}, nil)
+	jobs.add(0, tj)
+	res, err := tj.run()
+	return tj, jobs, res, err
+}
+
+func TestTaskJob_PersistentReadyOnOutput(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ready := &app.MonospaceConfigTaskReady{Output: `listening on \d+`}
+	tj, jobs, res, err := startPersistentJob(t, ctx, app.MonospaceConfigTask{Ready: ready}, "echo starting; sleep 0.1; printf '\\033[1mlistening on 3000'; exec sleep 30")
+	if err != nil || !tj.isReady {
+		t.Fatalf("task should be ready, got %v", err)
+	}
+	if !strings.Contains(res, "starting") {
+		t.Errorf("output until ready should be returned, got %q", res)
+	}
+	cancel()
+	start := time.Now()
+	if jobs.waitPersistent() {
+		t.Error("stopped persistent task should be reported as failed")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("persistent task should stop on SIGTERM, took %v", elapsed)
+	}
+}
+
+func TestTaskJob_PersistentNotReady(t *testing.T) {
+	ready := &app.MonospaceConfigTaskReady{Output: "never", Timeout: "300ms"}
+	start := time.Now()
+	tj, _, _, err := startPersistentJob(t, context.Background(), app.MonospaceConfigTask{Ready: ready}, "exec sleep 30")
+	if !errors.Is(err, ErrTaskNotReady) || tj.isReady {
+		t.Errorf("expected ErrTaskNotReady after timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("task should be stopped after the ready timeout, took %v", elapsed)
+	}
+	_, _, _, err = startPersistentJob(t, context.Background(), app.MonospaceConfigTask{Ready: ready}, "exit 0")
+	if !errors.Is(err, ErrTaskNotReady) {
+		t.Errorf("expected ErrTaskNotReady when exiting before ready, got %v", err)
+	}
+}
+
+func TestTaskJob_PersistentGracePeriod(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := &app.MonospaceConfigTaskReady{Output: "ready"}
+	taskDef := app.MonospaceConfigTask{Ready: ready, GracePeriod: "200ms"}
+	_, jobs, _, err := startPersistentJob(t, ctx, taskDef, "trap '' TERM; echo ready; while true; do sleep 0.1; done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	start := time.Now()
+	jobs.waitPersistent()
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("task ignoring SIGTERM should be killed after its grace period, took %v", elapsed)
+	}
+}
+
+func TestTaskJob_PersistentReadyOnTcpAndHttp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	for _, ready := range []*app.MonospaceConfigTaskReady{{Tcp: "127.0.0.1:" + port}, {Http: server.URL + "/health"}} {
+		ctx, cancel := context.WithCancel(context.Background())
+		tj, jobs, _, err := startPersistentJob(t, ctx, app.MonospaceConfigTask{Ready: ready}, "exec sleep 30")
+		if err != nil || !tj.isReady {
+			t.Errorf("%+v: task should be ready, got %v", ready, err)
+		}
+		cancel()
+		jobs.waitPersistent()
+	}
+	ready := &app.MonospaceConfigTaskReady{Http: server.URL + "/", Timeout: "600ms"}
+	if _, _, _, err := startPersistentJob(t, context.Background(), app.MonospaceConfigTask{Ready: ready}, "exec sleep 30"); !errors.Is(err, ErrTaskNotReady) {
+		t.Errorf("error status should not be ready, got %v", err)
+	}
+}
+
+func TestTaskList_DependentsStartOnceReady(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	root := makeTestProject(t, map[string]string{
+		".monospace/monospace.yml": "projects: {}",
+		"api/a.go":                 "a",
+		"e2e/b.go":                 "b",
+	})
+	useTestMonospace(t, root)
+	taskList := TaskList{List: map[string]*Task{
+		"api#dev":  NewTask("api#dev", app.MonospaceConfigTask{Cmd: []string{"sh", "-c", "echo ready; sleep 1"}, Persistent: true, Ready: &app.MonospaceConfigTaskReady{Output: "ready"}}),
+		"e2e#test": NewTask("e2e#test", app.MonospaceConfigTask{Cmd: []string{"sh", "-c", "echo e2e > ../ran"}, DependsOn: []string{"api#dev"}}),
+	}, config: &app.MonospaceConfig{Projects: map[string]string{"api": "internal", "e2e": "internal"}}}
+	e, jobs := taskList.getExecutor(RunOptions{OutputMode: "grouped"})
+	start := time.Now()
+	if errs := e.DagExecute(); len(errs) > 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("execution should end once the persistent task is ready, took %v", elapsed)
+	}
+	if _, err := os.Stat(filepath.Join(root, "ran")); err != nil {
+		t.Error("dependent task should have run")
+	}
+	if !jobs.waitPersistent() {
+		t.Error("persistent task should exit successfully")
+	}
+}
@@ -0,0 +1,121 @@
+//go:build !windows
+
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// process groups don't receive the signals sent by the terminal, so we
+// forward interruptions to them before exiting
+var processGroups = struct {
+	sync.Mutex
+	pgids      map[int]time.Duration // grace period by process group id
+	forwarding bool
+}{pgids: map[int]time.Duration{}}
+
+// runInProcessGroup runs cmd in its own process group, the whole group is
+// killed when the command context is done or on interruption.
+// When a grace period is given the group receives SIGTERM instead, and is
+// killed if still running after the grace period.
+func runInProcessGroup(cmd *exec.Cmd, grace time.Duration) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	var killDeadline time.Time // set once the group received SIGTERM
+	if grace > 0 {
+		cmd.Cancel = func() error {
+			killDeadline = time.Now().Add(grace)
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		}
+		// only kills the leader, the rest of the group is killed below
+		cmd.WaitDelay = grace
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pgid := cmd.Process.Pid
+	processGroups.Lock()
+	processGroups.pgids[pgid] = grace
+	if !processGroups.forwarding {
+		processGroups.forwarding = true
+		go forwardInterruptions()
+	}
+	processGroups.Unlock()
+	defer func() {
+		processGroups.Lock()
+		delete(processGroups.pgids, pgid)
+		processGroups.Unlock()
+	}()
+	err := cmd.Wait()
+	// cmd.Wait returns after Cancel when the context is done
+	if !killDeadline.IsZero() {
+		killProcessGroupAt(pgid, killDeadline)
+	}
+	return err
+}
+
+// killProcessGroupAt waits for the processes left in the group by its leader
+// to exit, and kills them if still running at deadline
+func killProcessGroupAt(pgid int, deadline time.Time) {
+	for time.Now().Before(deadline) {
+		if syscall.Kill(-pgid, 0) != nil {
+			return // no process left in the group
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	syscall.Kill(-pgid, syscall.SIGKILL)
+}
+
+func forwardInterruptions() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	sig := (<-signals).(syscall.Signal)
+	deadlines := map[int]time.Time{}
+	processGroups.Lock()
+	for pgid, grace := range processGroups.pgids {
+		if grace > 0 {
+			syscall.Kill(-pgid, syscall.SIGTERM)
+			deadlines[pgid] = time.Now().Add(grace)
+		} else {
+			syscall.Kill(-pgid, sig)
+		}
+	}
+	processGroups.Unlock()
+	// wait for groups to exit gracefully, a second interruption kills them all
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for len(deadlines) > 0 {
+		killAll := false
+		select {
+		case <-signals:
+			killAll = true
+		case <-ticker.C:
+		}
+		processGroups.Lock()
+		for pgid, deadline := range deadlines {
+			if _, running := processGroups.pgids[pgid]; killAll || !running || time.Now().After(deadline) {
+				// also kills the processes left in the group by the leader
+				syscall.Kill(-pgid, syscall.SIGKILL)
+				delete(deadlines, pgid)
+			}
+		}
+		processGroups.Unlock()
+	}
+	// restore default behaviour and exit as if we were not listening
+	signal.Reset(os.Interrupt, syscall.SIGTERM)
+	syscall.Kill(os.Getpid(), sig)
+}
@@ -0,0 +1,86 @@
+//go:build !windows
+
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunInProcessGroup_KillsWholeGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", "sleep 30 & echo $! > "+pidFile+"; wait")
+	if err := runInProcessGroup(cmd, 0); err == nil {
+		t.Fatal("killed command should fail")
+	}
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	waitKilled(t, pid)
+}
+
+func TestRunInProcessGroup_KillsGroupAfterGracePeriod(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithCancel(context.Background())
+	// the leader exits on SIGTERM, its child ignores it
+	cmd := exec.CommandContext(ctx, "sh", "-c", "(trap '' TERM; exec sleep 30) & echo $! > "+pidFile+"; wait")
+	go func() {
+		for !fileExists(pidFile) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}()
+	start := time.Now()
+	if err := runInProcessGroup(cmd, 300*time.Millisecond); err == nil {
+		t.Fatal("terminated command should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("group should be killed after its grace period, took %v", elapsed)
+	}
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	waitKilled(t, pid)
+}
+
+func fileExists(path string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && len(data) > 0
+}
+
+// waitKilled fails the test if the process with given pid is still alive
+func waitKilled(t *testing.T, pid int) {
+	t.Helper()
+	// the killed child may take a moment to be reaped by init, or stay a
+	// zombie when init doesn't reap orphans (ie: in some containers)
+	for i := 0; i < 50; i++ {
+		if err := syscall.Kill(pid, 0); err != nil {
+			return
+		}
+		if stat, _ := exec.Command("ps", "-o", "stat=", "-p", strconv.Itoa(pid)).Output(); strings.HasPrefix(strings.TrimSpace(string(stat)), "Z") {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("child process %d should have been killed with its group", pid)
+}
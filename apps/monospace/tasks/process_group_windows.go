@@ -0,0 +1,21 @@
+//go:build windows
+
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"os/exec"
+	"time"
+)
+
+// runInProcessGroup runs cmd, only the command process is killed when the
+// command context is done on windows, without grace period.
+func runInProcessGroup(cmd *exec.Cmd, grace time.Duration) error {
+	return cmd.Run()
+}
@@ -0,0 +1,269 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	exctr "github.com/software-t-rex/go-jobExecutor/v2"
+	"github.com/software-t-rex/monospace/gomodules/utils"
+)
+
+const (
+	ReportFormatJSON  = "json"
+	ReportFormatJUnit = "junit"
+)
+
+const (
+	ReportStatusSucceed = "succeed"
+	ReportStatusFailed  = "failed"
+	ReportStatusSkipped = "skipped" // not run because a dependency or another task failed
+)
+
+// number of output lines kept in reports
+const reportOutputLines = 50
+
+var ErrInvalidReportFormat = errors.New("invalid report format")
+
+var ansiEscapeRegexp = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// RunReport is the machine readable report of an execution
+type RunReport struct {
+	Command   string       `json:"command"`
+	StartedAt time.Time    `json:"startedAt"`
+	Duration  float64      `json:"duration"` // seconds
+	Succeed   int          `json:"succeed"`
+	Failed    int          `json:"failed"`
+	Skipped   int          `json:"skipped"`
+	Tasks     []TaskReport `json:"tasks"`
+}
+
+// TaskReport is the report of a single job of an execution
+type TaskReport struct {
+	Name     string  `json:"name"`
+	Project  string  `json:"project"`
+	Task     string  `json:"task,omitempty"` // empty for exec and git commands
+	Status   string  `json:"status"`         // ReportStatusSucceed | ReportStatusFailed | ReportStatusSkipped
+	Duration float64 `json:"duration"`       // seconds
+	ExitCode int     `json:"exitCode"`
+	Cache    string  `json:"cache,omitempty"`    // "hit" | "miss" for cached tasks
+	Attempts int     `json:"attempts,omitempty"` // number of runs of tasks with retries
+	Error    string  `json:"error,omitempty"`
+	Output   string  `json:"output,omitempty"` // last lines of the output
+}
+
+// Reporter writes a report file once an executor is done
+type Reporter struct {
+	path      string
+	format    string
+	command   string
+	startedAt time.Time
+	jobs      map[int]TaskReport // jobs identity by job id
+	taskJobs  *taskJobs
+	failures  *failureHandler
+}
+
+// NewReporter returns a reporter writing to path in the given format. An empty
+// format is guessed from the file extension: junit for .xml files, json otherwise.
+func NewReporter(path string, format string, command string) (*Reporter, error) {
+	if format == "" {
+		format = utils.If(strings.EqualFold(filepath.Ext(path), ".xml"), ReportFormatJUnit, ReportFormatJSON)
+	}
+	if format != ReportFormatJSON && format != ReportFormatJUnit {
+		return nil, fmt.Errorf("%w %q, expected %s or %s", ErrInvalidReportFormat, format, ReportFormatJSON, ReportFormatJUnit)
+	}
+	return &Reporter{path: path, format: format, command: command, jobs: map[int]TaskReport{}}, nil
+}
+
+// AddJob sets the identity of the job with given id in the report, task is
+// empty for jobs which are not pipeline tasks.
+func (r *Reporter) AddJob(jobId int, name string, project string, task string) {
+	r.jobs[jobId] = TaskReport{Name: name, Project: project, Task: task}
+}
+
+// Attach makes the reporter write its report when the executor is done
+func (r *Reporter) Attach(e *exctr.JobExecutor) {
+	e.OnJobsStart(func(jobs exctr.JobList) {
+		r.startedAt = time.Now()
+	})
+	e.OnJobsDone(func(jobs exctr.JobList) {
+		if err := r.write(r.report(jobs)); err != nil {
+			utils.PrintWarning(fmt.Sprintf("writing report file: %s", err))
+		}
+	})
+}
+
+func (r *Reporter) report(jobs exctr.JobList) RunReport {
+	report := RunReport{Command: r.command, StartedAt: r.startedAt, Duration: time.Since(r.startedAt).Seconds()}
+	for jobId, job := range jobs {
+		taskReport, ok := r.jobs[jobId]
+		if !ok {
+			taskReport.Name = ansiEscapeRegexp.ReplaceAllString(job.Name(), "")
+		}
+		taskReport.Duration = job.Duration.Seconds()
+		taskReport.Output = outputExcerpt(job.Res)
+		switch {
+		case job.IsState(exctr.JobStateSucceed):
+			taskReport.Status = ReportStatusSucceed
+			report.Succeed++
+		case isSkippedJobErr(job.Err):
+			taskReport.Status = ReportStatusSkipped
+			report.Skipped++
+		default:
+			taskReport.Status = ReportStatusFailed
+			report.Failed++
+		}
+		if job.Err != nil {
+			taskReport.Error = ansiEscapeRegexp.ReplaceAllString(job.Err.Error(), "")
+		}
+		if r.failures.isCancelled(jobId) {
+			taskReport.Error = fmt.Sprintf("%s (%s)", ErrCancelledAfterFailure, taskReport.Error)
+		}
+		if tj := r.taskJobs.get(jobId); tj != nil {
+			taskReport.ExitCode = tj.exitCode
+			taskReport.Attempts = tj.attempts
+			if tj.hasher != nil {
+				taskReport.Cache = utils.If(tj.cacheHit, PlanCacheHit, PlanCacheMiss)
+			}
+		} else if taskReport.Status != ReportStatusSkipped {
+			taskReport.ExitCode = jobExitCode(job.Cmd, job.Err)
+		}
+		report.Tasks = append(report.Tasks, taskReport)
+	}
+	return report
+}
+
+func jobExitCode(cmd *exec.Cmd, err error) int {
+	if cmd != nil && cmd.ProcessState != nil {
+		return cmd.ProcessState.ExitCode()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	} else if err != nil {
+		return -1
+	}
+	return 0
+}
+
+// return the last lines of the output without terminal escape sequences
+func outputExcerpt(output string) string {
+	lines := strings.Split(strings.TrimRight(ansiEscapeRegexp.ReplaceAllString(output, ""), "\n"), "\n")
+	if len(lines) > reportOutputLines {
+		lines = lines[len(lines)-reportOutputLines:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *Reporter) write(report RunReport) error {
+	var data []byte
+	var err error
+	if r.format == ReportFormatJUnit {
+		data, err = xml.MarshalIndent(junitReport(report), "", "  ")
+		data = append([]byte(xml.Header), data...)
+	} else {
+		data, err = json.MarshalIndent(report, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(r.path); dir != "" {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0640)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+func junitSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+// junitReport maps each task to a test case, grouped in a test suite per project
+func junitReport(report RunReport) junitTestSuites {
+	res := junitTestSuites{
+		Name:     report.Command,
+		Tests:    len(report.Tasks),
+		Failures: report.Failed,
+		Skipped:  report.Skipped,
+		Time:     junitSeconds(report.Duration),
+	}
+	suiteIndex := map[string]int{}
+	suiteDurations := map[string]float64{}
+	for _, task := range report.Tasks {
+		i, ok := suiteIndex[task.Project]
+		if !ok {
+			i = len(res.Suites)
+			suiteIndex[task.Project] = i
+			res.Suites = append(res.Suites, junitTestSuite{Name: task.Project, Timestamp: report.StartedAt.Format(time.RFC3339)})
+		}
+		suite := &res.Suites[i]
+		testCase := junitTestCase{
+			Name:      utils.If(task.Task != "", task.Task, task.Name),
+			ClassName: task.Project,
+			Time:      junitSeconds(task.Duration),
+			SystemOut: task.Output,
+		}
+		switch task.Status {
+		case ReportStatusFailed:
+			testCase.Failure = &junitMessage{Message: task.Error, Content: task.Output}
+			suite.Failures++
+		case ReportStatusSkipped:
+			testCase.Skipped = &junitMessage{Message: task.Error}
+			suite.Skipped++
+		}
+		suite.Tests++
+		suiteDurations[task.Project] += task.Duration
+		suite.Time = junitSeconds(suiteDurations[task.Project])
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	return res
+}
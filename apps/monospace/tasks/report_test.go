@@ -0,0 +1,105 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	exctr "github.com/software-t-rex/go-jobExecutor/v2"
+)
+
+func TestNewReporter_Format(t *testing.T) {
+	for path, want := range map[string]string{"report.json": ReportFormatJSON, "out/junit.XML": ReportFormatJUnit, "report": ReportFormatJSON} {
+		r, err := NewReporter(path, "", "monospace run")
+		if err != nil || r.format != want {
+			t.Errorf("%s: expected %s format, got %+v, %v", path, want, r, err)
+		}
+	}
+	if _, err := NewReporter("report.json", "yaml", "monospace run"); !errors.Is(err, ErrInvalidReportFormat) {
+		t.Errorf("expected ErrInvalidReportFormat, got %v", err)
+	}
+}
+
+// run jobs: ok succeeds, ko fails with exit code 3 and dependent is skipped
+func runReportedJobs(t *testing.T, reportFile string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	r, err := NewReporter(reportFile, "", "monospace exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := exctr.NewExecutor()
+	ok := e.AddJob(exctr.NamedJob{Name: "ok", Job: exec.Command("sh", "-c", "printf '\\033[1mdone\\033[0m\\n'")})
+	ko := e.AddJob(exctr.NamedJob{Name: "ko", Job: exec.Command("sh", "-c", "echo broken; exit 3")})
+	dependent := e.AddJob(exctr.NamedJob{Name: "dependent", Job: exec.Command("true")})
+	e.AddJobDependency(dependent, ko)
+	r.AddJob(ok.Id(), "lib: build", "lib", "")
+	r.AddJob(ko.Id(), "app#build", "app", "build")
+	r.AddJob(dependent.Id(), "app#deploy", "app", "deploy")
+	r.Attach(e)
+	e.DagExecute()
+}
+
+func TestReporter_JSON(t *testing.T) {
+	reportFile := filepath.Join(t.TempDir(), "reports", "run.json")
+	runReportedJobs(t, reportFile)
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("report not written: %v", err)
+	}
+	var report RunReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Command != "monospace exec" || report.Succeed != 1 || report.Failed != 1 || report.Skipped != 1 || len(report.Tasks) != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	ok, ko, dependent := report.Tasks[0], report.Tasks[1], report.Tasks[2]
+	if ok.Name != "lib: build" || ok.Project != "lib" || ok.Status != ReportStatusSucceed || ok.ExitCode != 0 || ok.Output != "done" {
+		t.Errorf("unexpected succeed task report %+v", ok)
+	}
+	if ko.Task != "build" || ko.Status != ReportStatusFailed || ko.ExitCode != 3 || ko.Output != "broken" || ko.Error == "" {
+		t.Errorf("unexpected failed task report %+v", ko)
+	}
+	if dependent.Status != ReportStatusSkipped {
+		t.Errorf("unexpected skipped task report %+v", dependent)
+	}
+}
+
+func TestReporter_JUnit(t *testing.T) {
+	reportFile := filepath.Join(t.TempDir(), "junit.xml")
+	runReportedJobs(t, reportFile)
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("report not written: %v", err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatalf("invalid junit report: %v\n%s", err, data)
+	}
+	if suites.Tests != 3 || suites.Failures != 1 || suites.Skipped != 1 || len(suites.Suites) != 2 {
+		t.Fatalf("unexpected junit report:\n%s", data)
+	}
+	app := suites.Suites[1]
+	if app.Name != "app" || app.Tests != 2 || app.Cases[0].Name != "build" || app.Cases[0].Failure == nil || app.Cases[1].Skipped == nil {
+		t.Errorf("unexpected app test suite %+v", app)
+	}
+	if !strings.Contains(string(data), "broken") {
+		t.Errorf("failure output should be reported:\n%s", data)
+	}
+}
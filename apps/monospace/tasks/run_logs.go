@@ -0,0 +1,351 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	exctr "github.com/software-t-rex/go-jobExecutor/v2"
+	"github.com/software-t-rex/monospace/gomodules/utils"
+)
+
+// run ids are the start time of runs, suffixed with -1, -2... on collisions
+const runLogIdLayout = "20060102-150405"
+
+// report of a run inside its directory, missing for interrupted runs
+const runLogReportFile = "run.json"
+
+// RunLogLast designates the most recent run
+const RunLogLast = "last"
+
+var ErrRunLogNotFound = errors.New("run log not found")
+
+// interval between checks for new content when following a log file
+var followPollInterval = 250 * time.Millisecond
+
+// RunLog is a past run kept in .monospace/logs/{run-id}
+type RunLog struct {
+	Id     string
+	Dir    string
+	Report *RunReport // nil when the run was interrupted or is still running
+}
+
+// RunLogsDir returns the directory holding the logs of past runs.
+func RunLogsDir(monospaceRoot string) string {
+	return filepath.Join(monospaceRoot, ".monospace", "logs")
+}
+
+// taskLogFileName returns the log file name of a task in a run directory,
+// named like cache directories: {proj}#{task}.log
+func taskLogFileName(name string) string {
+	return strings.ReplaceAll(name, "/", "__") + ".log"
+}
+
+// ListRunLogs returns the runs kept in the logs directory, newest first.
+func ListRunLogs(monospaceRoot string) ([]RunLog, error) {
+	entries, err := os.ReadDir(RunLogsDir(monospaceRoot))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var res []RunLog
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].IsDir() {
+			res = append(res, readRunLog(monospaceRoot, entries[i].Name()))
+		}
+	}
+	return res, nil
+}
+
+// GetRunLog returns the run with the given id, RunLogLast or an empty id
+// return the most recent one.
+func GetRunLog(monospaceRoot string, id string) (RunLog, error) {
+	if id == "" || id == RunLogLast {
+		runs, err := ListRunLogs(monospaceRoot)
+		if err != nil {
+			return RunLog{}, err
+		}
+		if len(runs) == 0 {
+			return RunLog{}, fmt.Errorf("%w: no run logged yet", ErrRunLogNotFound)
+		}
+		return runs[0], nil
+	}
+	if filepath.Base(id) != id {
+		return RunLog{}, fmt.Errorf("%w: %s", ErrRunLogNotFound, id)
+	}
+	info, err := os.Stat(filepath.Join(RunLogsDir(monospaceRoot), id))
+	if err != nil || !info.IsDir() {
+		return RunLog{}, fmt.Errorf("%w: %s", ErrRunLogNotFound, id)
+	}
+	return readRunLog(monospaceRoot, id), nil
+}
+
+func readRunLog(monospaceRoot string, id string) RunLog {
+	run := RunLog{Id: id, Dir: filepath.Join(RunLogsDir(monospaceRoot), id)}
+	data, err := os.ReadFile(filepath.Join(run.Dir, runLogReportFile))
+	if err != nil {
+		return run
+	}
+	var report RunReport
+	if json.Unmarshal(data, &report) == nil {
+		run.Report = &report
+	}
+	return run
+}
+
+// Tasks returns the sorted names of the tasks logged in the run
+func (r RunLog) Tasks() ([]string, error) {
+	var names []string
+	if r.Report != nil {
+		for _, task := range r.Report.Tasks {
+			names = append(names, task.Name)
+		}
+	} else {
+		entries, err := os.ReadDir(r.Dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if name, ok := strings.CutSuffix(entry.Name(), ".log"); ok && !entry.IsDir() {
+				names = append(names, strings.ReplaceAll(name, "__", "/"))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// TaskLogPath returns the path of the log file of the given task (in
+// project#task form) in the run.
+func (r RunLog) TaskLogPath(task string) (string, error) {
+	path := filepath.Join(r.Dir, taskLogFileName(task))
+	if filepath.Dir(path) != r.Dir || !utils.FileExistsNoErr(path) {
+		return "", fmt.Errorf("%w: no log for %s in run %s", ErrRunLogNotFound, task, r.Id)
+	}
+	return path, nil
+}
+
+// TailFile writes the last lines of the file at path to w (the whole file
+// when lines <= 0). When follow is set, content appended to the file is
+// written until ctx is done.
+func TailFile(ctx context.Context, path string, lines int, follow bool, w io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if lines > 0 {
+		data = lastLines(data, lines)
+	}
+	if _, err := w.Write(data); err != nil || !follow {
+		return err
+	}
+	ticker := time.NewTicker(followPollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := io.Copy(w, file); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// return the last n lines of data, ignoring a final line feed
+func lastLines(data []byte, n int) []byte {
+	end := len(bytes.TrimSuffix(data, []byte("\n")))
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			n--
+			if n == 0 {
+				return data[i+1:]
+			}
+		}
+	}
+	return data
+}
+
+// newRunLogDir creates the directory of a run started at the given time
+func newRunLogDir(monospaceRoot string, startedAt time.Time) (string, error) {
+	base := RunLogsDir(monospaceRoot)
+	if err := os.MkdirAll(base, 0750); err != nil {
+		return "", err
+	}
+	id := startedAt.Format(runLogIdLayout)
+	for i := 1; ; i++ {
+		dir := filepath.Join(base, id)
+		err := os.Mkdir(dir, 0750)
+		if err == nil {
+			return dir, nil
+		} else if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+		id = fmt.Sprintf("%s-%d", startedAt.Format(runLogIdLayout), i)
+	}
+}
+
+// rotateRunLogs removes the oldest runs to keep at most maxRuns of them
+func rotateRunLogs(monospaceRoot string, maxRuns int) error {
+	runs, err := ListRunLogs(monospaceRoot)
+	if err != nil || len(runs) <= maxRuns {
+		return err
+	}
+	var errs []error
+	for _, run := range runs[maxRuns:] {
+		errs = append(errs, os.RemoveAll(run.Dir))
+	}
+	return errors.Join(errs...)
+}
+
+// runLogWriter writes to the log file of a job and to its live stream if any,
+// log file errors are ignored so that they never break the task
+type runLogWriter struct {
+	live io.Writer
+	file io.Writer
+}
+
+func (w runLogWriter) Write(p []byte) (int, error) {
+	w.file.Write(p)
+	if w.live == nil {
+		return len(p), nil
+	}
+	return w.live.Write(p)
+}
+
+// runLogger writes the full output of each job of an executor to its own file
+// in a new run directory, and the run report once the executor is done.
+type runLogger struct {
+	root     string
+	maxRuns  int
+	reporter *Reporter // jobs identity and run report
+	mu       sync.Mutex
+	files    map[int]*os.File
+	streamed map[int]bool // jobs whose output is written to their file while running
+}
+
+// newRunLogger returns nil when maxRuns is 0 (logs are disabled)
+func newRunLogger(monospaceRoot string, maxRuns int, command string) *runLogger {
+	if maxRuns <= 0 {
+		return nil
+	}
+	return &runLogger{
+		root:     monospaceRoot,
+		maxRuns:  maxRuns,
+		reporter: &Reporter{format: ReportFormatJSON, command: command, jobs: map[int]TaskReport{}},
+		files:    map[int]*os.File{},
+		streamed: map[int]bool{},
+	}
+}
+
+// addJob sets the task name of the job with given id
+func (l *runLogger) addJob(jobId int, name TaskName) {
+	if l == nil {
+		return
+	}
+	l.reporter.AddJob(jobId, name.String(), name.Project, name.Task)
+}
+
+// attach must be called once output modes are attached to the executor, so
+// that the live streams they set are teed to the log files. Files of ready
+// persistent tasks are left open for the output of their process.
+func (l *runLogger) attach(e *exctr.JobExecutor, taskJobs *taskJobs, failures *failureHandler) {
+	if l == nil {
+		return
+	}
+	l.reporter.taskJobs = taskJobs
+	l.reporter.failures = failures
+	e.OnJobsStart(func(jobs exctr.JobList) {
+		l.reporter.startedAt = time.Now()
+		dir, err := newRunLogDir(l.root, l.reporter.startedAt)
+		if err != nil {
+			utils.PrintWarning(fmt.Sprintf("run logs disabled: %s", err))
+			return
+		}
+		l.reporter.path = filepath.Join(dir, runLogReportFile)
+		if err := rotateRunLogs(l.root, l.maxRuns); err != nil {
+			utils.PrintWarning(fmt.Sprintf("removing old run logs: %s", err))
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for jobId, job := range jobs {
+			name := ansiEscapeRegexp.ReplaceAllString(job.Name(), "")
+			if taskReport, ok := l.reporter.jobs[jobId]; ok {
+				name = taskReport.Name
+			}
+			file, err := os.Create(filepath.Join(dir, taskLogFileName(name)))
+			if err != nil {
+				utils.PrintWarning(fmt.Sprintf("creating %s log file: %s", name, err))
+				continue
+			}
+			l.files[jobId] = file
+			if tj := taskJobs.get(jobId); tj != nil {
+				tj.stdout = runLogWriter{tj.stdout, file}
+				tj.stderr = runLogWriter{tj.stderr, file}
+				l.streamed[jobId] = true
+			} else if job.Cmd != nil && (job.Cmd.Stdout != nil || job.Cmd.Stderr != nil) {
+				// commands without live streams return their output as result
+				job.Cmd.Stdout = runLogWriter{job.Cmd.Stdout, file}
+				job.Cmd.Stderr = runLogWriter{job.Cmd.Stderr, file}
+				l.streamed[jobId] = true
+			}
+		}
+	})
+	e.OnJobDone(func(jobs exctr.JobList, jobId int) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		file := l.files[jobId]
+		if file == nil {
+			return
+		}
+		job := jobs[jobId]
+		if !l.streamed[jobId] && job.Res != "" {
+			io.WriteString(file, job.Res)
+			if !strings.HasSuffix(job.Res, "\n") {
+				io.WriteString(file, "\n")
+			}
+		}
+		if job.Err != nil {
+			fmt.Fprintf(file, "[%s]\n", ansiEscapeRegexp.ReplaceAllString(job.Err.Error(), ""))
+		}
+	})
+	e.OnJobsDone(func(jobs exctr.JobList) {
+		if l.reporter.path == "" {
+			return
+		}
+		if err := l.reporter.write(l.reporter.report(jobs)); err != nil {
+			utils.PrintWarning(fmt.Sprintf("writing run log report: %s", err))
+		}
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for jobId, file := range l.files {
+			if tj := taskJobs.get(jobId); tj == nil || !tj.isReady {
+				file.Close()
+			}
+		}
+	})
+}
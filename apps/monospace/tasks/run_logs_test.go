@@ -0,0 +1,136 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/software-t-rex/monospace/app"
+)
+
+func TestRunLogger_WritesTaskLogsAndRotates(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	root := makeTestProject(t, map[string]string{
+		".monospace/monospace.yml": "projects: {}",
+		"lib/a.go":                 "a",
+		"web/b.go":                 "b",
+	})
+	useTestMonospace(t, root)
+	taskList := TaskList{List: map[string]*Task{
+		"lib#build": NewTask("lib#build", app.MonospaceConfigTask{Cmd: []string{"sh", "-c", "echo building; echo warn >&2"}}),
+		"web#test":  NewTask("web#test", app.MonospaceConfigTask{Cmd: []string{"sh", "-c", "echo testing; exit 3"}, Timeout: "10s", DependsOn: []string{"lib#build"}}),
+	}, config: &app.MonospaceConfig{Projects: map[string]string{"lib": "internal", "web": "internal"}, LogsMaxRuns: 2}}
+	for i := 0; i < 3; i++ {
+		e, _ := taskList.getExecutor(RunOptions{OutputMode: "grouped", Command: "monospace run test"})
+		e.DagExecute()
+	}
+
+	runs, err := ListRunLogs(root)
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("expected 2 runs to be kept, got %d (%v)", len(runs), err)
+	}
+	if runs[0].Id <= runs[1].Id {
+		t.Errorf("expected newest run first, got %s then %s", runs[0].Id, runs[1].Id)
+	}
+	run, err := GetRunLog(root, RunLogLast)
+	if err != nil || run.Id != runs[0].Id {
+		t.Fatalf("expected last run %s, got %s (%v)", runs[0].Id, run.Id, err)
+	}
+	if run.Report == nil || run.Report.Command != "monospace run test" || run.Report.Succeed != 1 || run.Report.Failed != 1 {
+		t.Fatalf("unexpected run report %+v", run.Report)
+	}
+	names, _ := run.Tasks()
+	if strings.Join(names, ",") != "lib#build,web#test" {
+		t.Errorf("unexpected run tasks %v", names)
+	}
+
+	expected := map[string][]string{
+		"lib#build": {"building\n", "warn\n"},
+		"web#test":  {"testing\n[exit status 3]\n"},
+	}
+	for name, parts := range expected {
+		path, err := run.TaskLogPath(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(path)
+		for _, part := range parts {
+			if !strings.Contains(string(data), part) {
+				t.Errorf("%s log should contain %q, got %q", name, part, data)
+			}
+		}
+	}
+	if _, err := run.TaskLogPath("web#lint"); !errors.Is(err, ErrRunLogNotFound) {
+		t.Errorf("expected ErrRunLogNotFound for unknown task, got %v", err)
+	}
+	if _, err := GetRunLog(root, "../logs"); !errors.Is(err, ErrRunLogNotFound) {
+		t.Errorf("expected ErrRunLogNotFound for invalid run id, got %v", err)
+	}
+}
+
+func TestRunLogger_Disabled(t *testing.T) {
+	root := t.TempDir()
+	if logger := newRunLogger(root, (&app.MonospaceConfig{LogsMaxRuns: -1}).GetLogsMaxRuns(), ""); logger != nil {
+		t.Error("expected no run logger when logs are disabled")
+	}
+	if runs, err := ListRunLogs(root); err != nil || len(runs) != 0 {
+		t.Errorf("expected no runs, got %v (%v)", runs, err)
+	}
+}
+
+func TestNewRunLogDir_Collision(t *testing.T) {
+	root := t.TempDir()
+	startedAt := time.Date(2024, 1, 31, 14, 30, 5, 0, time.UTC)
+	first, err := newRunLogDir(root, startedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newRunLogDir(root, startedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(first) != "20240131-143005" || filepath.Base(second) != "20240131-143005-1" {
+		t.Errorf("unexpected run dirs %s and %s", first, second)
+	}
+}
+
+func TestTailFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "task.log")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := TailFile(context.Background(), path, 2, false, &out); err != nil || out.String() != "two\nthree\n" {
+		t.Errorf("expected last 2 lines, got %q (%v)", out.String(), err)
+	}
+
+	followPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { followPollInterval = 250 * time.Millisecond })
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0640)
+		file.WriteString("four\n")
+		file.Close()
+	}()
+	var followed bytes.Buffer
+	if err := TailFile(ctx, path, 0, true, &followed); err != nil || followed.String() != "one\ntwo\nthree\nfour\n" {
+		t.Errorf("expected appended content to be followed, got %q (%v)", followed.String(), err)
+	}
+}
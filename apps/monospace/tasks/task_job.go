@@ -0,0 +1,298 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrCachedFailure is returned when replaying a failed run from the cache
+var ErrCachedFailure = errors.New("cached failure")
+
+// ErrTaskTimeout is returned by attempts running longer than the task timeout
+var ErrTaskTimeout = errors.New("timeout")
+
+// taskJob runs a task with cache, retries and timeout logic: it replays the
+// cached output on a hit, and runs the task recording its output streams on a
+// miss, retrying failed attempts. Persistent tasks are run by runPersistent.
+type taskJob struct {
+	task   *Task
+	runner *exec.Cmd       // each attempt runs a copy of it
+	ctx    context.Context // cancels running attempts
+	hasher *taskHasher     // nil when caching is disabled
+	opts   CacheOptions
+	jobs   *taskJobs
+	// retries settings
+	retries    int
+	retryDelay time.Duration
+	timeout    time.Duration
+	grace      time.Duration // persistent tasks grace period
+	// live destination of each stream, set by the executor output mode
+	// (nil = output only returned as the job result)
+	stdout io.Writer
+	stderr io.Writer
+	// additional destination of the output of persistent tasks once ready
+	afterReady io.Writer
+	// when set, processes of ready persistent tasks are handed to it instead
+	// of being waited by waitPersistent
+	watchExit func(exited <-chan error)
+	// result of the last run, for reports
+	cacheHit bool
+	exitCode int
+	attempts int
+	retried  strings.Builder // output of failed attempts
+	isReady  bool            // persistent task met its ready condition
+}
+
+// taskJobs keeps track of the task jobs of an executor by job id
+type taskJobs struct {
+	mu         sync.Mutex
+	jobs       map[int]*taskJob
+	saved      time.Duration       // sum of the original durations of the replayed tasks
+	written    bool                // entries were added to the local cache
+	persistent []persistentProcess // ready persistent tasks still running
+}
+
+func newTaskJobs() *taskJobs {
+	return &taskJobs{jobs: map[int]*taskJob{}}
+}
+
+func (c *taskJobs) add(jobId int, job *taskJob) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	job.jobs = c
+	c.jobs[jobId] = job
+}
+
+func (c *taskJobs) get(jobId int) *taskJob {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.jobs[jobId]
+}
+
+func (c *taskJobs) addSaved(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.saved += d
+}
+
+func (c *taskJobs) addWritten() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = true
+}
+
+// Written returns true when entries were added to the local cache so far
+func (c *taskJobs) Written() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.written
+}
+
+// Saved returns the time saved by cache hits so far
+func (c *taskJobs) Saved() time.Duration {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.saved
+}
+
+// isTaskCached returns false when the task has caching disabled or --no-cache
+// is set.
+func isTaskCached(task *Task, opts RunOptions) bool {
+	return !opts.NoCache && (task.TaskDef.Cache == "skip" || task.TaskDef.Cache == "restore")
+}
+
+// newTaskJob returns nil when the task is not persistent and has no cache,
+// retries or timeout, such tasks are run as plain commands unless running
+// tasks can be cancelled (--bail). Attempts are cancelled with ctx.
+func newTaskJob(ctx context.Context, taskRunner *exec.Cmd, task *Task, opts RunOptions, hasher *taskHasher) *taskJob {
+	// invalid values are reported by GetStandardizedPipeline
+	if task.Persists() {
+		grace, _ := task.TaskDef.GetGracePeriod()
+		return &taskJob{task: task, runner: taskRunner, ctx: ctx, grace: grace}
+	}
+	retries, _ := task.TaskDef.GetRetries()
+	retryDelay, _ := task.TaskDef.GetRetryDelay()
+	timeout, _ := task.TaskDef.GetTimeout()
+	cached := isTaskCached(task, opts)
+	if !cached && retries == 0 && timeout == 0 && opts.FailurePolicy != FailurePolicyBail {
+		return nil
+	}
+	j := &taskJob{task: task, runner: taskRunner, ctx: ctx, retries: retries, retryDelay: retryDelay, timeout: timeout}
+	if cached {
+		j.hasher = hasher
+		j.opts = hasher.cacheOptions(task)
+	}
+	return j
+}
+
+// reset prepares the task job for a new run cancelled with ctx
+func (j *taskJob) reset(ctx context.Context) {
+	j.ctx = ctx
+	j.cacheHit = false
+	j.exitCode = 0
+	j.attempts = 0
+	j.retried.Reset()
+	j.isReady = false
+}
+
+// run checks the cache before running the task and saves the result on a miss.
+// It returns the combined output of the task, including failed attempts.
+func (j *taskJob) run() (string, error) {
+	if j.task.Persists() {
+		return j.runPersistent()
+	}
+	if j.hasher == nil {
+		output, runErr := j.execute()
+		return j.retried.String() + output.Combined(), runErr
+	}
+	hash, manifest, err := j.hasher.memoizedHash(j.task)
+	if err != nil {
+		// hash failure is non-fatal: run the task normally
+		output, runErr := j.execute()
+		return j.retried.String() + output.Combined(), runErr
+	}
+	result, checkErr := Check(j.opts, hash)
+	if checkErr != nil {
+		fmt.Fprintf(os.Stderr, "warning: cache check failed: %v\n", checkErr)
+	}
+	if result.Fetched {
+		j.jobs.addWritten()
+	}
+	if result.Hit {
+		if j.task.TaskDef.Cache != "restore" {
+			return j.replay(fmt.Sprintf("[cache hit: %s]\n", hash[:8]), result)
+		}
+		if err := Restore(j.opts, result); err != nil {
+			// corrupted entries are treated as a miss and overwritten by the next Save
+			fmt.Fprintf(os.Stderr, "warning: cache restore failed: %v, re-running task\n", err)
+			// fall through to cache miss
+		} else {
+			return j.replay(fmt.Sprintf("[cache hit: %s, restored]\n", hash[:8]), result)
+		}
+	}
+	// cache miss: run, record output, and save to cache
+	output, runErr := j.execute()
+	// negative exit codes are start failures or signals, not task results
+	if runErr == nil || (j.opts.CacheFailures && output.ExitCode > 0) {
+		j.opts.HashManifest = &manifest
+		if err := SaveOutput(j.opts, hash, output); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: cache save failed: %v\n", err)
+		} else {
+			j.jobs.addWritten()
+		}
+	}
+	return j.retried.String() + output.Combined(), runErr
+}
+
+// replay writes the cached output of a hit to the live streams and returns it.
+// Cached failures return an error wrapping ErrCachedFailure.
+func (j *taskJob) replay(header string, result CacheResult) (string, error) {
+	output, _ := readCachedStreams(result.CacheDir)
+	var err error
+	if output.ExitCode != 0 {
+		header = fmt.Sprintf("[cached failure: %s, exit code %d]\n", result.Hash[:8], output.ExitCode)
+		err = fmt.Errorf("%w: exit status %d", ErrCachedFailure, output.ExitCode)
+	}
+	if j.stdout != nil {
+		io.WriteString(j.stdout, header)
+	}
+	output.Replay(j.stdout, j.stderr)
+	if j.jobs != nil {
+		j.jobs.addSaved(output.Duration)
+	}
+	j.cacheHit = true
+	j.exitCode = output.ExitCode
+	return header + output.Combined(), err
+}
+
+// execute runs attempts of the task until one succeeds or retries are
+// exhausted, and returns the output of the last one. Outputs of failed
+// attempts are kept in j.retried.
+func (j *taskJob) execute() (TaskOutput, error) {
+	for {
+		j.attempts++
+		output, err := j.executeAttempt()
+		j.exitCode = output.ExitCode
+		if err == nil || j.attempts > j.retries || j.ctx.Err() != nil {
+			return output, err
+		}
+		header := fmt.Sprintf("[attempt %d/%d failed: %s, retrying]\n", j.attempts, j.retries+1, err)
+		j.retried.WriteString(output.Combined())
+		j.retried.WriteString(header)
+		if j.stdout != nil {
+			io.WriteString(j.stdout, header)
+		}
+		select {
+		case <-j.ctx.Done():
+			return output, err
+		case <-time.After(j.retryDelay):
+		}
+	}
+}
+
+// executeAttempt runs a copy of the task command recording its stdout and
+// stderr separately while forwarding them to the live streams. The process
+// group of attempts exceeding the timeout or cancelled is killed.
+func (j *taskJob) executeAttempt() (TaskOutput, error) {
+	ctx := j.ctx
+	if j.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(j.ctx, j.timeout)
+		defer cancel()
+	}
+	cmd := commandContext(ctx, j.runner)
+	recorder := newOutputRecorder()
+	cmd.Stdout = recorder.writer(StreamStdout, j.stdout)
+	cmd.Stderr = recorder.writer(StreamStderr, j.stderr)
+	// don't wait for orphans holding the output pipes once killed
+	cmd.WaitDelay = time.Second
+	err := runInProcessGroup(cmd, 0)
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	} else if err != nil {
+		exitCode = -1
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && j.ctx.Err() == nil {
+		err = fmt.Errorf("%w after %v", ErrTaskTimeout, j.timeout)
+		exitCode = -1
+	}
+	return recorder.done(exitCode), err
+}
+
+// commandContext returns a copy of cmd that is killed when ctx is done
+func commandContext(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	res := exec.CommandContext(ctx, cmd.Path)
+	res.Args = cmd.Args
+	res.Dir = cmd.Dir
+	res.Env = cmd.Env
+	res.Err = cmd.Err
+	return res
+}
@@ -0,0 +1,274 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/software-t-rex/monospace/app"
+)
+
+func TestOutputRecorder_SeparatesStreams(t *testing.T) {
+	var live bytes.Buffer
+	recorder := newOutputRecorder()
+	recorder.writer(StreamStdout, &live).Write([]byte("out\n"))
+	recorder.writer(StreamStderr, nil).Write([]byte("err\n"))
+	output := recorder.done(0)
+	if len(output.Chunks) != 2 || output.Chunks[0].Stream != StreamStdout || output.Chunks[1].Stream != StreamStderr {
+		t.Fatalf("unexpected chunks %+v", output.Chunks)
+	}
+	if output.Chunks[1].Offset < output.Chunks[0].Offset {
+		t.Error("chunks offsets should be increasing")
+	}
+	if live.String() != "out\n" {
+		t.Errorf("only stdout should be forwarded, got %q", live.String())
+	}
+	if output.Combined() != "out\nerr\n" {
+		t.Errorf("unexpected combined output %q", output.Combined())
+	}
+}
+
+func TestSaveOutput_StreamsRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, root)
+	hash, _ := ComputeHash(opts, baseTaskDef([]string{"go", "build"}))
+	want := TaskOutput{Duration: 3 * time.Second, Chunks: []OutputChunk{
+		{Stream: StreamStdout, Data: "building\n"},
+		{Stream: StreamStderr, Offset: time.Second, Data: "warning\n"},
+	}}
+	if err := SaveOutput(opts, hash, want); err != nil {
+		t.Fatalf("SaveOutput: %v", err)
+	}
+	result, _ := Check(opts, hash)
+	got, err := readCachedStreams(result.CacheDir)
+	if err != nil {
+		t.Fatalf("readCachedStreams: %v", err)
+	}
+	if got.Duration != want.Duration || len(got.Chunks) != 2 || got.Chunks[1] != want.Chunks[1] {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	// combined output stays readable by older entries readers
+	if combined, _ := readCachedOutput(result.CacheDir); combined != "building\nwarning\n" {
+		t.Errorf("unexpected combined output %q", combined)
+	}
+	var stdout, stderr bytes.Buffer
+	got.Replay(&stdout, &stderr)
+	if stdout.String() != "building\n" || stderr.String() != "warning\n" {
+		t.Errorf("replayed stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestReadCachedStreams_FallsBackToCombinedOutput(t *testing.T) {
+	root := t.TempDir()
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, root)
+	hash, _ := ComputeHash(opts, baseTaskDef([]string{"go", "build"}))
+	Save(opts, hash, "legacy output")
+	result, _ := Check(opts, hash)
+	// entries saved before streams were recorded
+	if err := os.Remove(filepath.Join(result.CacheDir, outputStreamsName)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readCachedStreams(result.CacheDir)
+	if err != nil {
+		t.Fatalf("readCachedStreams: %v", err)
+	}
+	if len(got.Chunks) != 1 || got.Chunks[0].Stream != StreamStdout || got.Chunks[0].Data != "legacy output" {
+		t.Errorf("unexpected chunks %+v", got.Chunks)
+	}
+}
+
+// newTestTaskJobs returns a function creating task jobs for a lib#build
+// task running the given sh script, each job recording its live streams.
+func newTestTaskJobs(t *testing.T, script string, cacheFailures bool) (*taskJobs, func() (*taskJob, *bytes.Buffer, *bytes.Buffer)) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	root := makeTestProject(t, map[string]string{
+		".monospace/monospace.yml": "projects: {}",
+		"lib/a.go":                 "a",
+	})
+	useTestMonospace(t, root)
+	cmd := []string{"sh", "-c", script}
+	taskList := TaskList{List: map[string]*Task{
+		"lib#build": NewTask("lib#build", app.MonospaceConfigTask{Cmd: cmd, Cache: "skip", CacheFailures: cacheFailures}),
+	}, config: &app.MonospaceConfig{Projects: map[string]string{"lib": "internal"}}}
+	task := taskList.List["lib#build"]
+	jobs := newTaskJobs()
+	return jobs, func() (*taskJob, *bytes.Buffer, *bytes.Buffer) {
+		var stdout, stderr bytes.Buffer
+		tj := newTaskJob(context.Background(), exec.Command(cmd[0], cmd[1:]...), task, RunOptions{}, newTaskHasher(taskList, root, nil))
+		jobs.add(0, tj)
+		tj.stdout, tj.stderr = &stdout, &stderr
+		return tj, &stdout, &stderr
+	}
+}
+
+func TestTaskJob_ReplaysStreamsOnHit(t *testing.T) {
+	jobs, newJob := newTestTaskJobs(t, "echo out; echo err >&2", false)
+
+	miss, stdout, stderr := newJob()
+	if _, err := miss.run(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Fatalf("miss: stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+	if jobs.Saved() != 0 {
+		t.Error("a miss should not save time")
+	}
+
+	hit, stdout, stderr := newJob()
+	res, err := hit.run()
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !strings.HasPrefix(stdout.String(), "[cache hit: ") || !strings.HasSuffix(stdout.String(), "]\nout\n") {
+		t.Errorf("hit: unexpected stdout %q", stdout.String())
+	}
+	if stderr.String() != "err\n" {
+		t.Errorf("hit: unexpected stderr %q", stderr.String())
+	}
+	// streams go through distinct pipes so their relative order is not guaranteed
+	if !strings.Contains(res, "out\n") || !strings.Contains(res, "err\n") {
+		t.Errorf("hit: unexpected result %q", res)
+	}
+	if jobs.Saved() <= 0 {
+		t.Error("a hit should add the original run duration to the saved time")
+	}
+}
+
+func TestTaskJob_FailuresNotCachedByDefault(t *testing.T) {
+	_, newJob := newTestTaskJobs(t, "echo ran; exit 3", false)
+	for i := 0; i < 2; i++ {
+		tj, stdout, _ := newJob()
+		if _, err := tj.run(); err == nil || errors.Is(err, ErrCachedFailure) {
+			t.Fatalf("run %d: expected the task to fail for real, got %v", i, err)
+		}
+		if stdout.String() != "ran\n" {
+			t.Errorf("run %d: the task should have been run, got %q", i, stdout.String())
+		}
+	}
+}
+
+func TestTaskJob_ReplaysCachedFailure(t *testing.T) {
+	_, newJob := newTestTaskJobs(t, "echo bad >&2; exit 3", true)
+	miss, _, _ := newJob()
+	if _, err := miss.run(); err == nil || errors.Is(err, ErrCachedFailure) {
+		t.Fatalf("first run: expected the task to fail for real, got %v", err)
+	}
+
+	hit, stdout, stderr := newJob()
+	_, err := hit.run()
+	if !errors.Is(err, ErrCachedFailure) || !strings.Contains(err.Error(), "exit status 3") {
+		t.Fatalf("expected a cached failure with exit status 3, got %v", err)
+	}
+	if !strings.HasPrefix(stdout.String(), "[cached failure: ") {
+		t.Errorf("missing cached failure marker in %q", stdout.String())
+	}
+	if stderr.String() != "bad\n" {
+		t.Errorf("unexpected replayed stderr %q", stderr.String())
+	}
+}
+
+func TestCheck_IgnoresFailuresUnlessCached(t *testing.T) {
+	root := t.TempDir()
+	dir := makeTestProject(t, map[string]string{"a.go": "a"})
+	opts := baseOpts(dir, root)
+	hash, _ := ComputeHash(opts, baseTaskDef([]string{"go", "build"}))
+	SaveOutput(opts, hash, TaskOutput{ExitCode: 1})
+	if result, _ := Check(opts, hash); result.Hit {
+		t.Error("failed entries should be a miss for tasks not caching failures")
+	}
+	opts.CacheFailures = true
+	if result, _ := Check(opts, hash); !result.Hit {
+		t.Error("failed entries should be a hit for tasks caching failures")
+	}
+}
+
+// newTestRetriedJob returns a task job running script in a temporary directory
+func newTestRetriedJob(t *testing.T, script string, taskDef app.MonospaceConfigTask) *taskJob {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	runner := exec.Command("sh", "-c", script)
+	runner.Dir = t.TempDir()
+	tj := newTaskJob(context.Background(), runner, NewTask("lib#test", taskDef), RunOptions{}, nil)
+	if tj == nil {
+		t.Fatal("tasks with retries or timeout should be run as task jobs")
+	}
+	return tj
+}
+
+func TestTaskJob_Retries(t *testing.T) {
+	script := "n=$(cat count 2>/dev/null || echo 0); n=$((n+1)); echo $n > count; echo attempt $n; [ $n -ge 3 ]"
+	tj := newTestRetriedJob(t, script, app.MonospaceConfigTask{Retries: 2, RetryDelay: "10ms"})
+	var stdout bytes.Buffer
+	tj.stdout = &stdout
+	res, err := tj.run()
+	if err != nil {
+		t.Fatalf("third attempt should succeed, got %v", err)
+	}
+	if tj.attempts != 3 || tj.exitCode != 0 {
+		t.Errorf("expected 3 attempts and exit code 0, got %d, %d", tj.attempts, tj.exitCode)
+	}
+	for _, want := range []string{"attempt 1\n[attempt 1/3 failed: exit status 1, retrying]\n", "attempt 3\n"} {
+		if !strings.Contains(res, want) || !strings.Contains(stdout.String(), want) {
+			t.Errorf("missing %q in output %q and live output %q", want, res, stdout.String())
+		}
+	}
+
+	tj = newTestRetriedJob(t, "exit 2", app.MonospaceConfigTask{Retries: 1})
+	if _, err := tj.run(); err == nil || tj.attempts != 2 || tj.exitCode != 2 {
+		t.Errorf("expected failure after 2 attempts, got %v after %d attempts", err, tj.attempts)
+	}
+}
+
+func TestTaskJob_Timeout(t *testing.T) {
+	tj := newTestRetriedJob(t, "echo started; sleep 10 & wait", app.MonospaceConfigTask{Timeout: "200ms"})
+	start := time.Now()
+	res, err := tj.run()
+	if !errors.Is(err, ErrTaskTimeout) || tj.exitCode != -1 {
+		t.Errorf("expected a timeout error, got %v, exit code %d", err, tj.exitCode)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timed out task should be killed, took %v", elapsed)
+	}
+	if res != "started\n" {
+		t.Errorf("output before timeout should be kept, got %q", res)
+	}
+	if newTaskJob(context.Background(), exec.Command("true"), NewTask("lib#test", app.MonospaceConfigTask{}), RunOptions{}, nil) != nil {
+		t.Error("tasks without cache, retries or timeout should run as plain commands")
+	}
+}
+
+func TestTaskJob_CancelKillsProcessGroup(t *testing.T) {
+	tj := newTestRetriedJob(t, "echo started; sleep 10 & wait", app.MonospaceConfigTask{Retries: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	tj.reset(ctx)
+	start := time.Now()
+	if _, err := tj.run(); err == nil || tj.attempts != 1 {
+		t.Errorf("cancelled task should fail without retry, got %v after %d attempts", err, tj.attempts)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("children of a cancelled task should be killed, took %v", elapsed)
+	}
+}
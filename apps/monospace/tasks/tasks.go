@@ -8,7 +8,6 @@ SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
 package tasks
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"net/url"
@@ -16,6 +15,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/software-t-rex/go-jobExecutor/v2"
@@ -33,6 +33,7 @@ var exit func(msg string)
 var (
 	ErrInvalidProjectName = errors.New("invalid project name")
 	ErrNoAvailableOption  = errors.New("no available option")
+	ErrUnknownTask        = errors.New("unknown task")
 )
 
 func init() {
@@ -51,8 +52,10 @@ func (t TaskName) String() string {
 }
 
 type Task struct {
-	Name    TaskName
-	TaskDef app.MonospaceConfigTask
+	Name     TaskName
+	TaskDef  app.MonospaceConfigTask
+	Params   map[string]string // parameters values, set by TaskList.SetParams
+	Targeted bool              // named on the command line, not only added as a dependency
 }
 
 var taskNameRegex = regexp.MustCompile("^(?:([^#]+)#)?([^#]+)$")
@@ -96,18 +99,35 @@ type RunOptions struct {
 	AdditionalArgs []string
 	OutputMode     string
 	NoCache        bool
+	Reporter       *Reporter // writes a report file when set
+	Concurrency    int       // max concurrent jobs, 0 = default
+	FailurePolicy  string    // FailurePolicyContinue | FailurePolicyBail
+	Command        string    // command line recorded in run logs
+	// only pass AdditionalArgs to the tasks named on the command line, not to
+	// the tasks added as their dependencies
+	TargetsOnlyArgs bool
+}
+
+// taskArgs returns the additional args to pass to the task
+func (o RunOptions) taskArgs(task *Task) []string {
+	if o.TargetsOnlyArgs && !task.Targeted {
+		return nil
+	}
+	return o.AdditionalArgs
 }
 
 type Pipeline map[string]Task
 type TaskList struct {
-	List     map[string]*Task
-	Pipeline Pipeline
-	config   *app.MonospaceConfig
+	List         map[string]*Task
+	Pipeline     Pipeline
+	config       *app.MonospaceConfig
+	projectsDeps map[string][]string // only set when pipeline has upstream dependencies
 }
 
 // returns a clean pipeline
 // Will return an error if pipeline contains invalid tasks or dependencies references,
-// or if tasks depends on persistent task
+// or if tasks depends on persistent task without ready condition, or if a
+// persistent task is exclusive, or if tasks have invalid run settings
 func GetStandardizedPipeline(config *app.MonospaceConfig, failEmpty bool) (Pipeline, error) {
 	if config.Pipeline == nil || len(config.Pipeline) == 0 {
 		if failEmpty {
@@ -124,8 +144,10 @@ func GetStandardizedPipeline(config *app.MonospaceConfig, failEmpty bool) (Pipel
 		if len(taskDef.DependsOn) > 0 {
 			taskDef.DependsOn = append([]string{}, v.DependsOn...)
 			for i, depName := range taskDef.DependsOn {
-				// todo handle ^ prefix (or not)
-				if !strings.Contains(depName, "#") {
+				if strings.HasPrefix(depName, "^") {
+					// upstream dependencies are resolved against projects dependencies when building a task list
+					continue
+				} else if !strings.Contains(depName, "#") {
 					taskDef.DependsOn[i] = taskName.Project + "#" + depName
 				} else {
 					depName := ParseTaskName(depName, config)
@@ -133,16 +155,30 @@ func GetStandardizedPipeline(config *app.MonospaceConfig, failEmpty bool) (Pipel
 				}
 			}
 		}
-		res[taskName.String()] = Task{taskName, taskDef}
+		res[taskName.String()] = Task{Name: taskName, TaskDef: taskDef}
 	}
-	// check dependencies are valid (tasks exists and are not persistent tasks)
+	// check dependencies are valid (tasks exists and are not persistent tasks
+	// without ready condition)
 	for _, task := range res {
+		if task.TaskDef.Exclusive && task.Persists() {
+			// would prevent any other task to run until manually stopped
+			return Pipeline{}, fmt.Errorf("persistent task %s can't be exclusive", task.String())
+		}
+		if err := checkTaskRunSettings(task.TaskDef); err != nil {
+			return Pipeline{}, fmt.Errorf("%s: %w", task.String(), err)
+		}
 		for _, depName := range task.TaskDef.DependsOn {
+			if upstreamTask, isUpstream := strings.CutPrefix(depName, "^"); isUpstream {
+				if err := res.checkUpstreamDependency(upstreamTask); err != nil {
+					return Pipeline{}, fmt.Errorf("%s depends on %w", task.String(), err)
+				}
+				continue
+			}
 			dep, ok := res[depName]
 			if !ok {
 				return Pipeline{}, fmt.Errorf("%s depends on unknown task %s", task.String(), depName)
-			} else if dep.Persists() {
-				return Pipeline{}, fmt.Errorf("%s can't depend on persistent task %s", task.String(), depName)
+			} else if !dep.IsDependable() {
+				return Pipeline{}, fmt.Errorf("%s can't depend on persistent task %s without ready condition", task.String(), depName)
 			}
 		}
 	}
@@ -174,6 +210,36 @@ func getStandardProjectName(name string, config *app.MonospaceConfig) (string, e
 
 //######################### Pipeline methods #########################//
 
+// check that at least one task named taskName exists in the pipeline and that
+// all of them are dependable
+func (p Pipeline) checkUpstreamDependency(taskName string) error {
+	found := false
+	for _, task := range p {
+		if task.Name.Task != taskName {
+			continue
+		} else if !task.IsDependable() {
+			return fmt.Errorf("persistent task %s without ready condition", task.String())
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("unknown task ^%s", taskName)
+	}
+	return nil
+}
+
+// check if some tasks in the pipeline depends on upstream projects tasks (^ prefixed dependencies)
+func (p Pipeline) HasUpstreamDependencies() bool {
+	for _, task := range p {
+		for _, depName := range task.TaskDef.DependsOn {
+			if strings.HasPrefix(depName, "^") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (p Pipeline) TaskLookup(taskName, project string, config *app.MonospaceConfig) *Task {
 	stdProjectName, err := getStandardProjectName(project, config)
 	if err != nil {
@@ -188,8 +254,17 @@ func (p Pipeline) TaskLookup(taskName, project string, config *app.MonospaceConf
 	return nil
 }
 
+// Will exit if pipeline has upstream dependencies and projects dependencies can't be resolved
 func (p Pipeline) NewTaskList(config *app.MonospaceConfig) TaskList {
-	return TaskList{List: make(map[string]*Task), Pipeline: p, config: config}
+	taskList := TaskList{List: make(map[string]*Task), Pipeline: p, config: config}
+	if p.HasUpstreamDependencies() {
+		projectsDeps, err := mono.ProjectsGetDependenciesFromConfig(config)
+		if err != nil {
+			exit(err.Error())
+		}
+		taskList.projectsDeps = projectsDeps
+	}
+	return taskList
 }
 
 func (p Pipeline) IsAcyclic(exitOnError bool) bool {
@@ -197,7 +272,9 @@ func (p Pipeline) IsAcyclic(exitOnError bool) bool {
 	dependentCount := make(map[string]int, length)
 	for _, task := range p {
 		for _, to := range task.TaskDef.DependsOn {
-			dependentCount[to]++
+			if !strings.HasPrefix(to, "^") { // upstream dependencies are checked at execution time
+				dependentCount[to]++
+			}
 		}
 	}
 
@@ -213,6 +290,9 @@ func (p Pipeline) IsAcyclic(exitOnError bool) bool {
 		queue = queue[1:]
 		resolved++
 		for _, to := range p[at].TaskDef.DependsOn {
+			if strings.HasPrefix(to, "^") {
+				continue
+			}
 			dependentCount[to]--
 			if dependentCount[to] == 0 {
 				queue = append(queue, to)
@@ -235,11 +315,18 @@ func (p Pipeline) RemoveTask(name string, config *app.MonospaceConfig) Pipeline
 		if k == taskName {
 			continue
 		}
+		res[k] = v
+	}
+	for k, v := range res {
 		task := v.TaskDef
 		task.DependsOn = utils.SliceFilter(task.DependsOn, func(s string) bool {
+			if upstreamTask, isUpstream := strings.CutPrefix(s, "^"); isUpstream {
+				// only keep upstream dependencies that still match a task
+				return res.checkUpstreamDependency(upstreamTask) == nil
+			}
 			return StandardizedTaskName(s, config) != taskName // @todo check we need to parse task name as it should be standardized
 		})
-		res[k] = Task{v.Name, task}
+		res[k] = Task{Name: v.Name, TaskDef: task}
 	}
 	return res
 }
@@ -266,6 +353,9 @@ func (p Pipeline) ToConfig(config *app.MonospaceConfig) map[string]app.Monospace
 			copy(taskDef.DependsOn, v.TaskDef.DependsOn)
 			// replace project names in dependencies with alias if possible
 			for i, dep := range taskDef.DependsOn {
+				if strings.HasPrefix(dep, "^") {
+					continue
+				}
 				depName := ParseTaskName(dep, config) // @todo check we need to parse task name as it should be standardized
 				if depName.Project == "" {
 					depName.Project = taskName.Project
@@ -294,17 +384,44 @@ func (p Pipeline) GetDependableTasks(excludedTasks []string, config *app.Monospa
 			return StandardizedTaskName(task, config)
 		})
 	}
-	// get all tasks that are not persistent and not excluded
+	// get all dependable tasks that are not excluded
 	filteredPipeline := utils.MapFilter(p, func(task Task) bool {
 		if utils.SliceContains(excludedTasks, task.Name.String()) {
 			return false
 		}
-		return !task.TaskDef.Persistent
+		return task.IsDependable()
 	})
 	dependables := utils.MapGetKeys(filteredPipeline)
 	return dependables
 }
 
+// check retries, timeout, grace period and ready settings are valid
+func checkTaskRunSettings(taskDef app.MonospaceConfigTask) error {
+	if _, err := taskDef.GetRetries(); err != nil {
+		return err
+	}
+	if _, err := taskDef.GetRetryDelay(); err != nil {
+		return err
+	}
+	if _, err := taskDef.GetTimeout(); err != nil {
+		return err
+	}
+	if _, err := taskDef.GetGracePeriod(); err != nil {
+		return err
+	}
+	for _, name := range sortedParamNames(taskDef.Params) {
+		if err := taskDef.Params[name].Validate(name); err != nil {
+			return err
+		}
+	}
+	if taskDef.Ready == nil {
+		return nil
+	} else if !taskDef.Persistent {
+		return fmt.Errorf("%w: ready condition is only allowed on persistent tasks", app.ErrInvalidTaskConfig)
+	}
+	return taskDef.Ready.Validate()
+}
+
 //######################### Task methods #########################//
 
 func NewTask(fullName string, taskDef app.MonospaceConfigTask) *Task {
@@ -317,6 +434,12 @@ func NewTask(fullName string, taskDef app.MonospaceConfigTask) *Task {
 func (t *Task) Persists() bool {
 	return t.TaskDef.Persistent
 }
+
+// other tasks can depend on non persistent tasks and on persistent tasks with
+// a ready condition
+func (t *Task) IsDependable() bool {
+	return !t.TaskDef.Persistent || t.TaskDef.Ready != nil
+}
 func (t *Task) DependsOn(taskName TaskName) bool {
 	for _, dep := range t.TaskDef.DependsOn {
 		if dep == taskName.String() {
@@ -329,8 +452,32 @@ func (t *Task) String() string {
 	return t.Name.String()
 }
 
+// expand replaces ${name} and $name in s with the task parameters values or
+// with the environment variables values
+func (t *Task) expand(s string) string {
+	return os.Expand(s, func(name string) string {
+		if value, ok := t.Params[name]; ok {
+			return value
+		}
+		return os.Getenv(name)
+	})
+}
+
+// GetEnv returns the env of the task with parameters and environment variables
+// expanded in values
+func (t *Task) GetEnv() map[string]string {
+	if len(t.TaskDef.Env) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(t.TaskDef.Env))
+	for k, v := range t.TaskDef.Env {
+		res[k] = t.expand(v)
+	}
+	return res
+}
+
 func (t *Task) preparedCmd(cmdAndArgs ...string) *exec.Cmd {
-	args := utils.SliceMap(cmdAndArgs, os.ExpandEnv)
+	args := utils.SliceMap(cmdAndArgs, t.expand)
 	if _, err := exec.LookPath(args[0]); err != nil {
 		// lookup for command in .monospace/bin
 		binPath := filepath.Join(mono.SpaceGetRoot(), ".monospace", "bin", args[0])
@@ -339,9 +486,15 @@ func (t *Task) preparedCmd(cmdAndArgs ...string) *exec.Cmd {
 		}
 	}
 	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Path = os.ExpandEnv(cmd.Path)
-	cmd.Args = utils.SliceMap(cmd.Args, os.ExpandEnv)
+	cmd.Path = t.expand(cmd.Path)
+	cmd.Args = utils.SliceMap(cmd.Args, t.expand)
 	cmd.Dir = mono.ProjectGetPath(t.Name.Project)
+	if env := t.GetEnv(); env != nil {
+		cmd.Env = os.Environ()
+		for _, k := range sortedKeys(env) {
+			cmd.Env = append(cmd.Env, k+"="+env[k])
+		}
+	}
 	return cmd
 }
 func (t *Task) preparedJSPMRunCmd(pmCmd string, args []string) *exec.Cmd {
@@ -413,8 +566,53 @@ func (t *Task) GetJobRunner(additionalArgs []string, defaultJSPM string) *exec.C
 
 //######################### TaskList methods #########################//
 
+// SetParams sets the parameters values of the tasks of the list from the given
+// values, falling back to the parameters defaults. It returns an error for
+// missing required parameters, values not matching the parameters choices and
+// values of parameters no task of the list declares.
+func (t TaskList) SetParams(values map[string]string) error {
+	declared := map[string]bool{}
+	var errs []error
+	for _, name := range t.sortedNames() {
+		task := t.List[name]
+		task.Params = nil
+		for _, param := range sortedParamNames(task.TaskDef.Params) {
+			declared[param] = true
+			value, isSet := values[param]
+			value, err := task.TaskDef.Params[param].GetValue(param, value, isSet)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			if task.Params == nil {
+				task.Params = map[string]string{}
+			}
+			task.Params[param] = value
+		}
+	}
+	for _, param := range sortedKeys(values) {
+		if !declared[param] {
+			errs = append(errs, fmt.Errorf("%w: no task to run has a %s param", app.ErrInvalidTaskParam, param))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func sortedParamNames(params map[string]app.MonospaceConfigTaskParam) []string {
+	names := utils.MapGetKeys(params)
+	sort.Strings(names)
+	return names
+}
+
+func (t TaskList) sortedNames() []string {
+	names := utils.MapGetKeys(t.List)
+	sort.Strings(names)
+	return names
+}
+
 // add a task and resolve its dependencies
 func (t TaskList) AddTask(task *Task, resolveDeps bool) TaskList {
+	task.TaskDef.DependsOn = t.expandUpstreamDeps(task)
 	t.List[task.Name.String()] = task
 	if resolveDeps {
 		t.ResolveDeps(task)
@@ -433,23 +631,88 @@ func (t TaskList) ResolveDeps(task *Task) {
 		depTaskName := ParseTaskName(depName, t.config)
 		depTask := t.Pipeline.TaskLookup(depTaskName.Task, depTaskName.Project, t.config)
 		if depTask != nil {
+			depTask.TaskDef.DependsOn = t.expandUpstreamDeps(depTask)
 			t.List[depName] = depTask
 			t.ResolveDeps(depTask)
 		}
 	}
 }
+
+// return task dependencies with ^ prefixed dependencies replaced by the
+// matching tasks of the projects the task's project depends on
+func (t TaskList) expandUpstreamDeps(task *Task) []string {
+	var res []string
+	for _, depName := range task.TaskDef.DependsOn {
+		upstreamTask, isUpstream := strings.CutPrefix(depName, "^")
+		if !isUpstream {
+			if !utils.SliceContains(res, depName) {
+				res = append(res, depName)
+			}
+			continue
+		}
+		for _, upstreamProject := range t.projectsDeps[task.Name.Project] {
+			if t.Pipeline.TaskLookup(upstreamTask, upstreamProject, t.config) == nil {
+				continue
+			}
+			upstreamName := upstreamProject + "#" + upstreamTask
+			if !utils.SliceContains(res, upstreamName) {
+				res = append(res, upstreamName)
+			}
+		}
+	}
+	return res
+}
+
 func (t TaskList) Len() int {
 	return len(t.List)
 }
 
+// check if one of the task dependencies (recursively) belongs to one of the affected projects
+func (t TaskList) dependsOnAffected(task *Task, affected []string, visited map[string]bool) bool {
+	for _, depName := range t.expandUpstreamDeps(task) {
+		if visited[depName] {
+			continue
+		}
+		visited[depName] = true
+		depTaskName := ParseTaskName(depName, t.config)
+		if utils.SliceContains(affected, depTaskName.Project) {
+			return true
+		}
+		depTask := t.Pipeline.TaskLookup(depTaskName.Task, depTaskName.Project, t.config)
+		if depTask != nil && t.dependsOnAffected(depTask, affected, visited) {
+			return true
+		}
+	}
+	return false
+}
+
 func (t TaskList) GetExecutor(opts RunOptions) *jobExecutor.JobExecutor {
-	e := NewExecutor(opts.OutputMode)
+	e, _ := t.getExecutor(opts)
+	return e
+}
+
+// getExecutor is the same as GetExecutor but also returns the task jobs
+func (t TaskList) getExecutor(opts RunOptions) (*jobExecutor.JobExecutor, *taskJobs) {
+	taskJobs := newTaskJobs()
+	exclusive := newExclusiveJobs()
+	failures := newFailureHandler(opts.FailurePolicy)
+	e := newExecutor(opts.OutputMode, taskJobs, failures)
+	runLog := newRunLogger(mono.SpaceGetRoot(), t.config.GetLogsMaxRuns(), opts.Command)
 	projectAliases := t.config.GetProjectsAliases()
 	taskIds := make(map[string]int, t.Len())
+	var remoteCache *RemoteCache
+	if !opts.NoCache {
+		var err error
+		remoteCache, err = NewRemoteCache(t.config.RemoteCache, mono.SpaceGetRoot())
+		if err != nil {
+			utils.PrintWarning(err.Error() + " => using local cache only")
+		}
+	}
+	hasher := newTaskHasher(t, mono.SpaceGetRoot(), remoteCache)
 
 	jobs := make(map[int]jobExecutor.Job, t.Len())
 	for taskId, task := range t.List {
-		taskRunner := task.GetJobRunner(opts.AdditionalArgs, t.config.JSPM)
+		taskRunner := task.GetJobRunner(opts.taskArgs(task), t.config.JSPM)
 		taskName := task.Name.String()
 		if opts.OutputMode == "interleaved" {
 			// replace task name with alias if any when using interleaved output
@@ -459,20 +722,31 @@ func (t TaskList) GetExecutor(opts RunOptions) *jobExecutor.JobExecutor {
 			}
 		}
 		if taskRunner != nil {
-			maxEntries := task.TaskDef.CacheMaxEntries
-			if maxEntries == 0 {
-				maxEntries = t.config.CacheMaxEntries
-			}
-			if maxEntries == 0 {
-				maxEntries = app.DefaultCacheMaxEntries
+			var jobImpl interface{} = taskRunner
+			tj := newTaskJob(failures.context(), taskRunner, task, opts, hasher)
+			if tj != nil {
+				jobImpl = tj.run
 			}
-			jobImpl := wrapWithCache(taskRunner, task, opts, mono.SpaceGetRoot(), maxEntries)
 			job := e.AddJob(jobExecutor.NamedJob{Name: taskName, Job: jobImpl})
+			if tj != nil {
+				taskJobs.add(job.Id(), tj)
+			}
+			if task.TaskDef.Exclusive {
+				exclusive.add(job.Id())
+			}
+			if opts.Reporter != nil {
+				opts.Reporter.AddJob(job.Id(), task.Name.String(), task.Name.Project, task.Name.Task)
+			}
+			runLog.addJob(job.Id(), task.Name)
 			taskIds[taskId] = job.Id()
 			jobs[job.Id()] = job
 		} else if task.TaskDef.DependsOn != nil && len(task.TaskDef.DependsOn) > 0 {
 			fmt.Printf(ui.GetTheme().Info("%s#%s is a dummy task, will only executes its dependencies.\n"), task.Name.Project, task.Name.Task)
 			job := e.AddJob(jobExecutor.NamedJob{Name: taskName, Job: func() (string, error) { return "", nil }})
+			if opts.Reporter != nil {
+				opts.Reporter.AddJob(job.Id(), task.Name.String(), task.Name.Project, task.Name.Task)
+			}
+			runLog.addJob(job.Id(), task.Name)
 			taskIds[taskId] = job.Id()
 			jobs[job.Id()] = job
 		} else {
@@ -480,6 +754,15 @@ func (t TaskList) GetExecutor(opts RunOptions) *jobExecutor.JobExecutor {
 			exit(taskName + " task has no cmd, no package.json script or dependencies, provide at least one of those or remove the task from pipeline.")
 		}
 	}
+	exclusive.attach(e)
+	// files hashes are persisted once per run
+	e.OnJobsDone(func(jobExecutor.JobList) { hasher.saveFileHashes() })
+	if opts.Reporter != nil {
+		opts.Reporter.taskJobs = taskJobs
+		opts.Reporter.failures = failures
+		opts.Reporter.Attach(e)
+	}
+	runLog.attach(e, taskJobs, failures)
 	// add dependencies
 	for taskId, task := range t.List {
 		for _, depTask := range task.TaskDef.DependsOn {
@@ -489,7 +772,7 @@ func (t TaskList) GetExecutor(opts RunOptions) *jobExecutor.JobExecutor {
 			e.AddJobDependency(jobs[taskIds[taskId]], jobs[taskIds[depTask]])
 		}
 	}
-	return e
+	return e, taskJobs
 }
 
 // This function will prepare a task list from a list of task names and a list of projects to search tasks for
@@ -513,6 +796,32 @@ func PrepareTaskList(tasks []string, projects []mono.Project, config *app.Monosp
 		for _, taskName := range tasks {
 			task := pipeline.TaskLookup(taskName, project, config)
 			if task != nil {
+				task.Targeted = true
+				taskList.AddTask(task, true)
+			}
+		}
+	}
+	return taskList
+}
+
+// Same as PrepareTaskList but only keep tasks that belong to one of the affected
+// projects or that depends (directly or not) on a task of an affected project.
+// It will exit on failure
+func PrepareAffectedTaskList(tasks []string, projects []mono.Project, affected []string, config *app.MonospaceConfig) TaskList {
+	pipeline, err := GetStandardizedPipeline(config, true)
+	if err != nil {
+		exit(err.Error())
+	}
+
+	taskList := pipeline.NewTaskList(config)
+	for _, project := range projects {
+		for _, taskName := range tasks {
+			task := pipeline.TaskLookup(taskName, project.Name, config)
+			if task == nil {
+				continue
+			}
+			if utils.SliceContains(affected, project.Name) || taskList.dependsOnAffected(task, affected, map[string]bool{}) {
+				task.Targeted = true
 				taskList.AddTask(task, true)
 			}
 		}
@@ -530,85 +839,34 @@ func Run(taskList TaskList, opts RunOptions) {
 	if taskList.Len() == 0 {
 		exit("no tasks found")
 	}
-	executor := taskList.GetExecutor(opts)
-	err := executor.DagExecute()
-	if err.Len() > 0 {
-		if errors.Is(err[0], jobExecutor.ErrCyclicDependencyDetected) {
-			exit(err[0].Error())
-		}
+	if !runTaskList(taskList, opts) {
 		os.Exit(1)
 	}
 }
 
-// wrapWithCache wraps a task runner with cache logic when the task has caching
-// enabled. Returns the original *exec.Cmd unchanged when cache is disabled or
-// --no-cache is set. Otherwise returns a func() (string, error) closure that
-// checks the cache before running and saves the result on a miss.
-func wrapWithCache(taskRunner *exec.Cmd, task *Task, opts RunOptions, monospaceRoot string, maxEntries int) interface{} {
-	if opts.NoCache || (task.TaskDef.Cache != "skip" && task.TaskDef.Cache != "restore") {
-		return taskRunner
-	}
-	strategy := task.TaskDef.CacheStrategy
-	if strategy == "" {
-		strategy = app.CacheStrategyContent
-	}
-	cacheOpts := CacheOptions{
-		ProjectName:   task.Name.Project,
-		TaskName:      task.Name.Task,
-		ProjectPath:   mono.ProjectGetPath(task.Name.Project),
-		Mode:          task.TaskDef.Cache,
-		Strategy:      strategy,
-		Inputs:        task.TaskDef.Inputs,
-		Outputs:       task.TaskDef.Outputs,
-		MonospaceRoot: monospaceRoot,
-		MaxEntries:    maxEntries,
-	}
-	taskDef := task.TaskDef
-	return func() (string, error) {
-		hash, err := ComputeHash(cacheOpts, taskDef)
-		if err != nil {
-			// hash failure is non-fatal: run the task normally
-			return runCmdCaptured(taskRunner)
-		}
-		result, checkErr := Check(cacheOpts, hash)
-		if checkErr != nil {
-			fmt.Fprintf(os.Stderr, "warning: cache check failed: %v\n", checkErr)
-		}
-		if result.Hit {
-			if task.TaskDef.Cache == "restore" {
-				if err := Restore(cacheOpts, result); err != nil {
-					fmt.Fprintf(os.Stderr, "warning: cache restore failed: %v, re-running task\n", err)
-					// fall through to cache miss
-				} else {
-					cachedOutput, _ := readCachedOutput(result.CacheDir)
-					return fmt.Sprintf("[cache hit: %s, restored]\n", hash[:8]) + cachedOutput, nil
-				}
-			} else {
-				cachedOutput, _ := readCachedOutput(result.CacheDir)
-				return fmt.Sprintf("[cache hit: %s]\n", hash[:8]) + cachedOutput, nil
-			}
+// runTaskList executes the task list, evicts the cache if entries were added to
+// it, then waits for ready persistent tasks to exit. It returns false if a task
+// failed and exits on cyclic dependencies. A non zero opts.Concurrency limit is
+// only applied during the execution.
+func runTaskList(taskList TaskList, opts RunOptions) bool {
+	restoreConcurrency := func() {}
+	if opts.Concurrency > 0 {
+		restoreConcurrency = SetMaxConcurrentJobs(opts.Concurrency)
+	}
+	executor, taskJobs := taskList.getExecutor(opts)
+	err := executor.DagExecute()
+	restoreConcurrency()
+	if !opts.NoCache && taskJobs.Written() {
+		if _, evictErr := EvictCacheFromConfig(mono.SpaceGetRoot(), taskList.config); evictErr != nil {
+			utils.PrintWarning(fmt.Sprintf("cache eviction failed: %s", evictErr))
 		}
-		// cache miss: run, capture output, and save to cache
-		out, runErr := runCmdCaptured(taskRunner)
-		if runErr == nil {
-			if err := Save(cacheOpts, hash, out); err != nil {
-				fmt.Fprintf(os.Stderr, "warning: cache save failed: %v\n", err)
-			}
+	}
+	for _, jobErr := range err {
+		if errors.Is(jobErr, jobExecutor.ErrCyclicDependencyDetected) {
+			exit(jobErr.Error())
 		}
-		return out, runErr
 	}
-}
-
-// runCmdCaptured executes cmd and returns its combined stdout+stderr output.
-// The cmd must not have Stdout/Stderr pre-assigned: it is always called from
-// within a cache closure where the *exec.Cmd is captured before the executor
-// can redirect it (the executor only redirects job.Cmd, not closures).
-func runCmdCaptured(cmd *exec.Cmd) (string, error) {
-	var buf bytes.Buffer
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
-	err := cmd.Run()
-	return buf.String(), err
+	return taskJobs.waitPersistent() && err.Len() == 0
 }
 
 func OpenGraphvizFull(config *app.MonospaceConfig) {
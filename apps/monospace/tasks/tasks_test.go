@@ -1,11 +1,15 @@
 package tasks
 
 import (
+	"errors"
 	"reflect"
 	"slices"
+	"strings"
 	"testing"
 
 	"github.com/software-t-rex/monospace/app"
+	"github.com/software-t-rex/monospace/gomodules/utils"
+	"github.com/software-t-rex/monospace/mono"
 )
 
 var testConfig = &app.MonospaceConfig{
@@ -378,3 +382,172 @@ func TestTaskList_ResolveDeps(t *testing.T) {
 		})
 	}
 }
+
+func TestPrepareAffectedTaskList(t *testing.T) {
+	projects := []mono.Project{
+		{Name: "apps/internalapp", RepoUrl: "internal", Kind: mono.Internal},
+		{Name: "apps/localapp", RepoUrl: "local", Kind: mono.Local},
+	}
+	tests := []struct {
+		name     string
+		affected []string
+		want     []string
+	}{
+		{"should keep tasks of affected projects and their dependents", []string{"apps/localapp"}, []string{"apps/internalapp#task", "apps/localapp#task", "apps/localapp#test"}},
+		{"should keep dependencies of affected tasks", []string{"apps/internalapp"}, []string{"apps/internalapp#task", "apps/localapp#test"}},
+		{"should be empty without affected projects", []string{}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taskList := PrepareAffectedTaskList([]string{"task"}, projects, tt.affected, testConfig)
+			got := make([]string, 0, taskList.Len())
+			for name := range taskList.List {
+				got = append(got, name)
+			}
+			slices.Sort(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PrepareAffectedTaskList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func upstreamTestConfig(pipeline map[string]app.MonospaceConfigTask) *app.MonospaceConfig {
+	cfg := *testConfig
+	cfg.Pipeline = pipeline
+	return &cfg
+}
+
+func Test_getStandardizedPipeline_upstreamDependencies(t *testing.T) {
+	tests := []struct {
+		name     string
+		pipeline map[string]app.MonospaceConfigTask
+		wantErr  bool
+	}{
+		{"should keep upstream dependencies", map[string]app.MonospaceConfigTask{
+			"build": {DependsOn: []string{"^build", "task"}},
+			"task":  {},
+		}, false},
+		{"should fail on unknown upstream task", map[string]app.MonospaceConfigTask{
+			"build": {DependsOn: []string{"^unknown"}},
+		}, true},
+		{"should fail on persistent upstream task", map[string]app.MonospaceConfigTask{
+			"build": {DependsOn: []string{"^dev"}},
+			"dev":   {Persistent: true},
+		}, true},
+		{"should accept persistent upstream task with ready condition", map[string]app.MonospaceConfigTask{
+			"build": {DependsOn: []string{"^build", "task"}},
+			"task":  {Persistent: true, Ready: &app.MonospaceConfigTaskReady{Tcp: "3000"}},
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetStandardizedPipeline(upstreamTestConfig(tt.pipeline), true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetStandardizedPipeline() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got["*#build"].TaskDef.DependsOn, []string{"^build", "*#task"}) {
+				t.Errorf("GetStandardizedPipeline() dependencies = %v", got["*#build"].TaskDef.DependsOn)
+			}
+			if !tt.wantErr && !got.IsAcyclic(false) {
+				t.Errorf("upstream dependencies should not be considered as cyclic")
+			}
+		})
+	}
+}
+
+func TestTaskList_upstreamDependencies(t *testing.T) {
+	config := upstreamTestConfig(map[string]app.MonospaceConfigTask{
+		"build": {DependsOn: []string{"^build", "^lint"}},
+		"lint":  {},
+	})
+	pipeline, err := GetStandardizedPipeline(config, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskList := TaskList{
+		List:         make(map[string]*Task),
+		Pipeline:     pipeline,
+		config:       config,
+		projectsDeps: map[string][]string{"apps/localapp": {"apps/internalapp"}},
+	}
+	taskList.AddTask(pipeline.TaskLookup("build", "local", config), true)
+	got := utils.MapGetKeys(taskList.List)
+	slices.Sort(got)
+	want := []string{"apps/internalapp#build", "apps/internalapp#lint", "apps/localapp#build"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TaskList.AddTask() = %v, want %v", got, want)
+	}
+	wantDeps := []string{"apps/internalapp#build", "apps/internalapp#lint"}
+	if deps := taskList.List["apps/localapp#build"].TaskDef.DependsOn; !reflect.DeepEqual(deps, wantDeps) {
+		t.Errorf("TaskList.AddTask() dependencies = %v, want %v", deps, wantDeps)
+	}
+	if deps := taskList.List["apps/internalapp#build"].TaskDef.DependsOn; len(deps) != 0 {
+		t.Errorf("TaskList.AddTask() project without upstream should have no dependencies, got %v", deps)
+	}
+}
+
+func TestPipeline_RemoveTask_upstreamDependencies(t *testing.T) {
+	config := upstreamTestConfig(map[string]app.MonospaceConfigTask{
+		"build":      {DependsOn: []string{"^build", "^lint"}},
+		"lint":       {},
+		"local#lint": {},
+	})
+	pipeline, _ := GetStandardizedPipeline(config, true)
+	got := pipeline.RemoveTask("lint", config)
+	if deps := got["*#build"].TaskDef.DependsOn; !reflect.DeepEqual(deps, []string{"^build", "^lint"}) {
+		t.Errorf("should keep upstream dependency while a task with that name remains, got %v", deps)
+	}
+	got = got.RemoveTask("local#lint", config)
+	if deps := got["*#build"].TaskDef.DependsOn; !reflect.DeepEqual(deps, []string{"^build"}) {
+		t.Errorf("should remove upstream dependency without matching task, got %v", deps)
+	}
+}
+
+func TestTaskList_SetParams(t *testing.T) {
+	root := makeTestProject(t, map[string]string{".monospace/monospace.yml": "projects: {}", "web/a.go": "a"})
+	useTestMonospace(t, root)
+	params := map[string]app.MonospaceConfigTaskParam{
+		"env":    {Default: "dev", Choices: []string{"dev", "prod"}},
+		"region": {Default: "eu"},
+	}
+	taskList := TaskList{List: map[string]*Task{
+		"web#deploy": NewTask("web#deploy", app.MonospaceConfigTask{Cmd: []string{"echo", "deploy", "${env}"}, Env: map[string]string{"TARGET": "$env-${region}"}, Params: params}),
+		"web#build":  NewTask("web#build", app.MonospaceConfigTask{Cmd: []string{"echo", "build"}}),
+	}}
+	if err := taskList.SetParams(map[string]string{"env": "prod"}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	runner := taskList.List["web#deploy"].GetJobRunner(nil, "")
+	if strings.Join(runner.Args, " ") != "echo deploy prod" || !slices.Contains(runner.Env, "TARGET=prod-eu") {
+		t.Errorf("params should be replaced in cmd and env, got %v and TARGET=%s", runner.Args, taskList.List["web#deploy"].GetEnv()["TARGET"])
+	}
+	if build := taskList.List["web#build"]; build.Params != nil || build.GetJobRunner(nil, "").Env != nil {
+		t.Errorf("tasks without params should keep the default env, got %v", build.Params)
+	}
+	for _, values := range []map[string]string{{"env": "staging"}, {"env": "dev", "unknown": "value"}} {
+		if err := taskList.SetParams(values); !errors.Is(err, app.ErrInvalidTaskParam) {
+			t.Errorf("%v: expected ErrInvalidTaskParam, got %v", values, err)
+		}
+	}
+}
+
+func TestRunOptions_TargetsOnlyArgs(t *testing.T) {
+	projects := []mono.Project{{Name: "apps/internalapp", RepoUrl: "internal", Kind: mono.Internal}}
+	taskList := PrepareTaskList([]string{"build"}, projects, testConfig)
+	build, dep := taskList.List["apps/internalapp#build"], taskList.List["apps/internalapp#task"]
+	if build == nil || dep == nil || !build.Targeted || dep.Targeted {
+		t.Fatalf("only build should be targeted, got %+v and %+v", build, dep)
+	}
+	opts := RunOptions{AdditionalArgs: []string{"--verbose"}}
+	if args := opts.taskArgs(dep); !slices.Equal(args, opts.AdditionalArgs) {
+		t.Errorf("dependencies should get additional args by default, got %v", args)
+	}
+	opts.TargetsOnlyArgs = true
+	if args := opts.taskArgs(dep); args != nil {
+		t.Errorf("dependencies should not get additional args, got %v", args)
+	}
+	if args := opts.taskArgs(build); !slices.Equal(args, opts.AdditionalArgs) {
+		t.Errorf("targeted tasks should get additional args, got %v", args)
+	}
+}
@@ -0,0 +1,777 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	exctr "github.com/software-t-rex/go-jobExecutor/v2"
+	"github.com/software-t-rex/monospace/gomodules/ui"
+	"github.com/software-t-rex/monospace/gomodules/ui/pkg/ansi"
+	"github.com/software-t-rex/monospace/gomodules/utils"
+)
+
+// ErrTaskKilled is returned by tasks killed from the dashboard
+var ErrTaskKilled = errors.New("killed by user")
+
+// ErrCancelledByUser is returned by tasks not started before the dashboard is
+// closed
+var ErrCancelledByUser = errors.New("cancelled by user")
+
+// number of lines of output kept for each task
+var dashboardLogLines = 5000
+
+// number of lines of output printed for failed tasks once the dashboard is
+// closed
+var dashboardFailureLines = 20
+
+// interval between renders of the dashboard while something changes
+var dashboardRefreshInterval = 100 * time.Millisecond
+
+// the terminal clips lines instead of wrapping them while the dashboard is
+// displayed, so overflowing lines can't break the layout
+const (
+	ctrlNoLineWrap = "\x1b[?7l"
+	ctrlLineWrap   = "\x1b[?7h"
+)
+
+type dashboardStatus int
+
+const (
+	statusPending dashboardStatus = iota
+	statusRunning
+	statusReady // persistent task met its ready condition and is still running
+	statusSucceed
+	statusFailed
+	statusKilled
+	statusSkipped
+)
+
+var dashboardStatusNames = [...]string{"pending", "running", "ready", "succeed", "failed", "killed", "skipped"}
+
+func (s dashboardStatus) String() string { return dashboardStatusNames[s] }
+
+// dashboardSupported returns true if the terminal can display the dashboard
+func dashboardSupported() bool {
+	if !ui.EnhancedEnabled() {
+		return false
+	}
+	width, height, err := ui.GetTerminal().GetSize()
+	return err == nil && width > 0 && height > 0
+}
+
+// dashboardTask is a task displayed by the dashboard, its state fields are
+// guarded by the dashboard mutex
+type dashboardTask struct {
+	name string
+	log  *taskLog
+	// run executes the task once, its process is killed when ctx is done
+	run func(ctx context.Context) (string, error)
+	// parent context of the runs contexts
+	parent context.Context
+	// tasks without command can't be restarted or killed
+	controllable bool
+	// state of the last run
+	status    dashboardStatus
+	err       error
+	start     time.Time
+	duration  time.Duration
+	cancel    context.CancelFunc // cancels the current run
+	done      chan struct{}      // closed when the current run returns
+	persisted bool               // the current run left a ready process running
+	killed    bool
+	restart   bool
+}
+
+// dashboard is the full screen output mode: it displays the list of tasks
+// with their status and the output of the selected one, and lets the user
+// restart or kill tasks while they run. It stays open once the jobs are
+// done until the user quits, which kills remaining processes.
+type dashboard struct {
+	uiApi    *ui.ComponentApi
+	bindings *ui.KeyBindings[*dashboard]
+	msgs     chan ui.Msg
+	dirty    atomic.Bool
+	mu       sync.Mutex
+	tasks    []*dashboardTask // sorted by name
+	byJob    map[int]*dashboardTask
+	ctx      context.Context // cancelled when the user quits
+	quit     context.CancelFunc
+	runs     sync.WaitGroup // running tasks and ready processes
+	start    time.Time
+	elapsed  time.Duration // set once the jobs are done
+	finished chan struct{} // closed once the jobs are done
+	closed   chan struct{} // closed once the dashboard is closed
+	// display shows the dashboard until the user quits, then closes it
+	display func(d *dashboard)
+	// view state
+	selected  int // index in the visible tasks
+	scroll    int // lines scrolled up from the end of the log
+	pageSize  int
+	filter    string
+	filtering bool
+}
+
+// dashboardRefresh asks for a new render of the dashboard
+type dashboardRefresh struct{}
+
+func newDashboard() *dashboard {
+	ctx, quit := context.WithCancel(context.Background())
+	return &dashboard{
+		uiApi:    &ui.ComponentApi{Cleanup: true},
+		msgs:     make(chan ui.Msg, 1),
+		byJob:    map[int]*dashboardTask{},
+		ctx:      ctx,
+		quit:     quit,
+		finished: make(chan struct{}),
+		closed:   make(chan struct{}),
+		display:  (*dashboard).show,
+	}
+}
+
+// attach replaces the jobs of the executor by runs controlled by the
+// dashboard, task jobs outputs are sent to the dashboard logs. It must be
+// called after failures.attach.
+func (d *dashboard) attach(e *exctr.JobExecutor, taskJobs *taskJobs, failures *failureHandler) {
+	e.OnJobsStart(func(jobs exctr.JobList) {
+		d.start = time.Now()
+		for jobId, job := range jobs {
+			job.Fn = d.addJob(jobId, job.Name(), job.Cmd, job.Fn, taskJobs.get(jobId), failures.context())
+			job.Cmd = nil
+		}
+		sort.Slice(d.tasks, func(i, j int) bool { return d.tasks[i].name < d.tasks[j].name })
+		d.uiApi.Msgs = d.msgs
+		go d.display(d)
+	})
+	e.OnJobDone(func(jobs exctr.JobList, jobId int) {
+		// jobs skipped by the failure policy never run the dashboard job
+		job := jobs[jobId]
+		if !isSkippedJobErr(job.Err) {
+			return
+		}
+		d.mu.Lock()
+		if task := d.byJob[jobId]; task != nil && task.status == statusPending {
+			task.status = statusSkipped
+			task.err = job.Err
+		}
+		d.mu.Unlock()
+		d.touch()
+	})
+	e.OnJobsDone(func(jobs exctr.JobList) {
+		d.mu.Lock()
+		d.elapsed = time.Since(d.start)
+		d.mu.Unlock()
+		d.touch()
+		close(d.finished)
+		<-d.closed
+	})
+}
+
+// addJob adds a task for the job with given command or handler and returns
+// the handler to run it under the dashboard control
+func (d *dashboard) addJob(jobId int, name string, cmd *exec.Cmd, fn func() (string, error), tj *taskJob, parent context.Context) func() (string, error) {
+	task := &dashboardTask{name: name, log: newTaskLog(dashboardLogLines, d.touch), parent: parent, controllable: true}
+	switch {
+	case tj != nil:
+		tj.stdout = task.log
+		tj.stderr = task.log
+		tj.watchExit = func(exited <-chan error) { d.watchProcess(task, exited) }
+		task.run = func(ctx context.Context) (string, error) {
+			tj.reset(ctx)
+			return tj.run()
+		}
+	case cmd != nil:
+		task.run = func(ctx context.Context) (string, error) {
+			return runDashboardCommand(ctx, cmd, task.log)
+		}
+	default:
+		task.controllable = false
+		task.run = func(context.Context) (string, error) { return fn() }
+	}
+	d.tasks = append(d.tasks, task)
+	d.byJob[jobId] = task
+	return func() (string, error) {
+		d.mu.Lock()
+		ctx := d.startRun(task)
+		d.mu.Unlock()
+		if ctx == nil {
+			return "", ErrCancelledByUser
+		}
+		return d.runFrom(task, ctx)
+	}
+}
+
+// runDashboardCommand runs a copy of cmd in its own process group, writing its
+// output to log. It returns the combined output.
+func runDashboardCommand(ctx context.Context, cmd *exec.Cmd, log io.Writer) (string, error) {
+	var output bytes.Buffer
+	run := commandContext(ctx, cmd)
+	run.Stdout = io.MultiWriter(log, &output)
+	run.Stderr = run.Stdout
+	// don't wait for orphans holding the output pipes once killed
+	run.WaitDelay = time.Second
+	err := runInProcessGroup(run, 0)
+	return output.String(), err
+}
+
+// startRun starts a new run of task and returns its context, or nil when the
+// dashboard is closed. d.mu must be held.
+func (d *dashboard) startRun(task *dashboardTask) context.Context {
+	defer d.touch()
+	if d.ctx.Err() != nil {
+		if task.status == statusPending {
+			task.status = statusSkipped
+			task.err = ErrCancelledByUser
+		}
+		return nil
+	}
+	d.runs.Add(1)
+	ctx, cancel := context.WithCancel(task.parent)
+	stopOnQuit := context.AfterFunc(d.ctx, cancel)
+	task.cancel = func() {
+		stopOnQuit()
+		cancel()
+	}
+	if task.done != nil {
+		io.WriteString(task.log, "[restarted]\n")
+	}
+	task.done = make(chan struct{})
+	task.status = statusRunning
+	task.err = nil
+	task.start = time.Now()
+	task.duration = 0
+	task.persisted = false
+	task.killed = false
+	task.restart = false
+	return ctx
+}
+
+// runFrom runs the task with ctx until it ends without a restart request
+func (d *dashboard) runFrom(task *dashboardTask, ctx context.Context) (string, error) {
+	for {
+		res, err := task.run(ctx)
+		if ctx, err = d.endRun(task, err); ctx == nil {
+			return res, err
+		}
+	}
+}
+
+// endRun records the end of the current run of task and returns its error, or
+// the context of the next run when a restart was requested
+func (d *dashboard) endRun(task *dashboardTask, err error) (context.Context, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	defer d.runs.Done()
+	close(task.done)
+	if task.persisted { // the end of the process is recorded by watchProcess
+		return nil, err
+	}
+	if task.restart && d.ctx.Err() == nil {
+		task.cancel()
+		return d.startRun(task), err
+	}
+	d.finishRun(task, err)
+	return nil, task.err
+}
+
+// finishRun sets the final state of the current run of task. d.mu must be held.
+func (d *dashboard) finishRun(task *dashboardTask, err error) {
+	defer d.touch()
+	task.cancel()
+	task.duration = time.Since(task.start)
+	switch {
+	case err == nil:
+		task.status = statusSucceed
+	case task.killed || d.ctx.Err() != nil:
+		task.status = statusKilled
+		err = ErrTaskKilled
+	default:
+		task.status = statusFailed
+	}
+	task.err = err
+}
+
+// watchProcess is the watchExit handler of task jobs, it records the end of
+// the process of ready persistent tasks and restarts them if requested
+func (d *dashboard) watchProcess(task *dashboardTask, exited <-chan error) {
+	d.mu.Lock()
+	task.persisted = true
+	task.status = statusReady
+	task.duration = time.Since(task.start)
+	done := task.done
+	d.runs.Add(1)
+	d.mu.Unlock()
+	d.touch()
+	go func() {
+		defer d.runs.Done()
+		err := <-exited
+		<-done // the task job must not be reset while its run returns
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		restart := task.restart && d.ctx.Err() == nil
+		d.finishRun(task, err)
+		if restart {
+			if ctx := d.startRun(task); ctx != nil {
+				go d.runFrom(task, ctx)
+			}
+		}
+	}()
+}
+
+// restartTask cancels the current run of task and runs it again, tasks which
+// are done are run again in the background without their dependents
+func (d *dashboard) restartTask(task *dashboardTask) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !task.controllable || d.ctx.Err() != nil {
+		return
+	}
+	switch task.status {
+	case statusPending: // will be started by the executor
+	case statusRunning, statusReady:
+		task.restart = true
+		task.cancel()
+	default:
+		if ctx := d.startRun(task); ctx != nil {
+			go d.runFrom(task, ctx)
+		}
+	}
+}
+
+func (d *dashboard) killTask(task *dashboardTask) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if task.controllable && (task.status == statusRunning || task.status == statusReady) {
+		task.killed = true
+		task.restart = false
+		task.cancel()
+	}
+}
+
+// close kills the running tasks, waits for them to exit and prints the end of
+// the output of failed ones
+func (d *dashboard) close() {
+	d.mu.Lock()
+	d.quit()
+	d.mu.Unlock()
+	d.runs.Wait()
+	theme := ui.GetTheme()
+	for _, task := range d.tasks {
+		if task.status != statusFailed {
+			continue
+		}
+		tail, _ := task.log.tail(dashboardFailureLines, 0)
+		fmt.Printf("%s %s failed in %v\n", theme.FailureIndicator(), theme.Bold(task.name), task.duration)
+		fmt.Print(utils.Indent(theme.Error(task.err.Error()), "  "))
+		if len(tail) > 0 {
+			fmt.Print(utils.Indent(strings.Join(tail, "\n"), "  "))
+		}
+	}
+	close(d.closed)
+}
+
+// show displays the dashboard on the alternate screen until the user quits
+func (d *dashboard) show() {
+	type screenBuffers interface {
+		AltScreenBuffer() error
+		MainScreenBuffer() error
+	}
+	screen, hasScreenBuffers := ui.GetTerminal().(screenBuffers)
+	if hasScreenBuffers {
+		screen.AltScreenBuffer()
+	}
+	fmt.Print(ctrlNoLineWrap)
+	stopRefresh := d.refreshLoop()
+	ui.RunComponent(d)
+	stopRefresh()
+	fmt.Print(ctrlLineWrap)
+	if hasScreenBuffers {
+		screen.MainScreenBuffer()
+	}
+	if d.countStatus(statusRunning)+d.countStatus(statusReady) > 0 {
+		fmt.Println(ui.GetTheme().Info("Stopping running tasks..."))
+	}
+	d.close()
+}
+
+// touch marks the dashboard as needing a new render
+func (d *dashboard) touch() {
+	d.dirty.Store(true)
+}
+
+// refreshLoop sends refresh messages while something changes or tasks are
+// running, it returns a function to stop it
+func (d *dashboard) refreshLoop() func() {
+	stop := make(chan struct{})
+	ticker := time.NewTicker(dashboardRefreshInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if d.dirty.Swap(false) || d.countStatus(statusRunning) > 0 {
+				select {
+				case d.msgs <- dashboardRefresh{}:
+				default:
+				}
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+func (d *dashboard) countStatus(status dashboardStatus) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	count := 0
+	for _, task := range d.tasks {
+		if task.status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// visibleTasks returns the tasks matching the filter. d.mu must be held.
+func (d *dashboard) visibleTasks() []*dashboardTask {
+	if d.filter == "" {
+		return d.tasks
+	}
+	filter := strings.ToLower(d.filter)
+	var res []*dashboardTask
+	for _, task := range d.tasks {
+		if strings.Contains(strings.ToLower(task.name), filter) {
+			res = append(res, task)
+		}
+	}
+	return res
+}
+
+// selectedTask returns the selected task or nil. d.mu must be held.
+func (d *dashboard) selectedTask() *dashboardTask {
+	visible := d.visibleTasks()
+	if d.selected < len(visible) {
+		return visible[d.selected]
+	}
+	return nil
+}
+
+func (d *dashboard) moveSelection(delta int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.selected = min(max(0, d.selected+delta), max(0, len(d.visibleTasks())-1))
+	d.scroll = 0
+}
+
+func (d *dashboard) scrollLog(pages int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.scroll = max(0, d.scroll+pages*max(1, d.pageSize))
+}
+
+// withSelected returns a key handler calling action with the selected task
+func withSelected(action func(d *dashboard, task *dashboardTask)) func(d *dashboard) ui.Cmd {
+	return func(d *dashboard) ui.Cmd {
+		d.mu.Lock()
+		task := d.selectedTask()
+		d.mu.Unlock()
+		if task != nil {
+			action(d, task)
+		}
+		return nil
+	}
+}
+
+func (d *dashboard) Init() ui.Cmd {
+	d.bindings = ui.NewKeyBindings[*dashboard]().
+		AddBinding("up,k", "", func(d *dashboard) ui.Cmd {
+			d.moveSelection(-1)
+			return nil
+		}).
+		AddBinding("down,j", "", func(d *dashboard) ui.Cmd {
+			d.moveSelection(1)
+			return nil
+		}).
+		AddToDescription("↑/↓ select").
+		AddBinding("pageup", "", func(d *dashboard) ui.Cmd {
+			d.scrollLog(1)
+			return nil
+		}).
+		AddBinding("pagedown", "", func(d *dashboard) ui.Cmd {
+			d.scrollLog(-1)
+			return nil
+		}).
+		AddToDescription("⇞/⇟ scroll").
+		AddBinding("r", "restart", withSelected((*dashboard).restartTask)).
+		AddBinding("x", "kill", withSelected((*dashboard).killTask)).
+		AddBinding("/", "filter", func(d *dashboard) ui.Cmd {
+			d.mu.Lock()
+			d.filtering = true
+			d.mu.Unlock()
+			return nil
+		}).
+		AddBinding("q,ctrl+c", "quit", func(d *dashboard) ui.Cmd {
+			return ui.CmdQuit
+		})
+	return nil
+}
+
+func (d *dashboard) Update(msg ui.Msg) ui.Cmd {
+	key, isKey := msg.(ui.MsgKey)
+	if !isKey {
+		return nil // refresh
+	}
+	d.mu.Lock()
+	filtering := d.filtering
+	d.mu.Unlock()
+	if !filtering {
+		return d.bindings.Handle(d, msg)
+	}
+	if key.Value == "ctrl+c" {
+		return ui.CmdQuit
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case key.Value == "enter":
+		d.filtering = false
+	case key.Value == "esc":
+		d.filtering = false
+		d.filter = ""
+	case key.Value == "backspace":
+		if runes := []rune(d.filter); len(runes) > 0 {
+			d.filter = string(runes[:len(runes)-1])
+		}
+	case !key.IsSeq && !key.Unknown && len(key.Value) > 0 && unicode.IsPrint([]rune(key.Value)[0]):
+		d.filter += key.Value
+	default:
+		return nil
+	}
+	d.selected = 0
+	d.scroll = 0
+	return nil
+}
+
+func (d *dashboard) Render() string {
+	width, height, _ := ui.GetTerminal().GetSize()
+	return ansi.CtrlPos.Sprintf(1, 1) + d.render(width, height)
+}
+
+// render returns exactly height lines, with a header, the tasks list next to
+// the log of the selected task, and the key bindings
+func (d *dashboard) render(width, height int) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	theme := ui.GetTheme()
+	visible := d.visibleTasks()
+	bodyHeight := max(1, height-2)
+	listWidth := 0
+	for _, task := range d.tasks {
+		listWidth = max(listWidth, len([]rune(task.name)))
+	}
+	listWidth = min(listWidth+11, max(12, width/2)) // indicator and duration
+	lines := make([]string, 0, height)
+	lines = append(lines, d.renderHeader())
+	logLines := d.renderLog(d.selectedTask(), bodyHeight, max(0, width-listWidth-3))
+	offset := max(0, d.selected-bodyHeight+1)
+	for i := 0; i < bodyHeight; i++ {
+		item := strings.Repeat(" ", listWidth)
+		if offset+i < len(visible) {
+			item = d.renderListItem(visible[offset+i], listWidth, offset+i == d.selected)
+		}
+		lines = append(lines, item+theme.Faint(" │ ")+logLines[i])
+	}
+	if d.filtering {
+		lines = append(lines, "/"+d.filter+"█ "+theme.Faint("enter to apply, esc to clear"))
+	} else if d.filter != "" {
+		lines = append(lines, theme.Info("filter: "+d.filter)+" "+strings.TrimPrefix(d.bindings.GetDescription(), "\n"))
+	} else {
+		lines = append(lines, strings.TrimPrefix(d.bindings.GetDescription(), "\n"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (d *dashboard) renderHeader() string {
+	theme := ui.GetTheme()
+	counts := map[dashboardStatus]int{}
+	for _, task := range d.tasks {
+		counts[task.status]++
+	}
+	parts := []string{theme.Bold(fmt.Sprintf("%d tasks", len(d.tasks)))}
+	styles := []func(...string) string{theme.Faint, theme.Info, theme.Success, theme.Success, theme.Error, theme.Warning, theme.Faint}
+	for status, style := range styles {
+		if count := counts[dashboardStatus(status)]; count > 0 {
+			parts = append(parts, style(fmt.Sprintf("%d %s", count, dashboardStatus(status))))
+		}
+	}
+	elapsed := d.elapsed
+	select {
+	case <-d.finished:
+		parts = append(parts, theme.Bold(fmt.Sprintf("done in %v", formatDashboardDuration(elapsed))))
+	default:
+		parts = append(parts, theme.Bold(formatDashboardDuration(time.Since(d.start))))
+	}
+	return strings.Join(parts, theme.Faint(" · "))
+}
+
+func (d *dashboard) renderListItem(task *dashboardTask, width int, selected bool) string {
+	theme := ui.GetTheme()
+	var indicator string
+	switch task.status {
+	case statusPending:
+		indicator = theme.Faint("○")
+	case statusRunning:
+		indicator = theme.Info("●")
+	case statusReady:
+		indicator = theme.Success("◉")
+	case statusSucceed:
+		indicator = theme.Success("✔")
+	case statusFailed:
+		indicator = theme.Error("✘")
+	case statusKilled:
+		indicator = theme.Warning("■")
+	case statusSkipped:
+		indicator = theme.Faint("»")
+	}
+	duration := ""
+	if task.status == statusRunning {
+		duration = formatDashboardDuration(time.Since(task.start))
+	} else if task.status != statusPending && task.status != statusSkipped {
+		duration = formatDashboardDuration(task.duration)
+	}
+	nameWidth := max(1, width-3-len(duration))
+	name := padRunes(task.name, nameWidth)
+	if selected {
+		name = theme.Reversed(name)
+	}
+	return indicator + " " + name + " " + theme.Faint(duration)
+}
+
+// renderLog returns height lines with the state of task and the end of its
+// log, or the scrolled part of it
+func (d *dashboard) renderLog(task *dashboardTask, height, width int) []string {
+	theme := ui.GetTheme()
+	lines := make([]string, height)
+	if task == nil {
+		return lines
+	}
+	title := theme.Bold(task.name) + " " + task.status.String()
+	if task.err != nil {
+		title += ": " + theme.Error(task.err.Error())
+	}
+	d.pageSize = max(1, height-1)
+	tail, scroll := task.log.tail(height-1, d.scroll)
+	d.scroll = scroll
+	if scroll > 0 {
+		title += theme.Faint(fmt.Sprintf(" (%d lines below)", scroll))
+	}
+	lines[0] = title
+	for i, line := range tail {
+		lines[i+1] = truncateRunes(line, width)
+	}
+	return lines
+}
+
+func formatDashboardDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	} else if d < time.Minute {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
+
+func truncateRunes(s string, width int) string {
+	if runes := []rune(s); len(runes) > width {
+		return string(runes[:width])
+	}
+	return s
+}
+
+// padRunes truncates or pads s with spaces to width runes
+func padRunes(s string, width int) string {
+	s = truncateRunes(s, width)
+	return s + strings.Repeat(" ", width-len([]rune(s)))
+}
+
+func (d *dashboard) Fallback() (ui.Model, error) { return d, nil }
+
+func (d *dashboard) GetComponentApi() *ui.ComponentApi { return d.uiApi }
+
+// taskLog keeps the last lines written by a task, without escape sequences
+type taskLog struct {
+	mu       sync.Mutex
+	lines    []string
+	partial  []byte // incomplete last line
+	maxLines int
+	onWrite  func()
+}
+
+func newTaskLog(maxLines int, onWrite func()) *taskLog {
+	return &taskLog{maxLines: maxLines, onWrite: onWrite}
+}
+
+func (l *taskLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	l.partial = append(l.partial, p...)
+	for {
+		end := bytes.IndexByte(l.partial, '\n')
+		if end < 0 {
+			break
+		}
+		l.lines = append(l.lines, cleanLogLine(l.partial[:end]))
+		l.partial = l.partial[end+1:]
+	}
+	if len(l.lines) > l.maxLines {
+		l.lines = l.lines[len(l.lines)-l.maxLines:]
+	}
+	l.mu.Unlock()
+	if l.onWrite != nil {
+		l.onWrite()
+	}
+	return len(p), nil
+}
+
+// tail returns at most n lines ending scroll lines before the end of the log,
+// and scroll limited to the available lines
+func (l *taskLog) tail(n, scroll int) ([]string, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := l.lines
+	if len(l.partial) > 0 {
+		lines = append(lines[:len(lines):len(lines)], cleanLogLine(l.partial))
+	}
+	scroll = min(scroll, max(0, len(lines)-n))
+	end := len(lines) - scroll
+	return lines[max(0, end-n):end], scroll
+}
+
+// cleanLogLine removes escape sequences and what a carriage return overwrites
+// (like progress bars), and expands tabs
+func cleanLogLine(line []byte) string {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if start := bytes.LastIndexByte(line, '\r'); start >= 0 {
+		line = line[start+1:]
+	}
+	line = ansiEscapeRegexp.ReplaceAll(line, nil)
+	return strings.ReplaceAll(string(line), "\t", "    ")
+}
@@ -0,0 +1,141 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	exctr "github.com/software-t-rex/go-jobExecutor/v2"
+	"github.com/software-t-rex/monospace/gomodules/ui"
+)
+
+func TestTaskLog(t *testing.T) {
+	log := newTaskLog(3, nil)
+	log.Write([]byte("one\n\x1b[31mtwo\x1b[0m\nthree\n"))
+	log.Write([]byte("progress 10%\rprogress 100%\n\tfour"))
+	lines, scroll := log.tail(10, 0)
+	expected := []string{"two", "three", "progress 100%", "    four"}
+	if scroll != 0 || strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q (scroll %d)", expected, lines, scroll)
+	}
+	lines, scroll = log.tail(2, 1)
+	if scroll != 1 || strings.Join(lines, "|") != "three|progress 100%" {
+		t.Errorf("unexpected scrolled tail %q (scroll %d)", lines, scroll)
+	}
+	// scroll is limited to the available lines
+	if _, scroll = log.tail(2, 10); scroll != 2 {
+		t.Errorf("expected scroll to be limited to 2, got %d", scroll)
+	}
+}
+
+func (d *dashboard) testTask(name string) *dashboardTask {
+	for _, task := range d.tasks {
+		if task.name == name {
+			return task
+		}
+	}
+	return nil
+}
+
+// waitStatus waits for the task to reach status, it can be called from the
+// display goroutine
+func waitStatus(t *testing.T, d *dashboard, task *dashboardTask, status dashboardStatus) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d.mu.Lock()
+		current := task.status
+		d.mu.Unlock()
+		if current == status {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("%s: expected status %s, got %s", task.name, status, current)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDashboard_Run(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	t.Cleanup(SetMaxConcurrentJobs(4))
+	e := exctr.NewExecutor()
+	d := newDashboard()
+	d.display = func(d *dashboard) {
+		killed := d.testTask("killed")
+		waitStatus(t, d, killed, statusRunning)
+		d.killTask(killed)
+		<-d.finished
+		// done tasks are run again in the background
+		ok := d.testTask("ok")
+		d.restartTask(ok)
+		waitStatus(t, d, ok, statusSucceed)
+		d.close()
+	}
+	d.attach(e, nil, nil)
+	e.AddJob(exctr.NamedJob{Name: "ok", Job: exec.Command("sh", "-c", "echo hello")})
+	e.AddJob(exctr.NamedJob{Name: "ko", Job: exec.Command("sh", "-c", "echo oops >&2; exit 2")})
+	e.AddJob(exctr.NamedJob{Name: "killed", Job: exec.Command("sleep", "10")})
+	e.AddJob(exctr.NamedJob{Name: "dummy", Job: func() (string, error) { return "", nil }})
+	start := time.Now()
+	e.DagExecute()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("killed task should not run to its end, took %v", elapsed)
+	}
+	ok, ko, killed := d.testTask("ok"), d.testTask("ko"), d.testTask("killed")
+	if lines, _ := ok.log.tail(10, 0); strings.Join(lines, "|") != "hello|[restarted]|hello" {
+		t.Errorf("unexpected ok task log %q", lines)
+	}
+	if lines, _ := ko.log.tail(10, 0); ko.status != statusFailed || strings.Join(lines, "|") != "oops" {
+		t.Errorf("expected ko task to fail with its output, got %s %q", ko.status, lines)
+	}
+	if !errors.Is(killed.err, ErrTaskKilled) {
+		t.Errorf("expected killed task error, got %v", killed.err)
+	}
+	if dummy := d.testTask("dummy"); dummy.status != statusSucceed || dummy.controllable {
+		t.Errorf("expected uncontrollable succeed dummy task, got %s", dummy.status)
+	}
+}
+
+func TestDashboard_FilterAndRender(t *testing.T) {
+	d := newDashboard()
+	d.start = time.Now()
+	for _, name := range []string{"app#build", "app#test", "lib#build"} {
+		d.tasks = append(d.tasks, &dashboardTask{name: name, log: newTaskLog(10, nil)})
+	}
+	d.tasks[2].status = statusFailed
+	d.tasks[2].log.Write([]byte("first\nsecond\n"))
+	d.Init()
+	for _, key := range []string{"/", "l", "x", "backspace", "i", "enter"} {
+		d.Update(ui.MsgKey{Value: key, IsSeq: len(key) > 1})
+	}
+	if d.filtering || d.filter != "li" || len(d.visibleTasks()) != 1 {
+		t.Fatalf("expected filter li to match one task, got %q (%d tasks)", d.filter, len(d.visibleTasks()))
+	}
+	view := d.render(60, 6)
+	if lines := strings.Split(view, "\n"); len(lines) != 6 {
+		t.Errorf("expected 6 lines, got %d:\n%s", len(lines), view)
+	}
+	if !strings.Contains(view, "lib#build") || strings.Contains(view, "app#test") || !strings.Contains(view, "second") {
+		t.Errorf("unexpected filtered view:\n%s", view)
+	}
+	d.Update(ui.MsgKey{Value: "/"})
+	d.Update(ui.MsgKey{Value: "esc", IsSeq: true})
+	d.Update(ui.MsgKey{Value: "down", IsSeq: true})
+	if d.filter != "" || d.selectedTask().name != "app#test" {
+		t.Errorf("expected cleared filter and app#test selected, got %q %s", d.filter, d.selectedTask().name)
+	}
+}
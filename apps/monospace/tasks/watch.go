@@ -0,0 +1,307 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/software-t-rex/monospace/gomodules/ui"
+	"github.com/software-t-rex/monospace/gomodules/utils"
+	"github.com/software-t-rex/monospace/mono"
+)
+
+// quiet period waited after a change before re-running tasks, so that
+// saving many files at once triggers a single run
+var watchDebounce = 200 * time.Millisecond
+
+// fileWatcher notifies changes in watched directories
+type fileWatcher interface {
+	// add watches the given directories (not recursively), already watched
+	// directories are ignored
+	add(dirs []string) error
+	// events receives a value when something may have changed in the watched
+	// directories
+	events() <-chan struct{}
+	close() error
+}
+
+// fileStamp is the state of an input file used to detect changes
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// inputsSnapshot holds the state of the input files of tasks by task name
+type inputsSnapshot map[string]map[string]fileStamp
+
+// Watch runs the task list then watches the inputs of its tasks, as resolved
+// for the cache, to re-run the tasks whose inputs changed and the tasks
+// depending on them. Persistent tasks and the tasks depending on them are
+// started once after the first run, persistent ones are kept alive.
+// It never returns.
+func Watch(taskList TaskList, opts RunOptions) {
+	if taskList.Len() == 0 {
+		exit("no tasks found")
+	}
+	if opts.OutputMode == "tui" {
+		// the dashboard stays open after the first run until the user quits
+		utils.PrintWarning("tui output mode can't be used with --watch, using interleaved output")
+		opts.OutputMode = "interleaved"
+	}
+	watcher, err := newFileWatcher()
+	if err != nil {
+		exit(err.Error())
+	}
+	defer watcher.close()
+	watchTaskList(context.Background(), taskList, opts, watcher)
+}
+
+// watchTaskList runs the watch loop until ctx is done
+func watchTaskList(ctx context.Context, taskList TaskList, opts RunOptions, watcher fileWatcher) {
+	theme := ui.GetTheme()
+	root := mono.SpaceGetRoot()
+	var watched, persistent []string
+	for name, task := range taskList.List {
+		if task.Persists() {
+			persistent = append(persistent, name)
+		} else {
+			watched = append(watched, name)
+		}
+	}
+	sort.Strings(watched)
+	background := taskList.withDependents(persistent)
+
+	runTaskList(taskList.subList(utils.SliceFilter(watched, func(name string) bool {
+		return !utils.SliceContains(background, name)
+	})), opts)
+	if len(persistent) > 0 {
+		// persistent tasks hold a concurrency slot for the whole session, so
+		// the limit shared by the background run and the next runs is raised
+		// once, before they start, and restored when none of them is running
+		concurrency := opts.Concurrency
+		if concurrency <= 0 {
+			concurrency = runtime.GOMAXPROCS(0)
+		}
+		restoreConcurrency := SetMaxConcurrentJobs(concurrency + len(persistent))
+		backgroundOpts := opts
+		backgroundOpts.Concurrency = 0
+		backgroundDone := make(chan struct{})
+		go func() {
+			defer close(backgroundDone)
+			runTaskList(taskList.subList(background), backgroundOpts)
+		}()
+		defer func() {
+			go func() {
+				<-backgroundDone
+				restoreConcurrency()
+			}()
+		}()
+	}
+	// keep the limit of the session for the next runs
+	opts.Concurrency = 0
+
+	snapshot, dirs := taskList.snapshotInputs(watched, root)
+	for {
+		if err := watcher.add(dirs); err != nil {
+			utils.PrintWarning(err.Error())
+		}
+		fmt.Println(theme.Info(fmt.Sprintf("Watching inputs of %d tasks for changes (ctrl+c to exit)", len(watched))))
+		if !waitForChanges(ctx, watcher) {
+			return
+		}
+		var next inputsSnapshot
+		next, dirs = taskList.snapshotInputs(watched, root)
+		changed := snapshot.changedTasks(next)
+		snapshot = next
+		if len(changed) == 0 {
+			continue
+		}
+		toRun := taskList.withDependents(changed)
+		fmt.Println(theme.Info(fmt.Sprintf("Inputs of %s changed, running %d tasks", strings.Join(changed, ", "), len(toRun))))
+		runTaskList(taskList.subList(toRun), opts)
+		if ctx.Err() != nil {
+			return
+		}
+		// changes made by the run itself must not trigger another one
+		snapshot, dirs = taskList.snapshotInputs(watched, root)
+		drainEvents(watcher)
+	}
+}
+
+// waitForChanges waits for a change followed by a quiet period, it returns
+// false when ctx is done
+func waitForChanges(ctx context.Context, watcher fileWatcher) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-watcher.events():
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-watcher.events():
+		case <-time.After(watchDebounce):
+			return true
+		}
+	}
+}
+
+func drainEvents(watcher fileWatcher) {
+	for {
+		select {
+		case <-watcher.events():
+		default:
+			return
+		}
+	}
+}
+
+// snapshotInputs returns the state of the input files of the given tasks and
+// the directories to watch to detect their changes. Outputs of the tasks of a
+// project are excluded from the inputs of the project tasks.
+func (t TaskList) snapshotInputs(names []string, root string) (inputsSnapshot, []string) {
+	snapshot := make(inputsSnapshot, len(names))
+	dirs := map[string]bool{}
+	outputs := map[string][]string{}
+	for _, task := range t.List {
+		outputs[task.Name.Project] = append(outputs[task.Name.Project], task.TaskDef.Outputs...)
+	}
+	for _, name := range names {
+		task := t.List[name]
+		opts := TaskCacheOptions(task, t.config, root)
+		files, err := resolveInputFiles(opts)
+		if err != nil {
+			utils.PrintWarning(fmt.Sprintf("%s: %s", name, err))
+		}
+		stamps := make(map[string]fileStamp, len(files))
+		addDirs(dirs, opts.ProjectPath, opts.ProjectPath)
+		for _, file := range files {
+			if matchesAnyPattern(opts.ProjectPath, file, outputs[task.Name.Project]) {
+				continue
+			}
+			if info, err := os.Stat(file); err == nil {
+				stamps[file] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+				addDirs(dirs, opts.ProjectPath, filepath.Dir(file))
+			}
+		}
+		fsys := os.DirFS(root)
+		for _, pattern := range opts.GlobalInputs {
+			matches, _ := doublestar.Glob(fsys, pattern) // invalid patterns are reported by the cache
+			for _, match := range matches {
+				file := filepath.Join(root, match)
+				if info, err := os.Stat(file); err == nil && !info.IsDir() {
+					stamps[file] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+					addDirs(dirs, root, filepath.Dir(file))
+				}
+			}
+		}
+		snapshot[name] = stamps
+	}
+	return snapshot, utils.MapGetKeys(dirs)
+}
+
+// add dir and its parents up to base, so that new sub directories are noticed
+func addDirs(dirs map[string]bool, base, dir string) {
+	for !dirs[dir] {
+		dirs[dir] = true
+		if dir == base || !strings.HasPrefix(dir, base) {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// return true if file relative path to base matches one of the patterns
+func matchesAnyPattern(base, file string, patterns []string) bool {
+	rel, err := filepath.Rel(base, file)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if match, _ := doublestar.Match(pattern, filepath.ToSlash(rel)); match {
+			return true
+		}
+	}
+	return false
+}
+
+// changedTasks returns the sorted names of the tasks whose input files differ
+// in next snapshot
+func (s inputsSnapshot) changedTasks(next inputsSnapshot) []string {
+	var changed []string
+	for name, stamps := range next {
+		prev := s[name]
+		if len(prev) != len(stamps) {
+			changed = append(changed, name)
+			continue
+		}
+		for file, stamp := range stamps {
+			if prevStamp, ok := prev[file]; !ok || prevStamp.size != stamp.size || !prevStamp.modTime.Equal(stamp.modTime) {
+				changed = append(changed, name)
+				break
+			}
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// withDependents returns the sorted names of the given tasks and of the non
+// persistent tasks depending on them (directly or not)
+func (t TaskList) withDependents(names []string) []string {
+	selected := make(map[string]bool, len(names))
+	for _, name := range names {
+		selected[name] = true
+	}
+	for found := true; found; {
+		found = false
+		for name, task := range t.List {
+			if selected[name] || task.Persists() {
+				continue
+			}
+			for _, dep := range task.TaskDef.DependsOn {
+				if selected[dep] {
+					selected[name] = true
+					found = true
+					break
+				}
+			}
+		}
+	}
+	res := utils.MapGetKeys(selected)
+	sort.Strings(res)
+	return res
+}
+
+// subList returns a task list restricted to the given tasks, dependencies on
+// tasks not in the list are dropped
+func (t TaskList) subList(names []string) TaskList {
+	res := TaskList{List: make(map[string]*Task, len(names)), Pipeline: t.Pipeline, config: t.config, projectsDeps: t.projectsDeps}
+	for _, name := range names {
+		if task, ok := t.List[name]; ok {
+			copied := *task
+			res.List[name] = &copied
+		}
+	}
+	for _, task := range res.List {
+		task.TaskDef.DependsOn = utils.SliceFilter(task.TaskDef.DependsOn, func(dep string) bool {
+			_, ok := res.List[dep]
+			return ok
+		})
+	}
+	return res
+}
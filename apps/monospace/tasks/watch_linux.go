@@ -0,0 +1,73 @@
+//go:build linux
+
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotifyWatcher notifies changes using inotify
+type inotifyWatcher struct {
+	fd      int
+	file    *os.File // non blocking fd wrapper, closing it stops the reads
+	changes chan struct{}
+}
+
+func newFileWatcher() (fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("can't watch files: %w", err)
+	}
+	w := &inotifyWatcher{fd: fd, file: os.NewFile(uintptr(fd), "inotify"), changes: make(chan struct{}, 1)}
+	go w.read()
+	return w, nil
+}
+
+// adding an already watched directory only updates its watch, so directories
+// removed then created again are watched again
+func (w *inotifyWatcher) add(dirs []string) error {
+	for _, dir := range dirs {
+		if _, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask); err != nil && !errors.Is(err, syscall.ENOENT) {
+			return fmt.Errorf("can't watch %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+func (w *inotifyWatcher) events() <-chan struct{} {
+	return w.changes
+}
+
+func (w *inotifyWatcher) close() error {
+	return w.file.Close()
+}
+
+// read notifies any event, changes are detected by comparing inputs snapshots
+func (w *inotifyWatcher) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		if n > 0 {
+			select {
+			case w.changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
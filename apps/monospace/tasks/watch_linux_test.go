@@ -0,0 +1,42 @@
+//go:build linux
+
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInotifyWatcher(t *testing.T) {
+	dir := t.TempDir()
+	watcher, err := newFileWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.close()
+	if err := watcher.add([]string{dir, filepath.Join(dir, "missing")}); err != nil {
+		t.Fatalf("missing directories should be ignored, got %v", err)
+	}
+	select {
+	case <-watcher.events():
+		t.Fatal("unexpected event")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.go"), []byte("a"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-watcher.events():
+	case <-time.After(2 * time.Second):
+		t.Fatal("file creation should be notified")
+	}
+}
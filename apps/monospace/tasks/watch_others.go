@@ -0,0 +1,55 @@
+//go:build !linux
+
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import "time"
+
+// interval between inputs snapshots when files changes can't be notified
+var watchPollInterval = time.Second
+
+// pollWatcher notifies a possible change at each interval, changes are
+// detected by comparing inputs snapshots
+type pollWatcher struct {
+	ticker  *time.Ticker
+	changes chan struct{}
+	done    chan struct{}
+}
+
+func newFileWatcher() (fileWatcher, error) {
+	w := &pollWatcher{ticker: time.NewTicker(watchPollInterval), changes: make(chan struct{}, 1), done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-w.ticker.C:
+				select {
+				case w.changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return w, nil
+}
+
+func (w *pollWatcher) add(dirs []string) error {
+	return nil
+}
+
+func (w *pollWatcher) events() <-chan struct{} {
+	return w.changes
+}
+
+func (w *pollWatcher) close() error {
+	w.ticker.Stop()
+	close(w.done)
+	return nil
+}
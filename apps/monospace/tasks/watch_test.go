@@ -0,0 +1,169 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/software-t-rex/monospace/app"
+)
+
+func TestTaskList_WithDependentsAndSubList(t *testing.T) {
+	taskList := TaskList{List: map[string]*Task{
+		"lib#build": NewTask("lib#build", app.MonospaceConfigTask{}),
+		"lib#lint":  NewTask("lib#lint", app.MonospaceConfigTask{}),
+		"app#build": NewTask("app#build", app.MonospaceConfigTask{DependsOn: []string{"lib#build"}}),
+		"app#test":  NewTask("app#test", app.MonospaceConfigTask{DependsOn: []string{"app#build"}}),
+		"app#serve": NewTask("app#serve", app.MonospaceConfigTask{DependsOn: []string{"app#build"}, Persistent: true}),
+	}}
+	got := taskList.withDependents([]string{"lib#build"})
+	if want := []string{"app#build", "app#test", "lib#build"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	sub := taskList.subList([]string{"app#build", "app#test"})
+	if sub.Len() != 2 || len(sub.List["app#build"].TaskDef.DependsOn) != 0 || !reflect.DeepEqual(sub.List["app#test"].TaskDef.DependsOn, []string{"app#build"}) {
+		t.Errorf("unexpected sub list dependencies %+v", sub.List)
+	}
+	if len(taskList.List["app#build"].TaskDef.DependsOn) != 1 {
+		t.Error("sub list should not alter the task list")
+	}
+}
+
+func TestTaskList_SnapshotInputs(t *testing.T) {
+	root := makeTestProject(t, map[string]string{
+		".monospace/monospace.yml": "projects: {}",
+		"lib/src/a.go":             "a",
+		"lib/README.md":            "readme",
+	})
+	useTestMonospace(t, root)
+	taskList := TaskList{List: map[string]*Task{
+		"lib#build": NewTask("lib#build", app.MonospaceConfigTask{Inputs: []string{"src/**"}, Outputs: []string{"dist/**"}}),
+		"lib#test":  NewTask("lib#test", app.MonospaceConfigTask{}),
+	}, config: &app.MonospaceConfig{Projects: map[string]string{"lib": "internal"}}}
+	names := []string{"lib#build", "lib#test"}
+	snapshot, dirs := taskList.snapshotInputs(names, root)
+	for _, dir := range []string{"lib", "lib/src"} {
+		if !strings.Contains(strings.Join(dirs, ","), filepath.Join(root, dir)) {
+			t.Errorf("%s should be watched, got %v", dir, dirs)
+		}
+	}
+	changes := func(files map[string]string) []string {
+		t.Helper()
+		for name, content := range files {
+			path := filepath.Join(root, name)
+			os.MkdirAll(filepath.Dir(path), 0750)
+			if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+				t.Fatal(err)
+			}
+		}
+		next, _ := taskList.snapshotInputs(names, root)
+		changed := snapshot.changedTasks(next)
+		snapshot = next
+		return changed
+	}
+	if changed := changes(map[string]string{"lib/dist/a.js": "a"}); len(changed) != 0 {
+		t.Errorf("outputs changes should be ignored, got %v", changed)
+	}
+	if changed := changes(map[string]string{"lib/src/a.go": "changed"}); !reflect.DeepEqual(changed, names) {
+		t.Errorf("both tasks inputs changed, got %v", changed)
+	}
+	if changed := changes(map[string]string{"lib/doc/new.md": "new"}); !reflect.DeepEqual(changed, []string{"lib#test"}) {
+		t.Errorf("only lib#test inputs changed, got %v", changed)
+	}
+}
+
+func TestWatchTaskList_RunsChangedTasksAndDependents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	root := makeTestProject(t, map[string]string{
+		".monospace/monospace.yml": "projects: {}",
+		"lib/a.go":                 "a",
+		"app/b.go":                 "b",
+		"other/c.go":               "c",
+	})
+	useTestMonospace(t, root)
+	defer func(debounce time.Duration) { watchDebounce = debounce }(watchDebounce)
+	watchDebounce = 10 * time.Millisecond
+	record := func(name string) []string {
+		return []string{"sh", "-c", "echo " + name + " >> ../runs"}
+	}
+	taskList := TaskList{List: map[string]*Task{
+		"lib#build":   NewTask("lib#build", app.MonospaceConfigTask{Cmd: record("lib")}),
+		"app#build":   NewTask("app#build", app.MonospaceConfigTask{Cmd: record("app"), DependsOn: []string{"lib#build"}}),
+		"other#build": NewTask("other#build", app.MonospaceConfigTask{Cmd: record("other")}),
+		"app#serve":   NewTask("app#serve", app.MonospaceConfigTask{Cmd: []string{"sh", "-c", "echo serve >> ../runs; sleep 1"}, Persistent: true, DependsOn: []string{"app#build"}}),
+	}, config: &app.MonospaceConfig{Projects: map[string]string{"lib": "internal", "app": "internal", "other": "internal"}}}
+	watcher, err := newFileWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.close()
+	previousLimit := currentMaxConcurrentJobs()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchTaskList(ctx, taskList, RunOptions{OutputMode: "none", NoCache: true, Concurrency: 1}, watcher)
+		close(done)
+	}()
+	stop := sync.OnceFunc(func() {
+		cancel()
+		<-done
+	})
+	defer stop()
+	waitRuns := func(want int) []string {
+		t.Helper()
+		var runs []string
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			data, _ := os.ReadFile(filepath.Join(root, "runs"))
+			if runs = strings.Fields(string(data)); len(runs) >= want {
+				return runs
+			}
+		}
+		t.Fatalf("expected %d runs, got %v", want, runs)
+		return nil
+	}
+	if runs := waitRuns(4); !strings.Contains(strings.Join(runs, " "), "serve") {
+		t.Fatalf("persistent task should be started after the first run, got %v", runs)
+	}
+	if limit := currentMaxConcurrentJobs(); limit != 2 {
+		t.Errorf("persistent task should get its own concurrency slot for the session, got a limit of %d", limit)
+	}
+	// let the watcher settle before changing inputs
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(root, "lib/a.go"), []byte("changed"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	runs := waitRuns(6)
+	time.Sleep(100 * time.Millisecond)
+	runs = waitRuns(6)
+	if got := strings.Join(runs[4:], " "); len(runs) != 6 || got != "lib app" {
+		t.Errorf("expected lib then app to be re-run only, got %v", runs)
+	}
+	stop()
+	// restored once the persistent task exited
+	for deadline := time.Now().Add(5 * time.Second); currentMaxConcurrentJobs() != previousLimit; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("concurrency limit should be restored to %d after the session, got %d", previousLimit, currentMaxConcurrentJobs())
+		}
+	}
+}
+
+func currentMaxConcurrentJobs() int {
+	maxConcurrentJobs.mu.Lock()
+	defer maxConcurrentJobs.mu.Unlock()
+	return maxConcurrentJobs.n
+}
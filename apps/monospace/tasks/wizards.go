@@ -97,7 +97,7 @@ func cutString(s string, maxLen int) string {
 }
 
 func cycleOutputMode(task *app.MonospaceConfigTask, direction int) {
-	outputmodes := []string{"", "grouped", "interleaved", "status-only", "errors-only", "none"}
+	outputmodes := []string{"", "grouped", "interleaved", "status-only", "errors-only", "tui", "none"}
 	index := utils.SliceFindIndex(outputmodes, task.OutputMode)
 	index += direction
 	if index < 0 {
@@ -144,6 +144,9 @@ const (
 	TaskConfigSectionDeps
 	TaskConfigSectionPersist
 	TaskConfigSectionOutputMode
+	TaskConfigSectionRetries
+	TaskConfigSectionRetryDelay
+	TaskConfigSectionTimeout
 	TaskConfigSectionCache         // "skip" | "restore" | ""
 	TaskConfigSectionCacheStrategy // "content" | "mtime" | ""
 	TaskConfigSectionCacheMaxEntries
@@ -165,6 +168,8 @@ type TaskEditorUIModel struct {
 	uiAPI        *ui.ComponentApi
 	config       *app.MonospaceConfig
 	canceled     bool
+	errorMsg     string // error of the rejected line edition
+	invalidValue string // rejected value, kept for the next line edition
 }
 
 func (m *TaskEditorUIModel) Init() ui.Cmd {
@@ -173,7 +178,8 @@ func (m *TaskEditorUIModel) Init() ui.Cmd {
 	m.bindings.
 		AddBinding("enter", "", func(m *TaskEditorUIModel) ui.Cmd {
 			switch m.focusSection {
-			case TaskConfigSectionCmd, TaskConfigSectionDesc, TaskConfigSectionInputs, TaskConfigSectionOutputs, TaskConfigSectionCacheMaxEntries:
+			case TaskConfigSectionCmd, TaskConfigSectionDesc, TaskConfigSectionInputs, TaskConfigSectionOutputs, TaskConfigSectionCacheMaxEntries,
+				TaskConfigSectionRetries, TaskConfigSectionRetryDelay, TaskConfigSectionTimeout:
 				if !m.editing {
 					m.startLineEdition()
 				}
@@ -246,18 +252,41 @@ func (m *TaskEditorUIModel) startLineEdition() {
 }
 func (m *TaskEditorUIModel) endLineEdition() ui.Msg {
 	m.editing = false
+	m.errorMsg = ""
+	m.invalidValue = ""
 	m.uiAPI.InputReader = ui.KeyReader
 	return nil
 }
 
+// rejectLineEdition keeps the line edition open with the rejected value and
+// returns true when err is not nil
+func (m *TaskEditorUIModel) rejectLineEdition(value string, err error) bool {
+	if err == nil {
+		return false
+	}
+	m.errorMsg = err.Error()
+	m.invalidValue = value
+	return true
+}
+
 func (m *TaskEditorUIModel) ReadlineConfig() ui.LineEditorOptions {
 	var val string
-	if m.editing {
+	if m.editing && m.errorMsg != "" {
+		val = m.invalidValue
+	} else if m.editing {
 		switch m.focusSection {
 		case TaskConfigSectionCmd:
 			val = strings.Join(m.task.TaskDef.Cmd, " ")
 		case TaskConfigSectionDesc:
 			val = m.task.TaskDef.Description
+		case TaskConfigSectionRetries:
+			if m.task.TaskDef.Retries > 0 {
+				val = strconv.Itoa(m.task.TaskDef.Retries)
+			}
+		case TaskConfigSectionRetryDelay:
+			val = m.task.TaskDef.RetryDelay
+		case TaskConfigSectionTimeout:
+			val = m.task.TaskDef.Timeout
 		case TaskConfigSectionCacheMaxEntries:
 			if m.task.TaskDef.CacheMaxEntries > 0 {
 				val = strconv.Itoa(m.task.TaskDef.CacheMaxEntries)
@@ -302,6 +331,36 @@ func (m *TaskEditorUIModel) Update(msg ui.Msg) ui.Cmd {
 			case TaskConfigSectionDesc:
 				m.task.TaskDef.Description = msg.Value()
 				m.endLineEdition()
+			case TaskConfigSectionRetries:
+				v := strings.TrimSpace(msg.Value())
+				retries := 0
+				var err error
+				if v != "" {
+					if retries, err = strconv.Atoi(v); err != nil {
+						err = fmt.Errorf("%w: retries %q, expected a positive number", app.ErrInvalidTaskConfig, v)
+					} else {
+						_, err = (app.MonospaceConfigTask{Retries: retries}).GetRetries()
+					}
+				}
+				if m.rejectLineEdition(v, err) {
+					return nil
+				}
+				m.task.TaskDef.Retries = retries
+				m.endLineEdition()
+			case TaskConfigSectionRetryDelay:
+				v := strings.TrimSpace(msg.Value())
+				if _, err := (app.MonospaceConfigTask{RetryDelay: v}).GetRetryDelay(); m.rejectLineEdition(v, err) {
+					return nil
+				}
+				m.task.TaskDef.RetryDelay = v
+				m.endLineEdition()
+			case TaskConfigSectionTimeout:
+				v := strings.TrimSpace(msg.Value())
+				if _, err := (app.MonospaceConfigTask{Timeout: v}).GetTimeout(); m.rejectLineEdition(v, err) {
+					return nil
+				}
+				m.task.TaskDef.Timeout = v
+				m.endLineEdition()
 			case TaskConfigSectionCacheMaxEntries:
 				v := strings.TrimSpace(msg.Value())
 				if v == "" {
@@ -362,6 +421,24 @@ func (m *TaskEditorUIModel) renderHelp(theme *ui.Theme) string {
 		sb.WriteString(fmt.Sprintf("%s %s %s to toggle\n", dfltMsg, keyBindSep, boldInFaint("↵")))
 	case TaskConfigSectionOutputMode:
 		sb.WriteString(fmt.Sprintf("%s %s %s to switch\n", dfltMsg, keyBindSep, boldInFaint(fmt.Sprintf("arrows%s↵", keySep))))
+	case TaskConfigSectionRetries:
+		if m.editing {
+			sb.WriteString(fmt.Sprintf("%s %s to save %s %s to cancel\n", theme.Accentuated(boldInFaint("Retries after a failure (empty = no retry):")), boldInFaint("↵"), keyBindSep, boldInFaint("esc")))
+		} else {
+			sb.WriteString(fmt.Sprintf("%s %s %s to edit\n", dfltMsg, keyBindSep, boldInFaint("↵")))
+		}
+	case TaskConfigSectionRetryDelay:
+		if m.editing {
+			sb.WriteString(fmt.Sprintf("%s %s to save %s %s to cancel\n", theme.Accentuated(boldInFaint("Delay between attempts like 5s (empty = none):")), boldInFaint("↵"), keyBindSep, boldInFaint("esc")))
+		} else {
+			sb.WriteString(fmt.Sprintf("%s %s %s to edit\n", dfltMsg, keyBindSep, boldInFaint("↵")))
+		}
+	case TaskConfigSectionTimeout:
+		if m.editing {
+			sb.WriteString(fmt.Sprintf("%s %s to save %s %s to cancel\n", theme.Accentuated(boldInFaint("Timeout of each attempt like 10m (empty = none):")), boldInFaint("↵"), keyBindSep, boldInFaint("esc")))
+		} else {
+			sb.WriteString(fmt.Sprintf("%s %s %s to edit\n", dfltMsg, keyBindSep, boldInFaint("↵")))
+		}
 	case TaskConfigSectionCache:
 		sb.WriteString(fmt.Sprintf("%s %s %s to switch\n", dfltMsg, keyBindSep, boldInFaint(fmt.Sprintf("arrows%s↵", keySep))))
 	case TaskConfigSectionCacheStrategy:
@@ -392,7 +469,7 @@ func (m *TaskEditorUIModel) renderHelp(theme *ui.Theme) string {
 	return ui.ApplyStyle(sb.String(), ui.Faint)
 }
 func (m *TaskEditorUIModel) Render() string {
-	errorMsg := ""
+	errorMsg := m.errorMsg
 	if m.focusSection == TaskConfigSectionDeps && m.editing {
 		// this is a little bit hacky but the dependency selector will grab the rendering
 		deps, errDeps := DependencySelector(fmt.Sprintf("Configuring %s\nSelect dependencies", m.task.Name.ConfigName), DependencySelectorOptions{
@@ -420,6 +497,12 @@ func (m *TaskEditorUIModel) Render() string {
 	sb.WriteString("\n")
 	sb.WriteString(renderSection(theme, "Output mode:", utils.If(m.task.TaskDef.OutputMode != "", m.task.TaskDef.OutputMode, fmt.Sprintf("default (%s)", m.config.PreferredOutputMode)), m.focusSection == TaskConfigSectionOutputMode, m.editing))
 	sb.WriteString("\n")
+	sb.WriteString(renderSection(theme, "Retries:", utils.If(m.task.TaskDef.Retries > 0, strconv.Itoa(m.task.TaskDef.Retries), "none"), m.focusSection == TaskConfigSectionRetries, m.editing))
+	sb.WriteString("\n")
+	sb.WriteString(renderSection(theme, "Retry delay:", utils.If(m.task.TaskDef.RetryDelay != "", m.task.TaskDef.RetryDelay, "none"), m.focusSection == TaskConfigSectionRetryDelay, m.editing))
+	sb.WriteString("\n")
+	sb.WriteString(renderSection(theme, "Timeout:", utils.If(m.task.TaskDef.Timeout != "", m.task.TaskDef.Timeout, "none"), m.focusSection == TaskConfigSectionTimeout, m.editing))
+	sb.WriteString("\n")
 	sb.WriteString(renderSection(theme, "Cache:", utils.If(m.task.TaskDef.Cache != "", m.task.TaskDef.Cache, "disabled"), m.focusSection == TaskConfigSectionCache, m.editing))
 	sb.WriteString("\n")
 	cacheStrategyLabel := utils.If(m.task.TaskDef.CacheStrategy != "", m.task.TaskDef.CacheStrategy, fmt.Sprintf("default (%s)", app.CacheStrategyContent))
@@ -447,7 +530,8 @@ func (m *TaskEditorUIModel) Render() string {
 	}
 	if m.editing {
 		switch m.focusSection {
-		case TaskConfigSectionCmd, TaskConfigSectionDesc, TaskConfigSectionCacheMaxEntries, TaskConfigSectionInputs, TaskConfigSectionOutputs:
+		case TaskConfigSectionCmd, TaskConfigSectionDesc, TaskConfigSectionCacheMaxEntries, TaskConfigSectionInputs, TaskConfigSectionOutputs,
+			TaskConfigSectionRetries, TaskConfigSectionRetryDelay, TaskConfigSectionTimeout:
 			sb.WriteString(theme.FocusItemIndicator())
 		}
 	}
@@ -471,21 +555,16 @@ func (m *TaskEditorUIModel) Run() (Task, error) {
 }
 
 func NewTaskEditor(config *app.MonospaceConfig, task Task) *TaskEditorUIModel {
+	// keep settings not exposed in the editor
+	taskDef := task.TaskDef
+	taskDef.Cmd = append([]string{}, task.TaskDef.Cmd...)
+	taskDef.DependsOn = append([]string{}, task.TaskDef.DependsOn...)
+	taskDef.Inputs = append([]string{}, task.TaskDef.Inputs...)
+	taskDef.Outputs = append([]string{}, task.TaskDef.Outputs...)
 	return &TaskEditorUIModel{
 		task: Task{
-			Name: task.Name,
-			TaskDef: app.MonospaceConfigTask{
-				Description:     task.TaskDef.Description,
-				Cmd:             append([]string{}, task.TaskDef.Cmd...),
-				DependsOn:       append([]string{}, task.TaskDef.DependsOn...),
-				Persistent:      task.TaskDef.Persistent,
-				OutputMode:      task.TaskDef.OutputMode,
-				Cache:           task.TaskDef.Cache,
-				CacheStrategy:   task.TaskDef.CacheStrategy,
-				CacheMaxEntries: task.TaskDef.CacheMaxEntries,
-				Inputs:          append([]string{}, task.TaskDef.Inputs...),
-				Outputs:         append([]string{}, task.TaskDef.Outputs...),
-			},
+			Name:    task.Name,
+			TaskDef: taskDef,
 		},
 		originalTask: task,
 		focusSection: TaskConfigSectionCmd,
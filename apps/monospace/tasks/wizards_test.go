@@ -0,0 +1,44 @@
+/*
+Copyright © 2023 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2023 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package tasks
+
+import (
+	"testing"
+
+	"github.com/software-t-rex/monospace/app"
+)
+
+// testLine is a ui.MsgLine with the given value
+type testLine string
+
+func (l testLine) Value() string           { return string(l) }
+func (l testLine) Sprint() (string, error) { return string(l), nil }
+
+func TestTaskEditor_RejectsInvalidRetriesSettings(t *testing.T) {
+	sections := map[TaskEditorSection]string{
+		TaskConfigSectionRetries:    "-1",
+		TaskConfigSectionRetryDelay: "soon",
+		TaskConfigSectionTimeout:    "10 minutes",
+	}
+	for section, invalid := range sections {
+		m := NewTaskEditor(&app.MonospaceConfig{}, *NewTask("lib#test", app.MonospaceConfigTask{Retries: 1, RetryDelay: "1s", Timeout: "1m"}))
+		m.focusSection = section
+		m.startLineEdition()
+		m.Update(testLine(invalid))
+		if !m.editing || m.errorMsg == "" || m.ReadlineConfig().Value != invalid {
+			t.Errorf("%q: invalid value should be kept in edition with an error, got %+v", invalid, m)
+		}
+		if def := m.task.TaskDef; def.Retries != 1 || def.RetryDelay != "1s" || def.Timeout != "1m" {
+			t.Errorf("%q: invalid value should not be saved, got %+v", invalid, def)
+		}
+		m.Update(testLine(""))
+		if m.editing || m.errorMsg != "" {
+			t.Errorf("%q: valid value should end the edition, got %+v", invalid, m)
+		}
+	}
+}
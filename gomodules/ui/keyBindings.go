@@ -28,6 +28,8 @@ var KeyDescriptors = map[string]string{
 	"end":       "⇲",
 	"pgup":      "⇞",
 	"pgdown":    "⇟",
+	"pageup":    "⇞",
+	"pagedown":  "⇟",
 }
 
 type KeyBindings[T any] struct {
@@ -14,6 +14,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/software-t-rex/monospace/gomodules/ui/pkg/ansi"
 )
@@ -28,6 +29,11 @@ type (
 		Done        bool
 		Cleanup     bool
 		InputReader inputReader
+		// optional source of messages sent from other goroutines, they are
+		// handled as key press events are and the component is rendered again.
+		// Only used with the KeyReader, keys are then read in the background
+		// (unknown keys are ignored) until the component is done.
+		Msgs <-chan Msg
 	}
 	Model interface {
 		// set initial state of the component
@@ -91,6 +97,8 @@ func runComponent[M Model](m M) (M, error) {
 	linesPrinted := 0
 	api := m.GetComponentApi()
 	terminal := GetTerminal()
+	var keys <-chan keyEvent
+	msgs := api.Msgs
 	for !api.Done {
 		toPrint := lfToCrLf(m.Render())
 		// Update the number of lines printed
@@ -113,7 +121,36 @@ func runComponent[M Model](m M) (M, error) {
 			}
 		case KeyReader:
 			fmt.Print(toPrint)
-			msg, err = ReadKeyPressEvent(terminal)
+			if api.Msgs == nil {
+				msg, err = ReadKeyPressEvent(terminal)
+				break
+			}
+			if keys == nil {
+				// the terminal state is handled here, not by the background reader
+				restore, errState := terminal.HandleState(true)
+				if errState != nil {
+					restore()
+					return m, fmt.Errorf("runComponent %w: %w", ErrInputRead, errState)
+				}
+				stopKeys := make(chan struct{})
+				var keysDone <-chan struct{}
+				keys, keysDone = readKeysInBackground(terminal, stopKeys)
+				// the reader must be released before returning
+				defer func() {
+					close(stopKeys)
+					<-keysDone
+					restore()
+				}()
+			}
+			select {
+			case event := <-keys:
+				msg, err = event.msg, event.err
+			case received, ok := <-msgs:
+				if !ok { // closed channel, only keys are left
+					msgs = nil
+				}
+				msg = received
+			}
 		}
 		if err != nil {
 			return m, fmt.Errorf("runComponent %w: %w", ErrInputRead, err)
@@ -134,6 +171,71 @@ func runComponent[M Model](m M) (M, error) {
 	return m, nil
 }
 
+type keyEvent struct {
+	msg Msg
+	err error
+}
+
+// readKeysInBackground sends key press events on the returned channel until a
+// read error occurs or stop is closed, unknown keys are ignored. The second
+// returned channel is closed once the reader is released.
+// Keys are only read once stop is checked, so that a key pressed after stop is
+// closed is left in the terminal reader for the next component.
+func readKeysInBackground(terminal TermInterface, stop <-chan struct{}) (<-chan keyEvent, <-chan struct{}) {
+	events := make(chan keyEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			msg, err := readNextKey(terminal, stop)
+			if errors.Is(err, ErrUnknownKey) {
+				continue
+			} else if errors.Is(err, errStopped) {
+				return
+			}
+			select {
+			case events <- keyEvent{msg, err}:
+			case <-stop:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return events, done
+}
+
+var errStopped = errors.New("stopped")
+
+// time the background key reader holds the terminal reader while waiting for input
+const keyWaitInterval = 50 * time.Millisecond
+
+// readNextKey waits for input and reads a key press event unless stop was
+// closed in the meantime. The terminal reader is released between short waits
+// so that other readers are never blocked for long.
+func readNextKey(terminal TermInterface, stop <-chan struct{}) (Msg, error) {
+	for {
+		reader, releaseReader := terminal.ExclusiveReader()
+		ready, err := terminal.WaitInput(keyWaitInterval)
+		select {
+		case <-stop:
+			releaseReader()
+			return nil, errStopped
+		default:
+		}
+		if err == nil && ready {
+			msg, errRead := readKeyPressEvent(reader)
+			releaseReader()
+			return msg, errRead
+		}
+		releaseReader()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
 func EraseNLines(n int) {
 	if n > 0 {
 		fmt.Printf(ansi.CtrlHorizAbs.Sprintf(0) + ansi.CtrlUp.Sprintf(n) + ansi.CtrlEraseD.Sprintf(0))
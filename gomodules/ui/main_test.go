@@ -1,6 +1,12 @@
 package ui
 
-import "testing"
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
 
 func TestDetectCapability(t *testing.T) {
 	mockTerm := &MockTerm{isTerm: true, isDarkBg: true}
@@ -59,3 +65,106 @@ func TestEnhancedEnabled(t *testing.T) {
 		t.Errorf("Expected EnhancedEnabled() to return false, got true")
 	}
 }
+
+type msgsTestModel struct {
+	api      *ComponentApi
+	received []Msg
+}
+
+func (m *msgsTestModel) Init() Cmd                      { return nil }
+func (m *msgsTestModel) Render() string                 { return "" }
+func (m *msgsTestModel) Fallback() (Model, error)       { return m, nil }
+func (m *msgsTestModel) GetComponentApi() *ComponentApi { return m.api }
+func (m *msgsTestModel) Update(msg Msg) Cmd {
+	m.received = append(m.received, msg)
+	if key, ok := msg.(MsgKey); ok && key.Value == "q" {
+		return CmdQuit
+	}
+	return nil
+}
+
+// newPipedMockTerm returns a mock terminal reading from a pipe and the pipe
+// writer
+func newPipedMockTerm(t *testing.T) (*MockTerm, *os.File) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("waiting for input on pipes is not supported on windows")
+	}
+	rIn, wIn, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		wIn.Close()
+		rIn.Close()
+	})
+	return &MockTerm{isTerm: true, reader: bufio.NewReader(rIn), input: rIn}, wIn
+}
+
+func TestRunComponentWithMsgs(t *testing.T) {
+	terminal, wIn := newPipedMockTerm(t)
+	SetTerminal(terminal)
+	defer SetTerminal(nil)
+	msgs := make(chan Msg)
+	m := &msgsTestModel{api: &ComponentApi{Msgs: msgs}}
+	stopCapture := startCaptureStdout()
+	done := make(chan error)
+	go func() {
+		_, err := runComponent(m)
+		done <- err
+	}()
+	msgs <- "external"
+	wIn.Write([]byte("q"))
+	err := <-done
+	stopCapture()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.received) != 2 || m.received[0] != "external" || m.received[1].(MsgKey).Value != "q" {
+		t.Errorf("expected external msg then q key, got %#v", m.received)
+	}
+}
+
+func TestRunComponentWithMsgs_KeepsKeysForNextComponent(t *testing.T) {
+	terminal, wIn := newPipedMockTerm(t)
+	SetTerminal(terminal)
+	defer SetTerminal(nil)
+	msgs := make(chan Msg, 1)
+	msgs <- MsgQuit{}
+	m := &msgsTestModel{api: &ComponentApi{Msgs: msgs}}
+	stopCapture := startCaptureStdout()
+	_, err := runComponent(m)
+	stopCapture()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// give a leaked background reader the time to wait for input, the terminal
+	// reader must be released once the component is done
+	time.Sleep(50 * time.Millisecond)
+	released := make(chan struct{})
+	go func() {
+		_, release := terminal.ExclusiveReader()
+		release()
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("terminal reader still held after the component was done")
+	}
+	// the key pressed once the component is done must be left to the next reader
+	go wIn.Write([]byte("x"))
+	read := make(chan Msg)
+	go func() {
+		msg, _ := ReadKeyPressEvent(terminal)
+		read <- msg
+	}()
+	select {
+	case msg := <-read:
+		if key, ok := msg.(MsgKey); !ok || key.Value != "x" {
+			t.Errorf("expected x key to be read by the next reader, got %#v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("key pressed after the component was done was swallowed")
+	}
+}
@@ -70,6 +70,12 @@ type (
 	TTYFileDescriptor interface {
 		Tty() *os.File
 	}
+	TermWithInputWait interface {
+		// WaitInput returns true when input is available to the exclusive
+		// reader before timeout, it doesn't consume any input.
+		// You should hold the exclusive reader when calling this function.
+		WaitInput(timeout time.Duration) (bool, error)
+	}
 	TermInterface interface {
 		TermIsTerminal
 		TermWithRawMode
@@ -77,6 +83,7 @@ type (
 		TermWithExclusiveReader
 		TermWithSize
 		TTYFileDescriptor
+		TermWithInputWait
 	}
 	TermState state
 )
@@ -180,6 +187,16 @@ func (t *Terminal) ExclusiveReader() (*bufio.Reader, func()) {
 	return t.reader, func() { t.readMutex.Unlock() }
 }
 
+func (t *Terminal) WaitInput(timeout time.Duration) (bool, error) {
+	if !t.isTerm {
+		return false, fmt.Errorf("WaitInput: %w", ErrNOTERM)
+	}
+	if t.reader.Buffered() > 0 {
+		return true, nil
+	}
+	return waitForInput(t.fd, timeout)
+}
+
 func (t *Terminal) HasDarkBackground() (bool, error) {
 	if !t.isTerm {
 		return true, fmt.Errorf("HasDarkBackground: %w", ErrNOTERM)
@@ -17,6 +17,7 @@ type MockTerm struct {
 	isTerm    bool
 	isDarkBg  bool
 	reader    *bufio.Reader
+	input     *os.File // source of reader when it can be waited for
 	writer    *bufio.Writer
 	readMutex sync.Mutex
 }
@@ -47,6 +48,12 @@ func (t *MockTerm) ExclusiveReader() (*bufio.Reader, func()) {
 	t.readMutex.Lock()
 	return t.reader, func() { t.readMutex.Unlock() }
 }
+func (t *MockTerm) WaitInput(timeout time.Duration) (bool, error) {
+	if t.reader.Buffered() > 0 || t.input == nil {
+		return true, nil
+	}
+	return waitForInput(int(t.input.Fd()), timeout)
+}
 func (t *MockTerm) NewScanner() *bufio.Scanner {
 	return bufio.NewScanner(t.reader)
 }
@@ -0,0 +1,18 @@
+//go:build !unix && !windows
+
+/*
+Copyright © 2024 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2024 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package ui
+
+import "time"
+
+// waitForInput can't wait for input on this platform, reads will block until
+// input is available
+func waitForInput(fd int, timeout time.Duration) (bool, error) {
+	return true, nil
+}
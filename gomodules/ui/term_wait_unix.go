@@ -0,0 +1,29 @@
+//go:build unix
+
+/*
+Copyright © 2024 Jonathan Gotti <jgotti at jgotti dot org>
+SPDX-FileType: SOURCE
+SPDX-License-Identifier: MIT
+SPDX-FileCopyrightText: 2024 Jonathan Gotti <jgotti@jgotti.org>
+*/
+
+package ui
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// waitForInput returns true when fd is readable before timeout
+func waitForInput(fd int, timeout time.Duration) (bool, error) {
+	var fds unix.FdSet
+	fds.Set(fd)
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	n, err := unix.Select(fd+1, &fds, nil, nil, &tv)
+	if errors.Is(err, unix.EINTR) {
+		return false, nil
+	}
+	return n > 0, err
+}
@@ -12,6 +12,7 @@ package ui
 
 import (
 	"os"
+	"time"
 
 	"golang.org/x/sys/windows"
 )
@@ -47,3 +48,13 @@ func makeRaw(fd int) (*TermState, error) {
 func restore(fd int, state *TermState) error {
 	return windows.SetConsoleMode(windows.Handle(fd), state.mode)
 }
+
+// waitForInput returns true when the console has pending input events before
+// timeout
+func waitForInput(fd int, timeout time.Duration) (bool, error) {
+	event, err := windows.WaitForSingleObject(windows.Handle(fd), uint32(timeout.Milliseconds()))
+	if err != nil {
+		return false, err
+	}
+	return event == windows.WAIT_OBJECT_0, nil
+}